@@ -0,0 +1,30 @@
+package app
+
+import (
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+	"github.com/mrcode/nightscout-tray/internal/stats"
+)
+
+// GetAGP returns the ambulatory glucose profile for the last days (0 uses
+// the AGPDays setting) with 5- or 15-minute time-of-day bins
+func (s *NightscoutService) GetAGP(days int, binMinutes int) (*models.AGPData, error) {
+	settings := s.GetSettings()
+	if days <= 0 {
+		days = settings.AGPDays
+	}
+
+	to := time.Now()
+	from := to.AddDate(0, 0, -days)
+
+	entries, err := s.loadEntries(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats.ComputeAGP(entries, from, to, stats.AGPOptions{
+		BinMinutes: binMinutes,
+		Settings:   settings,
+	}), nil
+}
@@ -0,0 +1,241 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/backup"
+	"github.com/mrcode/nightscout-tray/internal/diagnostics"
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// BackupInfo describes a backup that was written or restored
+type BackupInfo struct {
+	Path         string    `json:"path"`
+	CreatedAt    time.Time `json:"createdAt"`
+	AppVersion   string    `json:"appVersion"`
+	Encrypted    bool      `json:"encrypted"`
+	Settings     bool      `json:"settings"`
+	Parameters   bool      `json:"parameters"`
+	HistoryFiles int       `json:"historyFiles"`
+}
+
+// CreateBackup writes a backup archive to the backups folder in the config
+// directory and returns its details. Credentials are only included when a
+// passphrase is given, which also encrypts the archive.
+func (s *NightscoutService) CreateBackup(passphrase string) (*BackupInfo, error) {
+	dir, err := models.GetConfigSubdir(models.BackupsDirName)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("nightscout-tray-backup-%s.zip", time.Now().Format("20060102-150405")))
+	return s.WriteBackup(path, passphrase)
+}
+
+// WriteBackup writes a backup archive with settings, learned parameters and
+// the local history to path
+func (s *NightscoutService) WriteBackup(path, passphrase string) (*BackupInfo, error) {
+	files, info, err := s.backupFiles(passphrase != "")
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	manifest, err := backup.Write(&buf, files, diagnostics.Version, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("creating backup: %w", err)
+	}
+
+	// Write to a temporary file first so a failed write never leaves a
+	// truncated archive behind
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+
+	info.Path = path
+	info.CreatedAt = manifest.CreatedAt
+	info.AppVersion = manifest.AppVersion
+	info.Encrypted = passphrase != ""
+	settingsLogger.Info("backup written", "path", path, "files", len(files), "encrypted", info.Encrypted)
+	return info, nil
+}
+
+// backupFiles collects the files that make up a backup
+func (s *NightscoutService) backupFiles(withCredentials bool) ([]backup.File, *BackupInfo, error) {
+	info := &BackupInfo{Settings: true}
+
+	settings := s.GetSettings()
+	settings.SecretsRef = ""
+	if !withCredentials {
+		settings.APISecret = ""
+		settings.APIToken = ""
+		for i := range settings.Webhooks {
+			settings.Webhooks[i].Secret = ""
+		}
+	}
+	data, err := json.MarshalIndent(&settings.SettingsFields, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	files := []backup.File{{Name: models.SettingsFileName, Data: data}}
+
+	configDir, err := models.GetConfigDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	params, err := os.ReadFile(filepath.Join(configDir, models.PredictionParamsFileName)) //nolint:gosec // Path is inside the config directory
+	if err == nil {
+		files = append(files, backup.File{Name: models.PredictionParamsFileName, Data: params})
+		info.Parameters = true
+	} else if !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("reading prediction parameters: %w", err)
+	}
+
+	if s.store != nil {
+		segments, err := s.store.Segments()
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading history: %w", err)
+		}
+		for name, data := range segments {
+			files = append(files, backup.File{Name: path.Join(models.HistoryDirName, name), Data: data})
+		}
+		info.HistoryFiles = len(segments)
+	}
+
+	return files, info, nil
+}
+
+// RestoreBackup replaces settings, learned parameters and the local history
+// with the contents of a backup archive. The archive is verified completely
+// before anything is changed. Credentials missing from an unencrypted backup
+// are kept from the current settings.
+func (s *NightscoutService) RestoreBackup(path, passphrase string) (*BackupInfo, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // Path is chosen by the user
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := backup.Read(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &BackupInfo{
+		Path:       path,
+		CreatedAt:  archive.Manifest.CreatedAt,
+		AppVersion: archive.Manifest.AppVersion,
+		Encrypted:  archive.Encrypted,
+	}
+
+	var settings *models.Settings
+	if raw, ok := archive.File(models.SettingsFileName); ok {
+		if settings, err = models.ParseSettings(raw); err != nil {
+			return nil, fmt.Errorf("reading settings from backup: %w", err)
+		}
+		current := s.GetSettings()
+		if settings.APISecret == "" && settings.APIToken == "" {
+			settings.APISecret = current.APISecret
+			settings.APIToken = current.APIToken
+		}
+		restoreWebhookSecrets(settings.Webhooks, current.Webhooks)
+		settings.Normalize()
+		if err := validateSettings(settings); err != nil {
+			return nil, err
+		}
+		info.Settings = true
+	}
+
+	historyPrefix := models.HistoryDirName + "/"
+	segments := make(map[string][]byte)
+	for _, name := range archive.Names(historyPrefix) {
+		content, _ := archive.File(name)
+		segments[strings.TrimPrefix(name, historyPrefix)] = content
+	}
+	info.HistoryFiles = len(segments)
+
+	// Background tasks would write to the files being replaced
+	wasRunning := s.IsRunning()
+	s.Stop()
+	defer func() {
+		if wasRunning {
+			s.Restart()
+		}
+	}()
+
+	if params, ok := archive.File(models.PredictionParamsFileName); ok {
+		if err := s.restoreParameters(params); err != nil {
+			return nil, err
+		}
+		info.Parameters = true
+	}
+
+	if s.store != nil {
+		if err := s.store.Replace(segments); err != nil {
+			return nil, fmt.Errorf("restoring history: %w", err)
+		}
+	}
+
+	if settings != nil {
+		if err := s.applySettings(settings); err != nil {
+			return nil, fmt.Errorf("restoring settings: %w", err)
+		}
+	}
+
+	settingsLogger.Info("backup restored", "path", path, "created", info.CreatedAt, "historyFiles", info.HistoryFiles)
+	return info, nil
+}
+
+// restoreParameters writes learned parameters and reloads them if the
+// prediction service is running
+func (s *NightscoutService) restoreParameters(params []byte) error {
+	if !json.Valid(params) {
+		return fmt.Errorf("prediction parameters in backup are not valid JSON")
+	}
+
+	configDir, err := models.GetConfigDir()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(configDir, models.PredictionParamsFileName), params, 0600); err != nil {
+		return fmt.Errorf("restoring prediction parameters: %w", err)
+	}
+
+	s.mu.RLock()
+	predSvc := s.predService
+	s.mu.RUnlock()
+
+	if predSvc != nil {
+		if err := predSvc.ReloadParameters(); err != nil {
+			return fmt.Errorf("loading restored prediction parameters: %w", err)
+		}
+	}
+	return nil
+}
+
+// restoreWebhookSecrets keeps the current signing secret of webhooks that
+// come without one, as in backups made without a passphrase
+func restoreWebhookSecrets(restored, current []models.WebhookTarget) {
+	for i := range restored {
+		if restored[i].Secret != "" {
+			continue
+		}
+		for _, c := range current {
+			if c.ID == restored[i].ID {
+				restored[i].Secret = c.Secret
+				break
+			}
+		}
+	}
+}
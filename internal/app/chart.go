@@ -0,0 +1,305 @@
+package app
+
+import (
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/chart"
+	"github.com/mrcode/nightscout-tray/internal/models"
+	"github.com/mrcode/nightscout-tray/internal/prediction"
+)
+
+const (
+	// chartPrecomputeDays is how much history gets its aggregates computed
+	// after a history sync, so the longest chart ranges open without a delay
+	chartPrecomputeDays = 90
+
+	// profileCacheTTL is how long fetched profiles are used for basal steps
+	// before they are read again
+	profileCacheTTL = time.Hour
+)
+
+// GetChartData returns the chart for the hours ending offsetHours ago. The
+// resolution follows the range: readings up to a week (downsampled beyond
+// chart.MaxPoints), hourly aggregates up to 45 days, daily ones beyond.
+func (s *NightscoutService) GetChartData(hours int, offsetHours int) (*models.ChartData, error) {
+	settings := s.GetSettings()
+
+	now := time.Now()
+	to := now.Add(-time.Duration(offsetHours) * time.Hour)
+	from := to.Add(-time.Duration(hours) * time.Hour)
+
+	data := &models.ChartData{
+		TargetLow:  settings.TargetLow,
+		TargetHigh: settings.TargetHigh,
+		UrgentLow:  settings.UrgentLow,
+		UrgentHigh: settings.UrgentHigh,
+		TimeRangeH: hours,
+		Unit:       settings.Unit,
+		Resolution: chart.SelectResolution(to.Sub(from)),
+	}
+
+	if data.Resolution != chart.ResolutionRaw {
+		buckets, err := s.chartBuckets(data.Resolution, from, to)
+		if err != nil {
+			return nil, err
+		}
+		fillBuckets(data, buckets, from, to, settings)
+		s.addOverlays(data, from, to, settings, offsetHours == 0)
+		return data, nil
+	}
+
+	entries, err := s.loadEntries(from, to)
+	if err != nil {
+		return nil, err
+	}
+	data.SourceCount = len(entries)
+	data.Entries = chartEntries(entries, settings)
+	if len(data.Entries) > chart.MaxPoints {
+		data.Entries = chart.Downsample(data.Entries, chart.MaxPoints)
+		data.Resolution = chart.ResolutionDownsampled
+	}
+	s.addOverlays(data, from, to, settings, offsetHours == 0)
+	return data, nil
+}
+
+// addOverlays adds what is drawn over the glucose curve: treatment markers,
+// insulin and carbs on board at each entry, basal steps and, when the range
+// ends now, the prediction. Overlays that fail to load are left out rather
+// than failing the whole chart.
+func (s *NightscoutService) addOverlays(data *models.ChartData, from, to time.Time, settings *models.Settings, live bool) {
+	s.mu.RLock()
+	predSvc := s.predService
+	s.mu.RUnlock()
+
+	aggregated := data.BucketMinutes > 0
+	treatments, err := s.loadTreatments(from.Add(-prediction.OnBoardWindow), to)
+	if err != nil {
+		historyLogger.Debug("chart treatments unavailable", "error", err)
+	} else {
+		data.Markers = chart.Markers(treatments, from, to, settings.Unit, !aggregated)
+		if !aggregated {
+			data.Basal = chart.BasalSteps(treatments, s.chartProfiles(), from, to, time.Local)
+		}
+		if predSvc != nil && len(data.Entries) > 0 {
+			times := make([]time.Time, len(data.Entries))
+			for i, e := range data.Entries {
+				times[i] = time.UnixMilli(e.Time)
+			}
+			iob, cob := predSvc.OnBoard(treatments, times)
+			data.IOB = make([]models.ChartSeriesPoint, len(times))
+			data.COB = make([]models.ChartSeriesPoint, len(times))
+			for i, e := range data.Entries {
+				data.IOB[i] = models.ChartSeriesPoint{Time: e.Time, Value: iob[i]}
+				data.COB[i] = models.ChartSeriesPoint{Time: e.Time, Value: cob[i]}
+			}
+		}
+	}
+
+	if live && predSvc != nil && len(data.Entries) > 0 {
+		result, err := predSvc.GetPrediction()
+		if err != nil {
+			historyLogger.Debug("chart prediction unavailable", "error", err)
+			return
+		}
+		data.Prediction = chartPrediction(result, settings)
+	}
+}
+
+// chartPrediction merges the short- and long-term predictions into one
+// curve in the display unit
+func chartPrediction(result *models.PredictionResult, settings *models.Settings) []models.ChartPrediction {
+	out := make([]models.ChartPrediction, 0, len(result.ShortTerm)+len(result.LongTerm))
+	var last int64
+	add := func(points []models.PredictedPoint, longTerm bool) {
+		for _, p := range points {
+			if p.Time <= last {
+				continue
+			}
+			value := p.Value
+			if settings.Unit == unitMmolL {
+				value = models.ToMmol(value)
+			}
+			out = append(out, models.ChartPrediction{
+				Time:       p.Time,
+				Value:      value,
+				ValueMg:    p.Value,
+				Confidence: p.Confidence,
+				LongTerm:   longTerm,
+			})
+			last = p.Time
+		}
+	}
+	add(result.ShortTerm, false)
+	add(result.LongTerm, true)
+	return out
+}
+
+// chartProfiles returns the Nightscout profiles for basal steps, read at
+// most once per profileCacheTTL
+func (s *NightscoutService) chartProfiles() []models.ProfileStore {
+	s.mu.RLock()
+	client := s.client
+	profiles, fetched := s.profiles, s.profilesFetched
+	s.mu.RUnlock()
+
+	if client == nil || time.Since(fetched) < profileCacheTTL {
+		return profiles
+	}
+
+	fresh, err := client.GetProfiles()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A failing server is asked again only after the TTL as well
+	s.profilesFetched = time.Now()
+	if err != nil {
+		historyLogger.Debug("profiles unavailable", "error", err)
+		return profiles
+	}
+	s.profiles = fresh
+	return fresh
+}
+
+// chartBuckets returns the aggregates covering [from, to). Completed
+// buckets come from the cache; readings are loaded only from the first
+// bucket that isn't cached on.
+func (s *NightscoutService) chartBuckets(resolution string, from, to time.Time) ([]chart.Bucket, error) {
+	spans := chart.Spans(resolution, from, to, time.Local)
+	buckets := make([]chart.Bucket, len(spans))
+
+	first := len(spans)
+	for i, span := range spans {
+		b, ok := s.chartCache.Get(resolution, span.Start)
+		if !ok {
+			first = i
+			break
+		}
+		buckets[i] = b
+	}
+	if first == len(spans) {
+		return buckets, nil
+	}
+
+	entries, err := s.loadEntries(spans[first].Start, to)
+	if err != nil {
+		return nil, err
+	}
+
+	settled := time.Now().Add(-chart.SettleDelay)
+	for i := first; i < len(spans); i++ {
+		if b, ok := s.chartCache.Get(resolution, spans[i].Start); ok {
+			buckets[i] = b
+			continue
+		}
+		buckets[i] = chart.Aggregate(entries, spans[i].Start, spans[i].End)
+		if !spans[i].End.After(settled) {
+			s.chartCache.Put(resolution, buckets[i])
+		}
+	}
+	return buckets, nil
+}
+
+// fillBuckets converts aggregates to the display unit. Each bucket also
+// becomes an entry at the middle of its covered time, so charts that only
+// draw entries keep working.
+func fillBuckets(data *models.ChartData, buckets []chart.Bucket, from, to time.Time, settings *models.Settings) {
+	data.BucketMinutes = chart.BucketMinutes(data.Resolution)
+	convert := func(v float64) float64 {
+		if settings.Unit == unitMmolL {
+			return models.ToMmol(v)
+		}
+		return v
+	}
+
+	for _, b := range buckets {
+		if b.Count == 0 {
+			continue
+		}
+		data.SourceCount += b.Count
+		data.Buckets = append(data.Buckets, models.ChartBucket{
+			Start:  b.Start.UnixMilli(),
+			End:    b.End.UnixMilli(),
+			Count:  b.Count,
+			Min:    convert(b.Min),
+			Max:    convert(b.Max),
+			Mean:   convert(b.Mean),
+			P10:    convert(b.P10),
+			P25:    convert(b.P25),
+			Median: convert(b.Median),
+			P75:    convert(b.P75),
+			P90:    convert(b.P90),
+		})
+
+		start, end := b.Start, b.End
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		mean := int(b.Mean + 0.5)
+		data.Entries = append(data.Entries, models.ChartEntry{
+			Time:    start.Add(end.Sub(start) / 2).UnixMilli(),
+			Value:   convert(b.Mean),
+			ValueMg: mean,
+			Status:  settings.GetGlucoseStatus(mean),
+		})
+	}
+}
+
+// chartEntries converts readings to chart points in the display unit
+func chartEntries(entries []models.GlucoseEntry, settings *models.Settings) []models.ChartEntry {
+	out := make([]models.ChartEntry, len(entries))
+	useMmol := settings.Unit == unitMmolL
+
+	for i, entry := range entries {
+		value := float64(entry.SGV)
+		if useMmol {
+			value = entry.ValueMmolL()
+		}
+
+		out[i] = models.ChartEntry{
+			Time:    entry.Date,
+			Value:   value,
+			ValueMg: entry.SGV,
+			Status:  settings.GetGlucoseStatus(entry.SGV),
+		}
+	}
+	return out
+}
+
+// invalidateCharts drops cached aggregates that newly stored readings fall into
+func (s *NightscoutService) invalidateCharts(entries []models.GlucoseEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	earliest := entries[0].Date
+	for _, e := range entries[1:] {
+		earliest = min(earliest, e.Date)
+	}
+	s.chartCache.Invalidate(time.UnixMilli(earliest))
+}
+
+// precomputeCharts fills the aggregate cache from the local history, so
+// long chart ranges don't have to aggregate weeks of readings when opened
+func (s *NightscoutService) precomputeCharts() {
+	if s.store == nil {
+		return
+	}
+	to := time.Now()
+	from := to.AddDate(0, 0, -chartPrecomputeDays)
+	// Only stored history, and only whole days of it: older readings would
+	// have to come from the server
+	earliest := s.store.Stats().Entries.Earliest
+	if earliest.IsZero() {
+		return
+	}
+	if from.Before(earliest) {
+		from = earliest.AddDate(0, 0, 1)
+	}
+	for _, resolution := range []string{chart.ResolutionHourly, chart.ResolutionDaily} {
+		if _, err := s.chartBuckets(resolution, from, to); err != nil {
+			historyLogger.Debug("precomputing chart aggregates failed", "resolution", resolution, "error", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/chart"
+	"github.com/mrcode/nightscout-tray/internal/models"
+	"github.com/mrcode/nightscout-tray/internal/stats"
+)
+
+const (
+	// overlayWeeks is how many previous weeks the weekday overlay goes back
+	overlayWeeks = 4
+
+	// maxOverlayDays bounds the dates one overlay may hold
+	maxOverlayDays = 14
+
+	// maxTracePoints is the most readings kept per overlaid day; one-minute
+	// sensors are downsampled to it
+	maxTracePoints = 480
+)
+
+// GetDayOverlay returns days of readings aligned on time of day: today and
+// yesterday (models.OverlayYesterday), today and the same weekday in the
+// previous weeks (models.OverlayWeekday), or the given YYYY-MM-DD dates
+// (models.OverlayDates).
+func (s *NightscoutService) GetDayOverlay(mode string, dates []string) (*models.DayOverlay, error) {
+	settings := s.GetSettings()
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+
+	var days []time.Time
+	switch mode {
+	case models.OverlayYesterday:
+		days = []time.Time{today, today.AddDate(0, 0, -1)}
+	case models.OverlayWeekday:
+		for week := 0; week <= overlayWeeks; week++ {
+			days = append(days, today.AddDate(0, 0, -7*week))
+		}
+	case models.OverlayDates:
+		if len(dates) == 0 || len(dates) > maxOverlayDays {
+			return nil, fmt.Errorf("between 1 and %d dates are needed, got %d", maxOverlayDays, len(dates))
+		}
+		for _, d := range dates {
+			day, err := time.ParseInLocation("2006-01-02", d, time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("date %q: %w", d, err)
+			}
+			days = append(days, day)
+		}
+	default:
+		return nil, fmt.Errorf("unknown overlay mode %q", mode)
+	}
+
+	overlay := &models.DayOverlay{
+		Mode:       mode,
+		Unit:       settings.Unit,
+		TargetLow:  settings.TargetLow,
+		TargetHigh: settings.TargetHigh,
+		UrgentLow:  settings.UrgentLow,
+		UrgentHigh: settings.UrgentHigh,
+	}
+	for _, day := range days {
+		trace, err := s.dayTrace(day, today, settings)
+		if err != nil {
+			return nil, err
+		}
+		overlay.Days = append(overlay.Days, *trace)
+	}
+	return overlay, nil
+}
+
+// dayTrace loads the readings of the local day starting at midnight
+func (s *NightscoutService) dayTrace(midnight, today time.Time, settings *models.Settings) (*models.DayTrace, error) {
+	end := time.Date(midnight.Year(), midnight.Month(), midnight.Day()+1, 0, 0, 0, 0, time.Local)
+	entries, err := s.loadEntries(midnight, end.Add(-time.Millisecond))
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", midnight.Format("2006-01-02"), err)
+	}
+
+	trace := &models.DayTrace{
+		Date:  midnight.Format("2006-01-02"),
+		Label: dayLabel(midnight, today),
+	}
+
+	st := stats.Compute(entries, midnight, end.Add(-time.Millisecond), stats.ThresholdsFromSettings(settings))
+	trace.Readings = st.Readings
+	trace.Mean = st.Mean
+	if settings.Unit == unitMmolL {
+		trace.Mean = models.ToMmol(trace.Mean)
+	}
+	trace.TIR = st.Ranges.TIR
+	trace.TBR = st.Ranges.TBRLevel1 + st.Ranges.TBRLevel2
+	trace.TAR = st.Ranges.TARLevel1 + st.Ranges.TARLevel2
+
+	points := chart.Downsample(chartEntries(entries, settings), maxTracePoints)
+	trace.Points = make([]models.DayTracePoint, len(points))
+	for i, p := range points {
+		local := time.UnixMilli(p.Time).In(time.Local)
+		trace.Points[i] = models.DayTracePoint{
+			Minute:  float64(local.Hour()*60+local.Minute()) + float64(local.Second())/60,
+			Time:    p.Time,
+			Value:   p.Value,
+			ValueMg: p.ValueMg,
+		}
+	}
+	return trace, nil
+}
+
+func dayLabel(day, today time.Time) string {
+	switch {
+	case day.Equal(today):
+		return "Today"
+	case day.Equal(today.AddDate(0, 0, -1)):
+		return "Yesterday"
+	default:
+		return day.Format("Mon 2 Jan 2006")
+	}
+}
+
+// ComparePeriods computes statistics for [aFrom, aTo] and [bFrom, bTo] side
+// by side, with the differences and hints on whether they exceed
+// day-to-day variation
+func (s *NightscoutService) ComparePeriods(aFrom, aTo, bFrom, bTo time.Time) (*stats.Comparison, error) {
+	if !aTo.After(aFrom) || !bTo.After(bFrom) {
+		return nil, fmt.Errorf("invalid periods: each must end after it starts")
+	}
+
+	a, err := s.loadEntries(aFrom, aTo)
+	if err != nil {
+		return nil, fmt.Errorf("loading first period: %w", err)
+	}
+	b, err := s.loadEntries(bFrom, bTo)
+	if err != nil {
+		return nil, fmt.Errorf("loading second period: %w", err)
+	}
+
+	settings := s.GetSettings()
+	return stats.Compare(a, b, aFrom, aTo, bFrom, bTo, stats.CompareOptions{
+		Thresholds: stats.ThresholdsFromSettings(settings),
+		Unit:       settings.Unit,
+	}), nil
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/devices"
+	"github.com/mrcode/nightscout-tray/internal/models"
+	"github.com/mrcode/nightscout-tray/internal/nightscout"
+)
+
+const (
+	// deviceRefreshInterval is how often the latest device changes are read.
+	// Synced or imported change events refresh them sooner.
+	deviceRefreshInterval = 15 * time.Minute
+
+	// deviceChangeLookback is how far back changes are searched, on the
+	// server and in the local history, which also holds imported ones
+	deviceChangeLookback = 60 * 24 * time.Hour
+)
+
+// GetDeviceAges returns how long the sensor, infusion site, insulin and pump
+// battery have been in use, from their last logged change
+func (s *NightscoutService) GetDeviceAges() []models.DeviceAge {
+	return s.deviceAges(time.Now())
+}
+
+// deviceAges returns the device ages at now from the cached changes, with
+// the configured thresholds
+func (s *NightscoutService) deviceAges(now time.Time) []models.DeviceAge {
+	s.mu.RLock()
+	changes := s.deviceChangeEvents
+	thresholds := devices.ThresholdsFromSettings(s.settings)
+	s.mu.RUnlock()
+
+	return devices.Ages(changes, now, thresholds)
+}
+
+// sensorWarmingUp reports whether a reading at t was taken during the
+// configured warm-up after the last sensor change
+func (s *NightscoutService) sensorWarmingUp(ages []models.DeviceAge, t time.Time) bool {
+	s.mu.RLock()
+	warmUp := time.Duration(s.settings.SensorWarmUpMinutes) * time.Minute
+	s.mu.RUnlock()
+
+	return devices.WarmingUp(ages, t, warmUp)
+}
+
+// runDeviceRefresh keeps the latest device changes up to date until ctx is
+// cancelled, so reading a status never waits for the server. Refreshing
+// pauses in push-only mode to save power.
+func (s *NightscoutService) runDeviceRefresh(ctx context.Context) {
+	for {
+		s.mu.RLock()
+		pushOnly := s.pollMode == models.PollModePushOnly
+		s.mu.RUnlock()
+
+		if !pushOnly {
+			s.refreshDeviceChanges()
+		}
+
+		select {
+		case <-time.After(deviceRefreshInterval):
+		case <-s.deviceRefreshCh:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refreshDeviceChanges reads the latest change event of each device from the
+// server and the local history. If neither is available the previous
+// changes are kept.
+func (s *NightscoutService) refreshDeviceChanges() {
+	s.mu.RLock()
+	client := s.client
+	s.mu.RUnlock()
+
+	now := time.Now()
+	candidates, err := fetchDeviceChanges(client, now.Add(-deviceChangeLookback))
+	if err != nil {
+		historyLogger.Debug("device changes unavailable from server", "error", err)
+		if s.store == nil {
+			return
+		}
+	}
+	if s.store != nil {
+		candidates = append(candidates, s.store.Treatments(now.Add(-deviceChangeLookback), now)...)
+	}
+
+	changes := devices.Latest(candidates)
+	s.mu.Lock()
+	s.deviceChangeEvents = changes
+	s.mu.Unlock()
+}
+
+// fetchDeviceChanges asks the server for the newest event of each change
+// type since from
+func fetchDeviceChanges(client *nightscout.Client, from time.Time) ([]models.Treatment, error) {
+	if client == nil {
+		return nil, fmt.Errorf("not configured")
+	}
+
+	var latest []models.Treatment
+	for _, d := range devices.Tracked {
+		for _, eventType := range d.EventTypes {
+			t, err := client.GetLatestTreatment(eventType, from)
+			if err != nil {
+				return latest, fmt.Errorf("fetching %s: %w", eventType, err)
+			}
+			if t != nil {
+				latest = append(latest, *t)
+			}
+		}
+	}
+	return latest, nil
+}
+
+// invalidateDeviceChanges asks the refresh task to read device changes
+// again if treatments hold one
+func (s *NightscoutService) invalidateDeviceChanges(treatments []models.Treatment) {
+	for i := range treatments {
+		if devices.IsChange(&treatments[i]) {
+			select {
+			case s.deviceRefreshCh <- struct{}{}:
+			default:
+			}
+			return
+		}
+	}
+}
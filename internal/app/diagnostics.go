@@ -0,0 +1,109 @@
+package app
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/diagnostics"
+	"github.com/mrcode/nightscout-tray/internal/history"
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// ConnectionReport summarizes the connection state for diagnostic bundles
+type ConnectionReport struct {
+	Configured        bool           `json:"configured"`
+	Reachable         bool           `json:"reachable"`
+	Latency           string         `json:"latency,omitempty"`
+	Error             string         `json:"error,omitempty"`
+	Server            *ServerSummary `json:"server,omitempty"`
+	LastSuccess       time.Time      `json:"lastSuccess"`
+	ConsecutiveErrors int            `json:"consecutiveErrors"`
+	LastReading       time.Time      `json:"lastReading"`
+	Running           bool           `json:"running"`
+	Power             PowerStatus    `json:"power"`
+	History           *history.Stats `json:"history,omitempty"`
+	CheckedAt         time.Time      `json:"checkedAt"`
+}
+
+// ServerSummary is the part of the Nightscout status useful for support
+type ServerSummary struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Units   string `json:"units"`
+}
+
+// CheckConnection queries the server status and reports the connection state
+func (s *NightscoutService) CheckConnection() *ConnectionReport {
+	s.mu.RLock()
+	client := s.client
+	report := &ConnectionReport{
+		Configured:        client != nil,
+		LastSuccess:       s.lastSuccessTime,
+		ConsecutiveErrors: s.consecutiveErrors,
+		Power:             PowerStatus{State: s.powerState, Mode: s.pollMode},
+		CheckedAt:         time.Now(),
+	}
+	if s.lastStatus != nil {
+		report.LastReading = s.lastStatus.Time
+	}
+	s.mu.RUnlock()
+
+	report.Running = s.IsRunning()
+	if s.store != nil {
+		stats := s.store.Stats()
+		report.History = &stats
+	}
+
+	if client == nil {
+		report.Error = "not configured"
+		return report
+	}
+
+	start := time.Now()
+	status, err := client.GetStatus()
+	report.Latency = time.Since(start).Round(time.Millisecond).String()
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	report.Reachable = true
+	report.Server = &ServerSummary{
+		Name:    status.Name,
+		Version: status.Version,
+		Units:   status.Settings.Units,
+	}
+	return report
+}
+
+// CreateDiagnosticBundle writes a zip with recent logs, sanitized settings,
+// version information and a connection check to the config directory and
+// returns its path
+func (s *NightscoutService) CreateDiagnosticBundle() (string, error) {
+	configDir, err := models.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(configDir, models.DiagnosticsDirName,
+		fmt.Sprintf("nightscout-tray-diagnostics-%s.zip", time.Now().Format("20060102-150405")))
+	if err := s.WriteDiagnosticBundle(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// WriteDiagnosticBundle writes a diagnostic bundle to path
+func (s *NightscoutService) WriteDiagnosticBundle(path string) error {
+	configDir, err := models.GetConfigDir()
+	if err != nil {
+		return err
+	}
+
+	return diagnostics.WriteFile(path, diagnostics.Bundle{
+		LogDir:     filepath.Join(configDir, models.LogsDirName),
+		Settings:   s.GetSettings(),
+		Connection: s.CheckConnection(),
+	})
+}
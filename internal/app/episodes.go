@@ -0,0 +1,40 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+	"github.com/mrcode/nightscout-tray/internal/stats"
+)
+
+// GetEpisodes detects low and high episodes between from and to, with the
+// treatments logged around them and counts by day and night
+func (s *NightscoutService) GetEpisodes(from, to time.Time) (*models.EpisodeReport, error) {
+	if !to.After(from) {
+		return nil, fmt.Errorf("invalid time window: %s to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	entries, err := s.loadEntries(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// Treatments shortly after the window may belong to an episode at its end
+	treatments, err := s.loadTreatments(from, to.Add(time.Hour))
+	if err != nil {
+		return nil, err
+	}
+
+	return stats.DetectEpisodes(entries, treatments, from, to, stats.EpisodeOptions{
+		Settings: s.GetSettings(),
+	}), nil
+}
+
+// GetChartEpisodes returns the episodes in the window shown by GetChartData
+// so the chart can shade them
+func (s *NightscoutService) GetChartEpisodes(hours int, offsetHours int) (*models.EpisodeReport, error) {
+	to := time.Now().Add(-time.Duration(offsetHours) * time.Hour)
+	from := to.Add(-time.Duration(hours) * time.Hour)
+	return s.GetEpisodes(from, to)
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/export"
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// ExportFile is the result of an export written to disk
+type ExportFile struct {
+	Path string `json:"path"`
+	export.Summary
+}
+
+// ExportFormats returns the formats ExportData accepts
+func (s *NightscoutService) ExportFormats() []string {
+	return export.Formats
+}
+
+// ExportData writes entries and treatments in [from, to] to the exports
+// folder in the config directory. format is one of ExportFormats.
+func (s *NightscoutService) ExportData(format string, from, to time.Time) (*ExportFile, error) {
+	dir, err := models.GetConfigSubdir(models.ExportsDirName)
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("nightscout-%s-%s%s",
+		from.Local().Format("20060102"), to.Local().Format("20060102"), export.FileExtension(format))
+	return s.ExportToFile(filepath.Join(dir, name), format, from, to)
+}
+
+// ExportToFile writes entries and treatments in [from, to] to path. Records
+// are streamed in windows, so long ranges don't need to fit in memory.
+func (s *NightscoutService) ExportToFile(path, format string, from, to time.Time) (*ExportFile, error) {
+	if !export.ValidFormat(format) {
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+
+	// Write to a temporary file first so a failed export never leaves a
+	// truncated file behind
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp) //nolint:gosec // Path is chosen by the user
+	if err != nil {
+		return nil, err
+	}
+
+	opts := export.Options{Format: format, Unit: s.GetSettings().Unit}
+	if format == export.FormatTidepool {
+		// Without profiles the export still has readings, boluses and temp
+		// basals, just no pump settings or scheduled basal
+		if opts.Profiles, err = s.GetProfiles(); err != nil {
+			historyLogger.Warn("exporting without profiles", "error", err)
+		}
+	}
+
+	summary, err := export.Export(f, historySource{s}, from, to, opts)
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return nil, fmt.Errorf("exporting %s: %w", format, err)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+
+	historyLogger.Info("data exported", "path", path, "format", format,
+		"entries", summary.Entries, "treatments", summary.Treatments)
+	return &ExportFile{Path: path, Summary: *summary}, nil
+}
+
+// GetProfiles returns the Nightscout profile documents, oldest first
+func (s *NightscoutService) GetProfiles() ([]models.ProfileStore, error) {
+	s.mu.RLock()
+	client := s.client
+	s.mu.RUnlock()
+
+	if client == nil {
+		return nil, fmt.Errorf("not configured")
+	}
+	return client.GetProfiles()
+}
@@ -0,0 +1,227 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/importer"
+	"github.com/mrcode/nightscout-tray/internal/models"
+	"github.com/mrcode/nightscout-tray/internal/nightscout"
+)
+
+const (
+	// uploadWindow is how much server data is compared at a time when
+	// de-duplicating an upload
+	uploadWindow = 30 * 24 * time.Hour
+
+	// uploadEntryTolerance treats a server reading this close to an imported
+	// one as the same reading, e.g. when the server got it from another uploader
+	uploadEntryTolerance = 150 * time.Second
+)
+
+// ImportResult describes an imported file
+type ImportResult struct {
+	importer.Result
+	Path               string `json:"path"`
+	EntriesAdded       int    `json:"entriesAdded"`       // Readings new to the local history
+	TreatmentsAdded    int    `json:"treatmentsAdded"`    // Treatments new to the local history
+	BeyondRetention    int    `json:"beyondRetention"`    // Records older than the history retention, not stored
+	Uploaded           bool   `json:"uploaded"`           // Whether an upload was attempted and finished
+	EntriesUploaded    int    `json:"entriesUploaded"`    // Readings sent to Nightscout
+	TreatmentsUploaded int    `json:"treatmentsUploaded"` // Treatments sent to Nightscout
+	AlreadyOnServer    int    `json:"alreadyOnServer"`    // Records skipped because Nightscout had them
+}
+
+// ImportFormats returns the formats ImportHistory accepts
+func (s *NightscoutService) ImportFormats() []string {
+	return importer.Formats
+}
+
+// ImportHistory reads a Dexcom Clarity or LibreView CSV export into the
+// local history. timezone is the IANA zone the device clock was set to
+// (empty for the system zone). With upload, records Nightscout doesn't
+// have yet are sent to the server as well.
+func (s *NightscoutService) ImportHistory(path, format, timezone string, upload bool) (*ImportResult, error) {
+	if s.store == nil {
+		return nil, fmt.Errorf("local history is not available")
+	}
+
+	loc := time.Local
+	if timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("time zone %q: %w", timezone, err)
+		}
+	}
+
+	f, err := os.Open(path) //nolint:gosec // Path is chosen by the user
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := importer.Parse(f, importer.Options{Format: format, Location: loc})
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	result := &ImportResult{Result: *parsed, Path: path}
+
+	s.mu.RLock()
+	retention := s.historyRetention()
+	client := s.client
+	s.mu.RUnlock()
+
+	if upload && client == nil {
+		return nil, fmt.Errorf("not configured: set a Nightscout URL in the settings to upload")
+	}
+
+	// Compaction would drop anything older than the retention period again
+	entries, treatments := parsed.Entries, parsed.Treatments
+	if retention > 0 {
+		cutoff := time.Now().Add(-retention)
+		entries = entriesSince(entries, cutoff)
+		treatments = treatmentsSince(treatments, cutoff)
+		result.BeyondRetention = len(parsed.Entries) - len(entries) + len(parsed.Treatments) - len(treatments)
+	}
+
+	if result.EntriesAdded, err = s.store.AddEntries(entries); err != nil {
+		return nil, fmt.Errorf("storing entries: %w", err)
+	}
+	if result.EntriesAdded > 0 {
+		s.invalidateCharts(entries)
+	}
+	if result.TreatmentsAdded, err = s.store.AddTreatments(treatments); err != nil {
+		return nil, fmt.Errorf("storing treatments: %w", err)
+	}
+	if result.TreatmentsAdded > 0 {
+		s.invalidateDeviceChanges(treatments)
+	}
+	historyLogger.Info("history imported", "path", path, "format", parsed.Format,
+		"entries", result.EntriesAdded, "treatments", result.TreatmentsAdded, "beyondRetention", result.BeyondRetention)
+
+	if upload {
+		if err := uploadImported(client, parsed, result); err != nil {
+			return result, fmt.Errorf("imported locally, but uploading to Nightscout failed: %w", err)
+		}
+		result.Uploaded = true
+	}
+	return result, nil
+}
+
+// uploadImported sends imported records to Nightscout, leaving out those the
+// server already has. Server data is compared a window at a time so years
+// of history never have to be held at once.
+func uploadImported(client *nightscout.Client, parsed *importer.Result, result *ImportResult) error {
+	if parsed.From.IsZero() {
+		return nil
+	}
+
+	for start := parsed.From; !start.After(parsed.To); start = start.Add(uploadWindow) {
+		end := start.Add(uploadWindow)
+
+		entries := entriesBetween(parsed.Entries, start, end)
+		if len(entries) > 0 {
+			existing, err := client.GetEntries(start.Add(-uploadEntryTolerance), end.Add(uploadEntryTolerance), 0)
+			if err != nil {
+				return fmt.Errorf("reading server entries: %w", err)
+			}
+			fresh := newEntries(entries, existing)
+			if err := client.UploadEntries(fresh); err != nil {
+				return err
+			}
+			result.EntriesUploaded += len(fresh)
+			result.AlreadyOnServer += len(entries) - len(fresh)
+		}
+
+		treatments := treatmentsBetween(parsed.Treatments, start, end)
+		if len(treatments) > 0 {
+			existing, err := client.GetTreatments(start.Add(-time.Minute), end.Add(time.Minute), 0)
+			if err != nil {
+				return fmt.Errorf("reading server treatments: %w", err)
+			}
+			fresh := newTreatments(treatments, existing)
+			if err := client.UploadTreatments(fresh); err != nil {
+				return err
+			}
+			result.TreatmentsUploaded += len(fresh)
+			result.AlreadyOnServer += len(treatments) - len(fresh)
+		}
+	}
+
+	historyLogger.Info("imported history uploaded", "entries", result.EntriesUploaded,
+		"treatments", result.TreatmentsUploaded, "alreadyOnServer", result.AlreadyOnServer)
+	return nil
+}
+
+// newEntries returns the imported readings with no server reading nearby
+func newEntries(imported, existing []models.GlucoseEntry) []models.GlucoseEntry {
+	times := make([]int64, len(existing))
+	for i, e := range existing {
+		times[i] = e.Date
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	tolerance := uploadEntryTolerance.Milliseconds()
+	var out []models.GlucoseEntry
+	for _, e := range imported {
+		i := sort.Search(len(times), func(i int) bool { return times[i] >= e.Date-tolerance })
+		if i < len(times) && times[i] <= e.Date+tolerance {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// newTreatments returns the imported treatments the server doesn't have,
+// matching by id or by event type and minute
+func newTreatments(imported, existing []models.Treatment) []models.Treatment {
+	known := make(map[string]bool, 2*len(existing))
+	for i := range existing {
+		t := &existing[i]
+		if t.ID != "" {
+			known[t.ID] = true
+		}
+		known[treatmentMinuteKey(t)] = true
+	}
+
+	var out []models.Treatment
+	for i := range imported {
+		t := &imported[i]
+		if known[t.ID] || known[treatmentMinuteKey(t)] {
+			continue
+		}
+		out = append(out, *t)
+	}
+	return out
+}
+
+func treatmentMinuteKey(t *models.Treatment) string {
+	return t.EventType + "@" + strconv.FormatInt(t.Time().Unix()/60, 10)
+}
+
+func entriesSince(entries []models.GlucoseEntry, cutoff time.Time) []models.GlucoseEntry {
+	i := sort.Search(len(entries), func(i int) bool { return !entries[i].Time().Before(cutoff) })
+	return entries[i:]
+}
+
+func treatmentsSince(treatments []models.Treatment, cutoff time.Time) []models.Treatment {
+	i := sort.Search(len(treatments), func(i int) bool { return !treatments[i].Time().Before(cutoff) })
+	return treatments[i:]
+}
+
+// entriesBetween returns sorted entries in [from, to)
+func entriesBetween(entries []models.GlucoseEntry, from, to time.Time) []models.GlucoseEntry {
+	i := sort.Search(len(entries), func(i int) bool { return !entries[i].Time().Before(from) })
+	j := sort.Search(len(entries), func(i int) bool { return !entries[i].Time().Before(to) })
+	return entries[i:j]
+}
+
+// treatmentsBetween returns sorted treatments in [from, to)
+func treatmentsBetween(treatments []models.Treatment, from, to time.Time) []models.Treatment {
+	i := sort.Search(len(treatments), func(i int) bool { return !treatments[i].Time().Before(from) })
+	j := sort.Search(len(treatments), func(i int) bool { return !treatments[i].Time().Before(to) })
+	return treatments[i:j]
+}
@@ -0,0 +1,67 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/insights"
+	"github.com/mrcode/nightscout-tray/internal/models"
+	"github.com/mrcode/nightscout-tray/internal/stats"
+)
+
+const (
+	// defaultInsightDays is the history scanned when no window is given
+	defaultInsightDays = 28
+
+	// maxInsightDays bounds the history one scan may load
+	maxInsightDays = 90
+
+	// insightTreatmentLead loads treatments past the window so meals and
+	// exercise near its end can be matched with the readings after them
+	insightTreatmentLead = 4 * time.Hour
+)
+
+// GetInsights scans the last days of history for recurring patterns and
+// returns them ranked, with hour by weekday heatmaps. days <= 0 uses four
+// weeks.
+func (s *NightscoutService) GetInsights(days int) (*models.InsightReport, error) {
+	from, to := insightWindow(days)
+
+	entries, err := s.loadEntries(from, to)
+	if err != nil {
+		return nil, fmt.Errorf("loading entries: %w", err)
+	}
+	treatments, err := s.loadTreatments(from, to.Add(insightTreatmentLead))
+	if err != nil {
+		return nil, fmt.Errorf("loading treatments: %w", err)
+	}
+
+	return insights.Analyze(entries, treatments, from, to, insights.Options{
+		Location: time.Local,
+		Settings: s.GetSettings(),
+	}), nil
+}
+
+// GetHeatmaps returns the share of readings in, below and above the target
+// range by weekday and hour over the last days. days <= 0 uses four weeks.
+func (s *NightscoutService) GetHeatmaps(days int) (*models.WeekdayHeatmaps, error) {
+	from, to := insightWindow(days)
+
+	entries, err := s.loadEntries(from, to)
+	if err != nil {
+		return nil, fmt.Errorf("loading entries: %w", err)
+	}
+	return stats.ComputeHeatmaps(entries, from, to, stats.ThresholdsFromSettings(s.GetSettings()), time.Local), nil
+}
+
+// insightWindow returns the last days up to now, starting at local midnight
+func insightWindow(days int) (from, to time.Time) {
+	if days <= 0 {
+		days = defaultInsightDays
+	}
+	days = min(days, maxInsightDays)
+
+	to = time.Now()
+	from = time.Date(to.Year(), to.Month(), to.Day()-days, 0, 0, 0, 0, time.Local)
+	return from, to
+}
@@ -0,0 +1,280 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/logging"
+)
+
+const (
+	// stopTimeout bounds how long Stop waits for background work to finish
+	stopTimeout = 10 * time.Second
+
+	// Restart backoff after a supervised task panics
+	minPanicBackoff = 1 * time.Second
+	maxPanicBackoff = 1 * time.Minute
+)
+
+// supervisor owns a set of background goroutines sharing one context.
+// Long-running tasks are restarted with backoff if they panic.
+type supervisor struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	onPanic func(task string, recovered any, stack []byte)
+}
+
+func newSupervisor(onPanic func(task string, recovered any, stack []byte)) *supervisor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &supervisor{
+		ctx:     ctx,
+		cancel:  cancel,
+		onPanic: onPanic,
+	}
+}
+
+// Go runs fn in a supervised goroutine. If restart is true and fn panics,
+// it is started again after a backoff until the supervisor is stopped.
+func (sv *supervisor) Go(task string, restart bool, fn func(ctx context.Context)) {
+	sv.wg.Add(1)
+	go func() {
+		defer sv.wg.Done()
+
+		backoff := minPanicBackoff
+		for {
+			if !sv.runOnce(task, fn) || !restart || sv.ctx.Err() != nil {
+				return
+			}
+
+			select {
+			case <-time.After(backoff):
+			case <-sv.ctx.Done():
+				return
+			}
+
+			backoff *= 2
+			if backoff > maxPanicBackoff {
+				backoff = maxPanicBackoff
+			}
+		}
+	}()
+}
+
+// runOnce runs fn and reports whether it panicked
+func (sv *supervisor) runOnce(task string, fn func(ctx context.Context)) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			if sv.onPanic != nil {
+				sv.onPanic(task, r, debug.Stack())
+			}
+		}
+	}()
+
+	fn(sv.ctx)
+	return false
+}
+
+// Stop cancels the context and waits up to timeout for all tasks to return.
+// It returns false if tasks were still running when the timeout expired.
+func (sv *supervisor) Stop(timeout time.Duration) bool {
+	sv.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		sv.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Wait blocks until all tasks have returned
+func (sv *supervisor) Wait() {
+	sv.wg.Wait()
+}
+
+// Start launches the supervised background tasks: the update loop, history
+// hydration and integrations. It does nothing if the service is already running.
+// The GUI calls it through SetApp; headless mode calls it directly.
+func (s *NightscoutService) Start() {
+	s.lifeMu.Lock()
+	defer s.lifeMu.Unlock()
+
+	if s.super != nil {
+		return
+	}
+
+	if s.settings.IsConfigured() {
+		s.initClient()
+	}
+	s.startLocked()
+}
+
+// startLocked creates a new supervisor and launches all tasks. lifeMu must be held.
+func (s *NightscoutService) startLocked() {
+	sv := newSupervisor(s.reportPanic)
+	s.super = sv
+
+	sv.Go("poller", true, s.runUpdateLoop)
+	sv.Go("history", false, s.hydrateHistory)
+	sv.Go("sync", true, s.runHistorySync)
+	sv.Go("devices", true, s.runDeviceRefresh)
+	sv.Go("sysmon", true, func(ctx context.Context) {
+		s.runSystemMonitor(ctx, sv)
+	})
+
+	// Webhook delivery outlives restarts so pending retries are not
+	// dead-lettered on every settings change; UpdateTargets applies new ones
+	if s.integrations == nil {
+		s.integrations = newSupervisor(s.reportPanic)
+		s.integrations.Go("webhooks", true, s.webhooks.Run)
+	}
+}
+
+// Stop cancels the restartable background tasks and waits for them to
+// finish. Webhook delivery keeps running until Shutdown.
+func (s *NightscoutService) Stop() {
+	s.lifeMu.Lock()
+	defer s.lifeMu.Unlock()
+
+	s.stopLocked()
+}
+
+// Shutdown stops all background tasks, including webhook delivery, before
+// the process exits
+func (s *NightscoutService) Shutdown() {
+	s.lifeMu.Lock()
+	defer s.lifeMu.Unlock()
+
+	// The process exits next, so tasks still running are not waited for
+	if s.super != nil && !s.super.Stop(stopTimeout) {
+		logger.Warn("background tasks did not stop in time", "timeout", stopTimeout)
+	}
+	s.super = nil
+	if s.integrations == nil {
+		return
+	}
+	if !s.integrations.Stop(stopTimeout) {
+		logger.Warn("webhook delivery did not stop in time", "timeout", stopTimeout)
+	}
+	s.integrations = nil
+}
+
+func (s *NightscoutService) stopLocked() {
+	if s.super == nil {
+		return
+	}
+
+	// A new set of tasks must not start while the old one still runs, or
+	// two update loops would fetch and alert side by side
+	if !s.super.Stop(stopTimeout) {
+		logger.Warn("background tasks did not stop in time, still waiting", "timeout", stopTimeout)
+		s.super.Wait()
+	}
+	s.super = nil
+}
+
+// Restart stops any running background tasks and starts them again so new
+// settings take effect
+func (s *NightscoutService) Restart() {
+	s.lifeMu.Lock()
+	defer s.lifeMu.Unlock()
+
+	s.stopLocked()
+	s.startLocked()
+}
+
+// ServiceShutdown is called by Wails when the application quits
+func (s *NightscoutService) ServiceShutdown() error {
+	s.Shutdown()
+	return nil
+}
+
+// IsRunning returns true if the background update loop is active
+func (s *NightscoutService) IsRunning() bool {
+	s.lifeMu.Lock()
+	defer s.lifeMu.Unlock()
+	return s.super != nil
+}
+
+// reportPanic logs a recovered panic and notifies the frontend
+func (s *NightscoutService) reportPanic(task string, recovered any, stack []byte) {
+	logger.Error("background task panicked", "task", task, "panic", fmt.Sprint(recovered), "stack", string(stack))
+
+	s.mu.RLock()
+	a := s.app
+	s.mu.RUnlock()
+
+	if a != nil {
+		a.Event.Emit("service:error", fmt.Sprintf("background task %s crashed: %v", task, recovered))
+	}
+}
+
+// triggerRefresh asks the update loop to fetch immediately without blocking
+func (s *NightscoutService) triggerRefresh() {
+	select {
+	case s.refreshCh <- struct{}{}:
+	default:
+	}
+}
+
+// runUpdateLoop fetches glucose data until ctx is cancelled. Fetches are
+// scheduled around the expected arrival of the next CGM reading and spaced
+// out further while running on battery.
+func (s *NightscoutService) runUpdateLoop(ctx context.Context) {
+	s.mu.RLock()
+	interval := time.Duration(s.settings.RefreshInterval) * time.Second
+	s.mu.RUnlock()
+
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	s.scheduler.SetFallback(interval)
+
+	// Initial fetch happens immediately
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-s.refreshCh:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+
+		mode := s.refreshPowerMode()
+		readingTime, err := s.fetchAndUpdate()
+		if err != nil {
+			s.scheduler.ObserveError()
+		} else {
+			s.scheduler.Observe(readingTime)
+		}
+		timer.Reset(s.powerDelay(mode, s.scheduler.Next(time.Now())))
+	}
+}
+
+// Component loggers used by the service
+var (
+	logger         = logging.For(logging.ComponentLoop)
+	historyLogger  = logging.For(logging.ComponentHistory)
+	settingsLogger = logging.For(logging.ComponentSettings)
+	notifyLogger   = logging.For(logging.ComponentNotifications)
+	trayLogger     = logging.For(logging.ComponentTray)
+)
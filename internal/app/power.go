@@ -0,0 +1,90 @@
+package app
+
+import (
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+	"github.com/mrcode/nightscout-tray/internal/power"
+)
+
+// urgentCheckInterval is the longest the update loop waits in power-saving
+// modes, so urgent lows and highs are still noticed within one CGM reading
+const urgentCheckInterval = defaultCGMInterval
+
+// PowerStatus describes the power source and the resulting poll mode
+type PowerStatus struct {
+	State power.State `json:"state"`
+	Mode  string      `json:"mode"` // One of models.PollMode*
+}
+
+// GetPowerStatus returns the last observed power state and poll mode
+func (s *NightscoutService) GetPowerStatus() PowerStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return PowerStatus{State: s.powerState, Mode: s.pollMode}
+}
+
+// refreshPowerMode reads the power source and selects the poll mode for it.
+// Alerts are restricted to urgent ones in push-only mode.
+func (s *NightscoutService) refreshPowerMode() string {
+	state, err := s.power.State()
+	if err != nil {
+		logger.Warn("reading power state failed", "error", err)
+	}
+
+	s.mu.RLock()
+	mode := models.PollModeNormal
+	if state.OnBattery {
+		mode = s.settings.BatteryPollMode
+		if state.Percent >= 0 && state.Percent <= s.settings.LowBatteryPercent {
+			mode = s.settings.LowBatteryPollMode
+		}
+	}
+	s.mu.RUnlock()
+
+	switch mode {
+	case models.PollModeNormal, models.PollModeSlow, models.PollModePushOnly:
+	default:
+		mode = models.PollModeNormal
+	}
+
+	s.mu.Lock()
+	changed := mode != s.pollMode
+	s.pollMode = mode
+	s.powerState = state
+	a := s.app
+	s.mu.Unlock()
+
+	if changed {
+		logger.Info("poll mode changed", "mode", mode, "onBattery", state.OnBattery, "percent", state.Percent)
+		s.notifyManager.SetUrgentOnly(mode == models.PollModePushOnly)
+		if a != nil {
+			a.Event.Emit("power:changed", PowerStatus{State: state, Mode: mode})
+		}
+	}
+
+	return mode
+}
+
+// powerDelay stretches the scheduler's delay according to the poll mode.
+// While glucose is out of range the normal schedule is kept so urgent alerts
+// are never delayed.
+func (s *NightscoutService) powerDelay(mode string, delay time.Duration) time.Duration {
+	s.mu.RLock()
+	status := s.lastStatus
+	slow := time.Duration(s.settings.BatteryRefreshInterval) * time.Second
+	s.mu.RUnlock()
+
+	if status != nil && status.Status != "normal" {
+		return delay
+	}
+
+	switch mode {
+	case models.PollModeSlow:
+		// Longer intervals would leave a fall into an urgent low unnoticed
+		return max(delay, min(slow, urgentCheckInterval))
+	case models.PollModePushOnly:
+		return max(delay, urgentCheckInterval)
+	}
+	return delay
+}
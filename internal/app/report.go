@@ -0,0 +1,105 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+	"github.com/mrcode/nightscout-tray/internal/prediction"
+	"github.com/mrcode/nightscout-tray/internal/report"
+	"github.com/mrcode/nightscout-tray/internal/stats"
+)
+
+// ReportFiles are the paths of a generated report
+type ReportFiles struct {
+	HTML string `json:"html"`
+	PDF  string `json:"pdf"`
+}
+
+// GenerateReport writes an HTML and a PDF report for the period to the
+// reports folder in the config directory
+func (s *NightscoutService) GenerateReport(from, to time.Time) (*ReportFiles, error) {
+	dir, err := models.GetConfigSubdir(models.ReportsDirName)
+	if err != nil {
+		return nil, err
+	}
+	return s.WriteReport(dir, from, to)
+}
+
+// WriteReport writes an HTML and a PDF report for the period to dir
+func (s *NightscoutService) WriteReport(dir string, from, to time.Time) (*ReportFiles, error) {
+	if !to.After(from) {
+		return nil, fmt.Errorf("invalid time window: %s to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	data, err := s.reportData(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	base := filepath.Join(dir, fmt.Sprintf("nightscout-report-%s-%s",
+		from.Local().Format("20060102"), to.Local().Format("20060102")))
+	files := &ReportFiles{HTML: base + ".html", PDF: base + ".pdf"}
+
+	if err := writeReportFile(files.HTML, data, report.WriteHTML); err != nil {
+		return nil, err
+	}
+	if err := writeReportFile(files.PDF, data, report.WritePDF); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// reportData gathers everything shown in a report
+func (s *NightscoutService) reportData(from, to time.Time) (*report.Data, error) {
+	entries, err := s.loadEntries(from, to)
+	if err != nil {
+		return nil, err
+	}
+	treatments, err := s.loadTreatments(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := s.GetSettings()
+	data := &report.Data{
+		Title:       "Glucose report",
+		From:        from,
+		To:          to,
+		GeneratedAt: time.Now(),
+		Unit:        settings.Unit,
+		Stats:       stats.Compute(entries, from, to, stats.ThresholdsFromSettings(settings)),
+		AGP:         stats.ComputeAGP(entries, from, to, stats.AGPOptions{Settings: settings}),
+		Episodes:    stats.DetectEpisodes(entries, treatments, from, to, stats.EpisodeOptions{Settings: settings}),
+		Totals:      prediction.NewAnalyzer().DailyAverages(treatments),
+	}
+
+	s.mu.RLock()
+	predSvc := s.predService
+	s.mu.RUnlock()
+
+	if predSvc != nil {
+		if params := predSvc.GetParameters(); !params.CalculatedAt.IsZero() {
+			data.Parameters = params
+		}
+	}
+	return data, nil
+}
+
+func writeReportFile(path string, data *report.Data, render func(w io.Writer, d *report.Data) error) error {
+	f, err := os.Create(path) //nolint:gosec // Path is built from the chosen report directory
+	if err != nil {
+		return err
+	}
+	if err := render(f, data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("rendering %s: %w", filepath.Base(path), err)
+	}
+	return f.Close()
+}
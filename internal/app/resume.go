@@ -0,0 +1,52 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/sysmon"
+)
+
+// runSystemMonitor watches for sleep/resume and network changes until ctx is
+// cancelled. Follow-up work is started on sv, the supervisor running the monitor.
+func (s *NightscoutService) runSystemMonitor(ctx context.Context, sv *supervisor) {
+	sysmon.NewMonitor(sysmon.DefaultInterval).Run(ctx, func(ev sysmon.Event) {
+		switch ev.Type {
+		case sysmon.EventResume:
+			logger.Info("system resumed, resyncing", "gap", ev.Gap.Round(time.Second))
+			s.resync(ctx, sv)
+		case sysmon.EventNetworkChanged:
+			if ev.Online {
+				logger.Info("network changed, resyncing")
+				s.resync(ctx, sv)
+			}
+		}
+	})
+}
+
+// resync brings all state up to date after the system woke or the network
+// came back: alert timers are moved to wall time, cached predictions are
+// dropped, the sparkline history is reloaded and a fetch runs immediately,
+// which also re-evaluates alerts against the fresh reading.
+func (s *NightscoutService) resync(ctx context.Context, sv *supervisor) {
+	s.notifyManager.ResyncClock()
+
+	s.mu.RLock()
+	predSvc := s.predService
+	a := s.app
+	s.mu.RUnlock()
+
+	if predSvc != nil {
+		predSvc.InvalidateCache()
+	}
+
+	s.triggerRefresh()
+
+	if ctx.Err() == nil {
+		sv.Go("history", false, s.hydrateHistory)
+	}
+
+	if a != nil {
+		a.Event.Emit("system:resync", nil)
+	}
+}
@@ -0,0 +1,182 @@
+package app
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultCGMInterval is the reading cadence assumed until enough readings are seen
+	defaultCGMInterval = 5 * time.Minute
+
+	// arrivalGrace is added to the expected reading time to allow for upload latency
+	arrivalGrace = 20 * time.Second
+
+	// fastPollInterval is used while waiting for an overdue reading
+	fastPollInterval = 15 * time.Second
+
+	// fastPollWindow is how long after the expected time we keep polling fast
+	fastPollWindow = 2 * time.Minute
+
+	// minFetchDelay prevents hammering the server in edge cases
+	minFetchDelay = 5 * time.Second
+
+	// cadenceSamples is the number of recent reading gaps used to estimate the cadence
+	cadenceSamples = 6
+
+	// staleCadences is how many missed readings make us give up on alignment
+	staleCadences = 3
+)
+
+// fetchScheduler decides when the update loop fetches next. It aligns fetches
+// to the expected arrival of the next CGM reading and falls back to the
+// configured interval when readings arrive irregularly.
+type fetchScheduler struct {
+	mu          sync.Mutex
+	fallback    time.Duration
+	lastReading time.Time
+	gaps        []time.Duration
+	errorStreak int
+}
+
+func newFetchScheduler(fallback time.Duration) *fetchScheduler {
+	if fallback <= 0 {
+		fallback = time.Minute
+	}
+	return &fetchScheduler{fallback: fallback}
+}
+
+// SetFallback sets the interval used when readings are irregular
+func (f *fetchScheduler) SetFallback(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if d > 0 {
+		f.fallback = d
+	}
+}
+
+// Seed primes the scheduler with historical reading times (any order)
+func (f *fetchScheduler) Seed(readings []time.Time) {
+	sorted := append([]time.Time(nil), readings...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+
+	for _, t := range sorted {
+		f.Observe(t)
+	}
+}
+
+// Observe records the timestamp of the newest reading seen by a successful fetch
+func (f *fetchScheduler) Observe(reading time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.errorStreak = 0
+	if reading.IsZero() || !reading.After(f.lastReading) {
+		return
+	}
+
+	if !f.lastReading.IsZero() {
+		f.gaps = append(f.gaps, reading.Sub(f.lastReading))
+		if len(f.gaps) > cadenceSamples {
+			f.gaps = f.gaps[len(f.gaps)-cadenceSamples:]
+		}
+	}
+	f.lastReading = reading
+}
+
+// ObserveError records a failed fetch
+func (f *fetchScheduler) ObserveError() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errorStreak++
+}
+
+// Reset forgets the reading history, e.g. after the server URL changed
+func (f *fetchScheduler) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lastReading = time.Time{}
+	f.gaps = nil
+	f.errorStreak = 0
+}
+
+// Next returns how long to wait before the next fetch
+func (f *fetchScheduler) Next(now time.Time) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Back off exponentially on errors, up to the configured interval
+	if f.errorStreak > 0 {
+		delay := fastPollInterval << uint(min(f.errorStreak-1, 8))
+		return clampDelay(delay, f.fallback)
+	}
+
+	cadence, regular := f.cadence()
+	if f.lastReading.IsZero() || !regular {
+		return f.fallback
+	}
+
+	sinceLast := now.Sub(f.lastReading)
+
+	// Sensor or uploader is offline; stop trying to align
+	if sinceLast > time.Duration(staleCadences)*cadence {
+		return f.fallback
+	}
+
+	// Next reading not due yet: sleep until it should have arrived
+	slots := (sinceLast - arrivalGrace) / cadence
+	if slots < 1 {
+		return clampDelay(f.lastReading.Add(cadence+arrivalGrace).Sub(now), cadence+arrivalGrace)
+	}
+
+	// Reading is late: poll fast for a while, then back off until the
+	// following slot in case the reading was skipped
+	slotStart := f.lastReading.Add(slots*cadence + arrivalGrace)
+	if now.Sub(slotStart) < fastPollWindow {
+		return fastPollInterval
+	}
+	return clampDelay(slotStart.Add(cadence).Sub(now), cadence)
+}
+
+// cadence estimates the reading interval and reports whether readings are regular
+func (f *fetchScheduler) cadence() (time.Duration, bool) {
+	if len(f.gaps) < 2 {
+		return defaultCGMInterval, true
+	}
+
+	sorted := append([]time.Duration(nil), f.gaps...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	median := sorted[len(sorted)/2]
+
+	if median < 30*time.Second || median > 15*time.Minute {
+		return median, false
+	}
+
+	// Readings are regular if most gaps are within 25% of the median.
+	// Single missed readings (a gap of ~2x) are tolerated.
+	tolerance := median / 4
+	regular := 0
+	for _, g := range f.gaps {
+		diff := g - median
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff <= tolerance || (g > median && (g%median <= tolerance || median-g%median <= tolerance)) {
+			regular++
+		}
+	}
+
+	return median, regular*4 >= len(f.gaps)*3
+}
+
+func clampDelay(d, maxDelay time.Duration) time.Duration {
+	if d < minFetchDelay {
+		return minFetchDelay
+	}
+	if maxDelay > 0 && d > maxDelay {
+		return maxDelay
+	}
+	return d
+}
@@ -1,17 +1,26 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"path/filepath"
 	"sort"
 	"sync"
 	"time"
 
 	"github.com/mrcode/nightscout-tray/internal/autostart"
+	"github.com/mrcode/nightscout-tray/internal/chart"
+	"github.com/mrcode/nightscout-tray/internal/history"
+	"github.com/mrcode/nightscout-tray/internal/logging"
 	"github.com/mrcode/nightscout-tray/internal/models"
 	"github.com/mrcode/nightscout-tray/internal/nightscout"
 	"github.com/mrcode/nightscout-tray/internal/notifications"
+	"github.com/mrcode/nightscout-tray/internal/power"
 	"github.com/mrcode/nightscout-tray/internal/prediction"
+	"github.com/mrcode/nightscout-tray/internal/secrets"
 	"github.com/mrcode/nightscout-tray/internal/tray"
+	"github.com/mrcode/nightscout-tray/internal/webhooks"
 	"github.com/wailsapp/wails/v3/pkg/application"
 )
 
@@ -22,14 +31,29 @@ type NightscoutService struct {
 	client        *nightscout.Client
 	notifyManager *notifications.Manager
 	predService   *prediction.Service
+	webhooks      *webhooks.Dispatcher
+	store         *history.Store // Local history, nil if it could not be opened
+	chartCache    *chart.Cache   // Hourly and daily aggregates of completed buckets
 
 	mu                sync.RWMutex
 	lastStatus        *models.GlucoseStatus
 	lastSuccessTime   time.Time
 	consecutiveErrors int
-	ticker            *time.Ticker
-	stopChan          chan struct{}
-	isRunning         bool
+	power             power.Provider
+	powerState        power.State
+	pollMode          string
+	historySyncedAt   time.Time // Last complete sync of the local store
+	profiles          []models.ProfileStore
+	profilesFetched   time.Time // When profiles were last read from Nightscout
+
+	deviceChangeEvents map[string]models.Treatment // Latest change by device, see runDeviceRefresh
+
+	lifeMu    sync.Mutex
+	super     *supervisor
+	integrations *supervisor // Webhook delivery, kept across restarts until Shutdown
+	scheduler *fetchScheduler
+	refreshCh chan struct{}
+	deviceRefreshCh chan struct{}
 	
 	app *application.App
 	tray *application.SystemTray
@@ -37,18 +61,75 @@ type NightscoutService struct {
 }
 
 func NewNightscoutService() *NightscoutService {
+	configDir, configErr := models.GetConfigDir()
+	if configErr == nil {
+		if err := logging.Setup(filepath.Join(configDir, models.LogsDirName)); err != nil {
+			logger.Warn("log file unavailable", "error", err)
+		}
+		models.SetSecretStore(secrets.NewStore(configDir))
+	}
+
 	settings := models.DefaultSettings()
 	if err := settings.Load(); err != nil {
-		fmt.Printf("Error loading settings: %v\n", err)
+		settingsLogger.Error("loading settings failed", "error", err)
+	}
+	settings.Normalize()
+	if err := settings.Validate(); err != nil {
+		settingsLogger.Warn("settings file has problems", "error", err)
 	}
+	logging.SetLevel(settings.LogLevel)
 
-	return &NightscoutService{
+	webhookDir := ""
+	if configErr == nil {
+		webhookDir = filepath.Join(configDir, models.WebhooksDirName)
+	}
+
+	s := &NightscoutService{
 		settings:      settings,
 		notifyManager: notifications.NewManager(settings),
-		stopChan:      make(chan struct{}),
+		webhooks:      webhooks.NewDispatcher(webhookDir),
+		store:         openHistoryStore(),
+		chartCache:    chart.NewCache(),
+		scheduler:     newFetchScheduler(time.Duration(settings.RefreshInterval) * time.Second),
+		refreshCh:     make(chan struct{}, 1),
+		deviceRefreshCh: make(chan struct{}, 1),
 		iconGen:       tray.NewIconGenerator(),
+		power:         power.NewProvider(),
+		pollMode:      models.PollModeNormal,
 		predService:   nil, // Initialized when client is ready
 	}
+
+	s.webhooks.UpdateTargets(settings.Clone().Webhooks)
+	s.notifyManager.SetAlertHandler(s.onAlert)
+
+	return s
+}
+
+// onAlert forwards fired alerts to the webhook dispatcher
+func (s *NightscoutService) onAlert(alertType string, status *models.GlucoseStatus) {
+	s.dispatchWebhook(models.WebhookEventAlert, alertType, status)
+}
+
+// dispatchWebhook queues a webhook event with the latest known prediction
+func (s *NightscoutService) dispatchWebhook(event, alertType string, status *models.GlucoseStatus) {
+	s.mu.RLock()
+	predSvc := s.predService
+	unit := s.settings.Unit
+	s.mu.RUnlock()
+
+	var pred *models.PredictionResult
+	if predSvc != nil {
+		pred = predSvc.GetLastPrediction()
+	}
+
+	s.webhooks.Dispatch(webhooks.Event{
+		Type:       event,
+		AlertType:  alertType,
+		Status:     status,
+		Prediction: pred,
+		Unit:       unit,
+		Time:       time.Now(),
+	})
 }
 
 
@@ -62,48 +143,29 @@ func (s *NightscoutService) initClient() {
 		s.settings.APIToken,
 		s.settings.UseToken,
 	)
+	s.profiles, s.profilesFetched = nil, time.Time{}
+	s.deviceChangeEvents = nil
 
 	// Initialize prediction service with the new client
 	if s.predService == nil {
 		s.predService = prediction.NewService(s.client)
+		if s.store != nil {
+			s.predService.SetHistorySource(historySource{s})
+		}
 	} else {
 		s.predService.SetClient(s.client)
 	}
 }
 
-func (s *NightscoutService) startUpdateLoop() {
-	s.mu.Lock()
-	if s.isRunning {
-		s.mu.Unlock()
-		return
-	}
-	s.isRunning = true
-
-	interval := time.Duration(s.settings.RefreshInterval) * time.Second
-	s.ticker = time.NewTicker(interval)
-	s.mu.Unlock()
-
-	// Initial fetch
-	s.fetchAndUpdate()
-
-	for {
-		select {
-		case <-s.ticker.C:
-			s.fetchAndUpdate()
-		case <-s.stopChan:
-			s.ticker.Stop()
-			return
-		}
-	}
-}
-
-func (s *NightscoutService) fetchAndUpdate() {
+// fetchAndUpdate fetches the current reading and updates the tray, alerts and UI.
+// It returns the reading's timestamp.
+func (s *NightscoutService) fetchAndUpdate() (time.Time, error) {
 	s.mu.RLock()
 	client := s.client
 	s.mu.RUnlock()
 
 	if client == nil {
-		return
+		return time.Time{}, fmt.Errorf("not configured")
 	}
 
 	entry, err := client.GetCurrentEntry()
@@ -116,7 +178,7 @@ func (s *NightscoutService) fetchAndUpdate() {
 		a := s.app
 		s.mu.Unlock()
 
-		fmt.Printf("Error fetching glucose data (attempt %d): %v\n", errorCount, err)
+		logger.Warn("fetching glucose data failed", "attempt", errorCount, "error", err)
 
 		if lastStatus != nil && !lastSuccess.IsZero() {
 			timeSinceSuccess := time.Since(lastSuccess)
@@ -134,29 +196,50 @@ func (s *NightscoutService) fetchAndUpdate() {
 		if a != nil {
 			a.Event.Emit("glucose:error", err.Error())
 		}
-		return
+		return time.Time{}, err
 	}
 
 	s.mu.Lock()
 	s.consecutiveErrors = 0
-	s.lastSuccessTime = time.Now()
+	// Wall time, so staleness stays correct across system sleep
+	s.lastSuccessTime = time.Now().Round(0)
 	s.mu.Unlock()
 
 	status := s.createStatus(entry)
 
+	if s.store != nil {
+		if added, err := s.store.AddEntries([]models.GlucoseEntry{*entry}); err != nil {
+			historyLogger.Error("storing reading failed", "error", err)
+		} else if added > 0 {
+			s.invalidateCharts([]models.GlucoseEntry{*entry})
+		}
+	}
+
 	s.mu.Lock()
+	previous := s.lastStatus
 	s.lastStatus = status
+	pushOnly := s.pollMode == models.PollModePushOnly
 	s.mu.Unlock()
 
 	s.updateTray(status)
 
+	// Reading webhooks are skipped in push-only mode; alert webhooks still fire
+	if !pushOnly && (previous == nil || !previous.Time.Equal(status.Time)) {
+		s.dispatchWebhook(models.WebhookEventReading, "", status)
+	}
+
 	if err := s.notifyManager.CheckAndNotify(status); err != nil {
-		fmt.Printf("Notification error: %v\n", err)
+		notifyLogger.Error("sending notification failed", "error", err)
+	}
+	if err := s.notifyManager.CheckDeviceAges(status.DeviceAges); err != nil {
+		notifyLogger.Error("sending device age notification failed", "error", err)
 	}
 
 	if s.app != nil {
 		s.app.Event.Emit("glucose:update", status)
 	}
+
+	return status.Time, nil
 }
 
 func (s *NightscoutService) createStatus(entry *models.GlucoseEntry) *models.GlucoseStatus {
@@ -165,6 +248,7 @@ func (s *NightscoutService) createStatus(entry *models.GlucoseEntry) *models.Glu
 	s.mu.RUnlock()
 
 	staleMinutes := int(time.Since(entry.Time()).Minutes())
+	ages := s.deviceAges(time.Now())
 
 	return &models.GlucoseStatus{
 		Value:        entry.SGV,
@@ -176,6 +260,8 @@ func (s *NightscoutService) createStatus(entry *models.GlucoseEntry) *models.Glu
 		Status:       settings.GetGlucoseStatus(entry.SGV),
 		StaleMinutes: staleMinutes,
 		IsStale:      staleMinutes > 15,
+		SensorWarmUp: s.sensorWarmingUp(ages, entry.Time()),
+		DeviceAges:   ages,
 	}
 }
 
@@ -191,11 +277,17 @@ func (s *NightscoutService) SetApp(app *application.App) {
 	s.mu.Unlock()
 
 	// Now that we have app reference, start background tasks
-	if s.settings.IsConfigured() {
-		s.initClient()
-		go s.hydrateHistory()
+	s.Start()
+}
+
+// Connect initializes the Nightscout client without starting the update loop.
+// It is used by one-shot command-line commands.
+func (s *NightscoutService) Connect() error {
+	if !s.settings.IsConfigured() {
+		return fmt.Errorf("not configured: set a Nightscout URL in the settings first")
 	}
-	go s.startUpdateLoop()
+	s.initClient()
+	return nil
 }
 
 func (s *NightscoutService) updateTray(status *models.GlucoseStatus) {
@@ -222,9 +314,12 @@ func (s *NightscoutService) updateTray(status *models.GlucoseStatus) {
 	// No tooltip - we use the popup window instead
 
 	iconData := s.iconGen.GenerateIcon(valStr, status.Direction, status)
-	if iconData != nil {
-		t.SetIcon(iconData)
+	if iconData == nil {
+		trayLogger.Warn("generating tray icon failed", "value", valStr)
+		return
 	}
+	t.SetIcon(iconData)
+	trayLogger.Debug("tray updated", "value", valStr, "direction", status.Direction)
 }
 
 func (s *NightscoutService) updateTrayError(err error) {
@@ -235,11 +330,12 @@ func (s *NightscoutService) updateTrayError(err error) {
 	if t == nil {
 		return
 	}
+	trayLogger.Warn("showing error in tray", "error", err)
 	t.SetLabel("ERR")
 	// No tooltip - we use the popup window instead
 }
 
-func (s *NightscoutService) hydrateHistory() {
+func (s *NightscoutService) hydrateHistory(ctx context.Context) {
 	s.mu.RLock()
 	client := s.client
 	s.mu.RUnlock()
@@ -250,7 +346,14 @@ func (s *NightscoutService) hydrateHistory() {
 
 	entries, err := client.GetRecentEntries(24)
 	if err != nil {
-		fmt.Printf("Error hydrating history: %v\n", err)
+		if s.store == nil {
+			logger.Warn("hydrating history failed", "error", err)
+			return
+		}
+		logger.Info("hydrating history from local store", "error", err)
+		entries = s.store.RecentEntries(24)
+	}
+	if ctx.Err() != nil {
 		return
 	}
 
@@ -258,6 +361,12 @@ func (s *NightscoutService) hydrateHistory() {
 		return entries[i].Date < entries[j].Date
 	})
 
+	readingTimes := make([]time.Time, len(entries))
+	for i := range entries {
+		readingTimes[i] = entries[i].Time()
+	}
+	s.scheduler.Seed(readingTimes)
+
 	s.mu.Lock()
 	s.iconGen.ClearHistory()
 	for i := range entries {
@@ -286,7 +395,26 @@ func (s *NightscoutService) GetSettings() *models.Settings {
 	return s.settings.Clone()
 }
 
+// SaveSettings validates and applies new settings. Invalid settings are
+// rejected with a *models.ValidationError and nothing is written to disk.
 func (s *NightscoutService) SaveSettings(settings *models.Settings) error {
+	settings = settings.Clone()
+	settings.Normalize()
+	if err := validateSettings(settings); err != nil {
+		return err
+	}
+
+	if err := s.applySettings(settings); err != nil {
+		return err
+	}
+
+	s.Restart()
+	return nil
+}
+
+// applySettings saves validated settings and passes them to every component.
+// Background tasks must be restarted by the caller.
+func (s *NightscoutService) applySettings(settings *models.Settings) error {
 	s.mu.Lock()
 	s.settings.Update(settings)
 	s.mu.Unlock()
@@ -295,9 +423,11 @@ func (s *NightscoutService) SaveSettings(settings *models.Settings) error {
 		return err
 	}
 
+	logging.SetLevel(settings.LogLevel)
 	s.initClient()
+	s.scheduler.Reset()
 	s.notifyManager.UpdateSettings(s.settings)
-	s.restartUpdateLoop()
+	s.webhooks.UpdateTargets(s.settings.Clone().Webhooks)
 
 	if settings.AutoStart {
 		_ = autostart.Enable()
@@ -308,71 +438,75 @@ func (s *NightscoutService) SaveSettings(settings *models.Settings) error {
 	return nil
 }
 
-func (s *NightscoutService) restartUpdateLoop() {
-	s.mu.Lock()
-	if s.ticker != nil {
-		s.ticker.Stop()
-	}
-	interval := time.Duration(s.settings.RefreshInterval) * time.Second
-	s.ticker = time.NewTicker(interval)
-	s.mu.Unlock()
-}
-
 func (s *NightscoutService) GetCurrentStatus() *models.GlucoseStatus {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return s.lastStatus
 }
 
-func (s *NightscoutService) GetChartData(hours int, offsetHours int) (*models.ChartData, error) {
+// RefreshNow fetches the latest reading immediately and updates the tray, alerts and UI
+func (s *NightscoutService) RefreshNow() (*models.GlucoseStatus, error) {
 	s.mu.RLock()
-	client := s.client
-	settings := s.settings
+	configured := s.client != nil
 	s.mu.RUnlock()
 
-	if client == nil {
+	if !configured {
 		return nil, fmt.Errorf("not configured")
 	}
 
-	now := time.Now()
-	to := now.Add(-time.Duration(offsetHours) * time.Hour)
-	from := to.Add(-time.Duration(hours) * time.Hour)
-
-	entries, err := client.GetEntries(from, to, 2000)
+	readingTime, err := s.fetchAndUpdate()
 	if err != nil {
+		s.scheduler.ObserveError()
 		return nil, err
 	}
+	s.scheduler.Observe(readingTime)
 
-	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].Date < entries[j].Date
-	})
+	return s.GetCurrentStatus(), nil
+}
 
-	chartEntries := make([]models.ChartEntry, len(entries))
-	useMmol := settings.Unit == unitMmolL
+// SnoozeAlerts silences all glucose alerts for the given number of minutes (0 cancels)
+func (s *NightscoutService) SnoozeAlerts(minutes int) time.Time {
+	return s.SnoozeAlertsFor(time.Duration(minutes) * time.Minute)
+}
 
-	for i, entry := range entries {
-		value := float64(entry.SGV)
-		if useMmol {
-			value = entry.ValueMmolL()
-		}
+// SnoozeAlertsFor silences all glucose alerts for d (0 cancels) and returns
+// when the snooze ends
+func (s *NightscoutService) SnoozeAlertsFor(d time.Duration) time.Time {
+	s.notifyManager.Snooze(d)
+	return s.notifyManager.SnoozedUntil()
+}
 
-		chartEntries[i] = models.ChartEntry{
-			Time:    entry.Date,
-			Value:   value,
-			ValueMg: entry.SGV,
-			Status:  settings.GetGlucoseStatus(entry.SGV),
-		}
+// GetSnoozedUntil returns when the current alert snooze ends, or the zero time
+func (s *NightscoutService) GetSnoozedUntil() time.Time {
+	return s.notifyManager.SnoozedUntil()
+}
+
+// FetchCurrentStatus fetches the latest reading from Nightscout, bypassing the update loop
+func (s *NightscoutService) FetchCurrentStatus() (*models.GlucoseStatus, error) {
+	s.mu.RLock()
+	client := s.client
+	s.mu.RUnlock()
+
+	if client == nil {
+		return nil, fmt.Errorf("not configured")
 	}
 
-	return &models.ChartData{
-		Entries:    chartEntries,
-		TargetLow:  settings.TargetLow,
-		TargetHigh: settings.TargetHigh,
-		UrgentLow:  settings.UrgentLow,
-		UrgentHigh: settings.UrgentHigh,
-		TimeRangeH: hours,
-		Unit:       settings.Unit,
-	}, nil
+	entry, err := client.GetCurrentEntry()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.createStatus(entry), nil
+}
+
+// GetEntries returns raw glucose entries between from and to, oldest first
+func (s *NightscoutService) GetEntries(from, to time.Time) ([]models.GlucoseEntry, error) {
+	return s.loadEntries(from, to)
+}
+
+// GetTreatmentsRange returns treatments between from and to, oldest first
+func (s *NightscoutService) GetTreatmentsRange(from, to time.Time) ([]models.Treatment, error) {
+	return s.loadTreatments(from, to)
 }
 
 // Prediction-related methods
@@ -519,3 +653,60 @@ func (s *NightscoutService) GetTreatments(hours int) ([]models.Treatment, error)
 
 	return predSvc.GetTreatments(hours)
 }
+
+// Webhook methods
+
+// validateSettings runs the model validation plus checks that need other
+// packages, such as webhook body templates
+func validateSettings(settings *models.Settings) error {
+	errs := &models.ValidationError{}
+	if err := settings.Validate(); err != nil {
+		var verr *models.ValidationError
+		if !errors.As(err, &verr) {
+			return err
+		}
+		errs.Errors = append(errs.Errors, verr.Errors...)
+	}
+
+	for i, w := range settings.Clone().Webhooks {
+		if err := webhooks.ValidateTemplate(w.BodyTemplate); err != nil {
+			errs.Add(fmt.Sprintf("webhooks[%d].bodyTemplate", i), models.ErrCodeInvalidValue, "%v", err)
+		}
+	}
+
+	return errs.OrNil()
+}
+
+// SendTestWebhook delivers a sample reading event to the given target immediately
+func (s *NightscoutService) SendTestWebhook(target models.WebhookTarget) error {
+	if err := webhooks.ValidateTemplate(target.BodyTemplate); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	status := s.lastStatus
+	unit := s.settings.Unit
+	s.mu.RUnlock()
+
+	if status == nil {
+		status = &models.GlucoseStatus{Value: 120, ValueMmol: models.ToMmol(120), Trend: "→", Direction: "Flat", Status: "normal", Time: time.Now()}
+	}
+
+	target.Enabled = true
+	return s.webhooks.SendTest(target, webhooks.Event{
+		Type:   models.WebhookEventReading,
+		Status: status,
+		Unit:   unit,
+		Time:   time.Now(),
+	})
+}
+
+// GetWebhookDeadLetters returns deliveries to a target that failed after all retries
+func (s *NightscoutService) GetWebhookDeadLetters(targetID string) ([]webhooks.DeadLetter, error) {
+	return s.webhooks.DeadLetters(targetID)
+}
+
+// ClearWebhookDeadLetters deletes the dead-letter log of a target
+func (s *NightscoutService) ClearWebhookDeadLetters(targetID string) error {
+	return s.webhooks.ClearDeadLetters(targetID)
+}
@@ -0,0 +1,24 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/stats"
+)
+
+// GetStatistics computes glycemic statistics for readings between from and
+// to. Time in range uses the user's target and urgent limits.
+func (s *NightscoutService) GetStatistics(from, to time.Time) (*stats.Statistics, error) {
+	if !to.After(from) {
+		return nil, fmt.Errorf("invalid time window: %s to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	entries, err := s.loadEntries(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	thresholds := stats.ThresholdsFromSettings(s.GetSettings())
+	return stats.Compute(entries, from, to, thresholds), nil
+}
@@ -0,0 +1,272 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/history"
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+const (
+	// historySyncInterval is how often new records are pulled into the local store
+	historySyncInterval = 5 * time.Minute
+
+	// historyBackfill is how far back the first sync of an empty store reaches
+	historyBackfill = 90 * 24 * time.Hour
+
+	// deviceStatusBackfill is shorter because device status reports are large and frequent
+	deviceStatusBackfill = 24 * time.Hour
+
+	// Incremental syncs re-read a little before the newest stored record.
+	// Treatments are often entered after the fact, so they get a longer overlap.
+	entrySyncOverlap     = 10 * time.Minute
+	treatmentSyncOverlap = 24 * time.Hour
+
+	// compactInterval is how often retention is applied and segments are rewritten
+	compactInterval = 24 * time.Hour
+)
+
+// openHistoryStore opens the local history store in the config directory.
+// The app keeps working without it, so errors are only logged.
+func openHistoryStore() *history.Store {
+	configDir, err := models.GetConfigDir()
+	if err != nil {
+		historyLogger.Warn("local history disabled", "error", err)
+		return nil
+	}
+
+	store, err := history.Open(filepath.Join(configDir, models.HistoryDirName))
+	if err != nil {
+		historyLogger.Warn("local history disabled", "error", err)
+		return nil
+	}
+	return store
+}
+
+// GetHistoryStats returns what the local history store contains
+func (s *NightscoutService) GetHistoryStats() (*history.Stats, error) {
+	if s.store == nil {
+		return nil, fmt.Errorf("local history is not available")
+	}
+	stats := s.store.Stats()
+	return &stats, nil
+}
+
+// runHistorySync keeps the local store up to date until ctx is cancelled.
+// Syncing pauses in push-only mode to save power.
+func (s *NightscoutService) runHistorySync(ctx context.Context) {
+	if s.store == nil {
+		return
+	}
+
+	for {
+		s.mu.RLock()
+		pushOnly := s.pollMode == models.PollModePushOnly
+		s.mu.RUnlock()
+
+		if !pushOnly {
+			if err := s.syncHistory(ctx); err != nil {
+				historyLogger.Warn("syncing history failed", "error", err)
+			} else {
+				s.precomputeCharts()
+			}
+			s.compactHistoryIfDue()
+		}
+
+		select {
+		case <-time.After(historySyncInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// syncHistory fetches records newer than the newest stored ones
+func (s *NightscoutService) syncHistory(ctx context.Context) error {
+	s.mu.RLock()
+	client := s.client
+	retention := s.historyRetention()
+	s.mu.RUnlock()
+
+	if client == nil {
+		return nil
+	}
+
+	started := time.Now().Round(0)
+	stats := s.store.Stats()
+
+	entries, err := client.GetEntries(syncStart(stats.Entries.Latest, entrySyncOverlap, historyBackfill, retention), time.Time{}, 0)
+	if err != nil {
+		return fmt.Errorf("fetching entries: %w", err)
+	}
+	added, err := s.store.AddEntries(entries)
+	if err != nil {
+		return fmt.Errorf("storing entries: %w", err)
+	}
+	if added > 0 {
+		s.invalidateCharts(entries)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	treatments, err := client.GetTreatments(syncStart(stats.Treatments.Latest, treatmentSyncOverlap, historyBackfill, retention), time.Time{}, 0)
+	if err != nil {
+		return fmt.Errorf("fetching treatments: %w", err)
+	}
+	if added, err := s.store.AddTreatments(treatments); err != nil {
+		return fmt.Errorf("storing treatments: %w", err)
+	} else if added > 0 {
+		s.invalidateDeviceChanges(treatments)
+	}
+
+	s.mu.Lock()
+	s.historySyncedAt = started
+	s.mu.Unlock()
+
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	statuses, err := client.GetDeviceStatus(syncStart(stats.DeviceStatus.Latest, entrySyncOverlap, deviceStatusBackfill, retention), time.Time{}, 0)
+	if err != nil {
+		return fmt.Errorf("fetching device status: %w", err)
+	}
+	if _, err := s.store.AddDeviceStatus(statuses); err != nil {
+		return fmt.Errorf("storing device status: %w", err)
+	}
+
+	return nil
+}
+
+// compactHistoryIfDue applies the retention limit once per compactInterval
+func (s *NightscoutService) compactHistoryIfDue() {
+	if time.Since(s.store.LastCompacted()) < compactInterval {
+		return
+	}
+
+	s.mu.RLock()
+	retention := s.historyRetention()
+	s.mu.RUnlock()
+
+	if err := s.store.Compact(retention); err != nil {
+		historyLogger.Error("compacting history failed", "error", err)
+	}
+}
+
+// historyRetention returns the configured retention; s.mu must be held
+func (s *NightscoutService) historyRetention() time.Duration {
+	return time.Duration(s.settings.HistoryRetentionDays) * 24 * time.Hour
+}
+
+// syncStart returns where an incremental sync begins: shortly before the newest
+// stored record, or the backfill window (bounded by retention) for an empty store
+func syncStart(latest time.Time, overlap, backfill, retention time.Duration) time.Time {
+	if !latest.IsZero() {
+		return latest.Add(-overlap)
+	}
+	if retention > 0 && retention < backfill {
+		backfill = retention
+	}
+	return time.Now().Add(-backfill)
+}
+
+// storeCovers reports whether the local store holds complete data for [from, to].
+// s.mu must be held.
+func (s *NightscoutService) storeCovers(earliest, from, to time.Time) bool {
+	if s.historySyncedAt.IsZero() || earliest.IsZero() || from.Before(earliest) {
+		return false
+	}
+	// Readings newer than the last sync are added by the update loop as they arrive
+	return !to.After(s.historySyncedAt.Add(historySyncInterval))
+}
+
+// loadEntries returns entries between from and to, oldest first. The local
+// store is used when it covers the range; otherwise Nightscout is queried,
+// falling back to whatever is stored locally when the server is unreachable.
+func (s *NightscoutService) loadEntries(from, to time.Time) ([]models.GlucoseEntry, error) {
+	s.mu.RLock()
+	client := s.client
+	covered := s.store != nil && s.storeCovers(s.store.Stats().Entries.Earliest, from, to)
+	s.mu.RUnlock()
+
+	if covered {
+		return s.store.Entries(from, to), nil
+	}
+	if client == nil {
+		// Without a server, imported history is all there is
+		if s.store != nil {
+			return s.store.Entries(from, to), nil
+		}
+		return nil, fmt.Errorf("not configured")
+	}
+
+	entries, err := client.GetEntries(from, to, 0)
+	if err != nil {
+		if s.store != nil {
+			if local := s.store.Entries(from, to); len(local) > 0 {
+				historyLogger.Info("server unreachable, using local entries", "count", len(local), "error", err)
+				return local, nil
+			}
+		}
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Date < entries[j].Date
+	})
+	return entries, nil
+}
+
+// loadTreatments returns treatments between from and to, oldest first, with
+// the same local-first strategy as loadEntries
+func (s *NightscoutService) loadTreatments(from, to time.Time) ([]models.Treatment, error) {
+	s.mu.RLock()
+	client := s.client
+	covered := s.store != nil && s.storeCovers(s.store.Stats().Treatments.Earliest, from, to)
+	s.mu.RUnlock()
+
+	if covered {
+		return s.store.Treatments(from, to), nil
+	}
+	if client == nil {
+		// Without a server, imported history is all there is
+		if s.store != nil {
+			return s.store.Treatments(from, to), nil
+		}
+		return nil, fmt.Errorf("not configured")
+	}
+
+	treatments, err := client.GetTreatments(from, to, 0)
+	if err != nil {
+		if s.store != nil {
+			if local := s.store.Treatments(from, to); len(local) > 0 {
+				historyLogger.Info("server unreachable, using local treatments", "count", len(local), "error", err)
+				return local, nil
+			}
+		}
+		return nil, err
+	}
+
+	sort.Slice(treatments, func(i, j int) bool {
+		return treatments[i].Time().Before(treatments[j].Time())
+	})
+	return treatments, nil
+}
+
+// historySource lets the prediction service read through loadEntries and loadTreatments
+type historySource struct {
+	s *NightscoutService
+}
+
+func (h historySource) Entries(from, to time.Time) ([]models.GlucoseEntry, error) {
+	return h.s.loadEntries(from, to)
+}
+
+func (h historySource) Treatments(from, to time.Time) ([]models.Treatment, error) {
+	return h.s.loadTreatments(from, to)
+}
@@ -0,0 +1,245 @@
+// Package backup creates and reads versioned backup archives of the app's
+// settings, learned parameters and local history
+package backup
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// FormatName identifies backup archives in the manifest
+	FormatName = "nightscout-tray-backup"
+
+	// FormatVersion is the current archive layout version
+	FormatVersion = 1
+
+	manifestName = "manifest.json"
+)
+
+// Errors returned by Read
+var (
+	ErrPassphraseRequired = errors.New("backup is encrypted, a passphrase is required")
+	ErrWrongPassphrase    = errors.New("wrong passphrase or damaged backup")
+	ErrCorrupt            = errors.New("backup is damaged")
+)
+
+// Manifest describes the archive contents
+type Manifest struct {
+	Format     string      `json:"format"`
+	Version    int         `json:"version"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	AppVersion string      `json:"appVersion"`
+	Files      []FileEntry `json:"files"`
+}
+
+// FileEntry records the size and checksum of an archived file
+type FileEntry struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// File is a file to include in a backup
+type File struct {
+	Name string // Slash-separated path inside the archive
+	Data []byte
+}
+
+// Archive is a verified backup read into memory
+type Archive struct {
+	Manifest  Manifest
+	Encrypted bool
+	files     map[string][]byte
+}
+
+// File returns the contents of a file in the archive
+func (a *Archive) File(name string) ([]byte, bool) {
+	data, ok := a.files[name]
+	return data, ok
+}
+
+// Names returns the archived file names with the given prefix, sorted
+func (a *Archive) Names(prefix string) []string {
+	var names []string
+	for name := range a.files {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Write creates an archive holding files. A non-empty passphrase encrypts it.
+func Write(w io.Writer, files []File, appVersion, passphrase string) (*Manifest, error) {
+	manifest := &Manifest{
+		Format:     FormatName,
+		Version:    FormatVersion,
+		CreatedAt:  time.Now().UTC(),
+		AppVersion: appVersion,
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		if err := validName(f.Name); err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(f.Data)
+		manifest.Files = append(manifest.Files, FileEntry{
+			Name:   f.Name,
+			Size:   int64(len(f.Data)),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name, Method: zip.Deflate, Modified: manifest.CreatedAt})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fw.Write(f.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: manifestName, Method: zip.Deflate, Modified: manifest.CreatedAt})
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(mw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	data := buf.Bytes()
+	if passphrase != "" {
+		if data, err = encrypt(data, passphrase); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Read decrypts (if needed) and verifies an archive. Every file must be
+// listed in the manifest with a matching size and checksum.
+func Read(data []byte, passphrase string) (*Archive, error) {
+	archive := &Archive{files: make(map[string][]byte)}
+
+	if isEncrypted(data) {
+		if passphrase == "" {
+			return nil, ErrPassphraseRequired
+		}
+		plain, err := decrypt(data, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		data = plain
+		archive.Encrypted = true
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+
+	for _, zf := range zr.File {
+		if err := validName(zf.Name); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		content, err := readZipFile(zf)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrCorrupt, zf.Name, err)
+		}
+		if zf.Name == manifestName {
+			if err := json.Unmarshal(content, &archive.Manifest); err != nil {
+				return nil, fmt.Errorf("%w: manifest: %v", ErrCorrupt, err)
+			}
+			continue
+		}
+		archive.files[zf.Name] = content
+	}
+
+	if err := archive.verify(); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+func (a *Archive) verify() error {
+	m := a.Manifest
+	if m.Format != FormatName {
+		return fmt.Errorf("%w: not a backup archive", ErrCorrupt)
+	}
+	if m.Version > FormatVersion {
+		return fmt.Errorf("backup format %d is newer than supported version %d", m.Version, FormatVersion)
+	}
+
+	listed := make(map[string]bool, len(m.Files))
+	for _, entry := range m.Files {
+		listed[entry.Name] = true
+		data, ok := a.files[entry.Name]
+		if !ok {
+			return fmt.Errorf("%w: %s is missing", ErrCorrupt, entry.Name)
+		}
+		sum := sha256.Sum256(data)
+		if int64(len(data)) != entry.Size || hex.EncodeToString(sum[:]) != entry.SHA256 {
+			return fmt.Errorf("%w: checksum mismatch for %s", ErrCorrupt, entry.Name)
+		}
+	}
+	for name := range a.files {
+		if !listed[name] {
+			return fmt.Errorf("%w: %s is not listed in the manifest", ErrCorrupt, name)
+		}
+	}
+	return nil
+}
+
+// validName rejects paths that could escape the restore directory
+func validName(name string) error {
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, "\\") ||
+		path.Clean(name) != name || strings.HasPrefix(name, "../") || name == ".." {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	return nil
+}
+
+// maxFileSize bounds decompression so a crafted archive can't exhaust memory
+const maxFileSize = 1 << 30
+
+func readZipFile(zf *zip.File) ([]byte, error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFileSize {
+		return nil, fmt.Errorf("file too large")
+	}
+	return data, nil
+}
@@ -0,0 +1,84 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+)
+
+// Encrypted archives are: magic | salt | nonce | AES-256-GCM(zip).
+// The key is derived from the passphrase with PBKDF2-HMAC-SHA256.
+var encryptedMagic = []byte("NSTBAK1\n")
+
+const (
+	saltSize         = 16
+	pbkdf2Iterations = 600000
+	keySize          = 32
+)
+
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+func deriveKey(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, pbkdf2Iterations, keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encrypt(plain []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	gcm, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(encryptedMagic)+saltSize+len(nonce)+len(plain)+gcm.Overhead())
+	out = append(out, encryptedMagic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	// The header is authenticated so it can't be swapped between archives
+	return gcm.Seal(out, nonce, plain, out[:len(encryptedMagic)+saltSize]), nil
+}
+
+func decrypt(data []byte, passphrase string) ([]byte, error) {
+	header := len(encryptedMagic) + saltSize
+	if len(data) < header {
+		return nil, ErrCorrupt
+	}
+	salt := data[len(encryptedMagic):header]
+
+	gcm, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < header+gcm.NonceSize() {
+		return nil, ErrCorrupt
+	}
+
+	nonce := data[header : header+gcm.NonceSize()]
+	plain, err := gcm.Open(nil, nonce, data[header+gcm.NonceSize():], data[:header])
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plain, nil
+}
@@ -0,0 +1,126 @@
+// Package basal works out the scheduled basal rate at any time from
+// Nightscout profile documents and profile switches, and the rate a temp
+// basal delivers in its place
+package basal
+
+import (
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// profileSwitch selects a named profile from the time it was logged
+type profileSwitch struct {
+	at   time.Time
+	name string
+}
+
+// Timeline answers which profile and scheduled rate apply at a given time
+type Timeline struct {
+	profiles []models.ProfileStore // Oldest first
+	switches []profileSwitch       // Oldest first
+	loc      *time.Location
+}
+
+// NewTimeline creates a timeline for profiles, sorted by start. Schedules
+// are read in loc unless a profile names its own time zone.
+func NewTimeline(profiles []models.ProfileStore, loc *time.Location) *Timeline {
+	return &Timeline{profiles: profiles, loc: loc}
+}
+
+// AddSwitch records a profile switch to name at at. Switches must be added
+// in time order; a stream of treatments may add them as it goes, as long as
+// it only asks about times up to the latest switch.
+func (tl *Timeline) AddSwitch(at time.Time, name string) {
+	tl.switches = append(tl.switches, profileSwitch{at: at, name: name})
+}
+
+// ProfileAt returns the profile document in effect at t and its active
+// profile, as selected by the latest profile switch, with its name
+func (tl *Timeline) ProfileAt(t time.Time) (*models.ProfileStore, string, *models.Profile) {
+	var store *models.ProfileStore
+	for i := range tl.profiles {
+		if tl.profiles[i].Start().After(t) {
+			break
+		}
+		store = &tl.profiles[i]
+	}
+	if store == nil {
+		return nil, "", nil
+	}
+	var name string
+	for _, s := range tl.switches {
+		if s.at.After(t) {
+			break
+		}
+		name = s.name
+	}
+	name, profile := store.Active(name)
+	return store, name, profile
+}
+
+// Scheduled returns the scheduled rate at t and the name of its schedule.
+// ok is false when no basal schedule is known at t.
+func (tl *Timeline) Scheduled(t time.Time) (rate float64, name string, ok bool) {
+	_, name, profile := tl.ProfileAt(t)
+	if profile == nil || len(profile.Basal) == 0 {
+		return 0, "", false
+	}
+	local := t.In(profile.Location(tl.loc))
+	return models.ScheduleValue(profile.Basal, SecondsOfDay(local)), name, true
+}
+
+// NextChange returns the first time after t at which the scheduled rate may
+// change: a schedule step, midnight, or a profile document or switch taking
+// effect. It returns to if nothing changes before it.
+func (tl *Timeline) NextChange(t, to time.Time) time.Time {
+	next := to
+	earlier := func(c time.Time) {
+		if c.After(t) && c.Before(next) {
+			next = c
+		}
+	}
+
+	for i := range tl.profiles {
+		earlier(tl.profiles[i].Start())
+	}
+	for _, s := range tl.switches {
+		earlier(s.at)
+	}
+
+	if _, _, profile := tl.ProfileAt(t); profile != nil && len(profile.Basal) > 0 {
+		loc := profile.Location(tl.loc)
+		local := t.In(loc)
+		seconds := SecondsOfDay(local)
+		boundary := 24 * 3600
+		for _, e := range models.SortedSchedule(profile.Basal) {
+			if e.Seconds() > seconds {
+				boundary = e.Seconds()
+				break
+			}
+		}
+		earlier(time.Date(local.Year(), local.Month(), local.Day(), 0, 0, boundary, 0, loc))
+	}
+
+	return next
+}
+
+// IsPercent reports whether temp is set relative to the scheduled rate
+// rather than as an absolute rate
+func IsPercent(temp *models.Treatment) bool {
+	return temp.Absolute <= 0 && temp.Percent != 0
+}
+
+// TempRate returns the rate a temp basal delivers while scheduled is the
+// scheduled rate. Nightscout percent is the change from the scheduled rate.
+func TempRate(temp *models.Treatment, scheduled float64) float64 {
+	if IsPercent(temp) {
+		return max(scheduled*(1+temp.Percent/100), 0)
+	}
+	return max(temp.Absolute, 0)
+}
+
+// SecondsOfDay returns the seconds since midnight of t's wall clock
+func SecondsOfDay(t time.Time) int {
+	return t.Hour()*3600 + t.Minute()*60 + t.Second()
+}
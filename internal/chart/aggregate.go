@@ -0,0 +1,119 @@
+package chart
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// maxCachedBuckets bounds the cache; a year of hourly and daily buckets fits
+const maxCachedBuckets = 20000
+
+// Bucket summarises the readings of one hour or day. Values are in mg/dL so
+// cached buckets stay valid when the display unit changes.
+type Bucket struct {
+	Start  time.Time
+	End    time.Time
+	Count  int
+	Min    float64
+	Max    float64
+	Mean   float64
+	P10    float64
+	P25    float64
+	Median float64
+	P75    float64
+	P90    float64
+}
+
+// Aggregate summarises the readings in [start, end). entries must be sorted
+// by time.
+func Aggregate(entries []models.GlucoseEntry, start, end time.Time) Bucket {
+	b := Bucket{Start: start, End: end}
+
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].Date >= start.UnixMilli() })
+	j := sort.Search(len(entries), func(i int) bool { return entries[i].Date >= end.UnixMilli() })
+
+	values := make([]float64, 0, j-i)
+	var sum float64
+	for _, e := range entries[i:j] {
+		if e.SGV <= 0 {
+			continue
+		}
+		values = append(values, float64(e.SGV))
+		sum += float64(e.SGV)
+	}
+	if len(values) == 0 {
+		return b
+	}
+	sort.Float64s(values)
+
+	b.Count = len(values)
+	b.Min = values[0]
+	b.Max = values[len(values)-1]
+	b.Mean = sum / float64(len(values))
+	b.P10 = percentile(values, 10)
+	b.P25 = percentile(values, 25)
+	b.Median = percentile(values, 50)
+	b.P75 = percentile(values, 75)
+	b.P90 = percentile(values, 90)
+	return b
+}
+
+// percentile returns the p-th percentile (0-100) of sorted values with
+// linear interpolation
+func percentile(sorted []float64, p float64) float64 {
+	pos := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+// Cache keeps the aggregates of completed buckets, so long ranges only have
+// to load the readings of the buckets that are still open
+type Cache struct {
+	mu      sync.Mutex
+	buckets map[cacheKey]Bucket
+}
+
+type cacheKey struct {
+	resolution string
+	start      int64
+}
+
+// NewCache creates an empty aggregate cache
+func NewCache() *Cache {
+	return &Cache{buckets: make(map[cacheKey]Bucket)}
+}
+
+// Get returns the cached bucket of the given resolution starting at start
+func (c *Cache) Get(resolution string, start time.Time) (Bucket, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.buckets[cacheKey{resolution, start.UnixMilli()}]
+	return b, ok
+}
+
+// Put stores a completed bucket
+func (c *Cache) Put(resolution string, b Bucket) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.buckets) >= maxCachedBuckets {
+		c.buckets = make(map[cacheKey]Bucket)
+	}
+	c.buckets[cacheKey{resolution, b.Start.UnixMilli()}] = b
+}
+
+// Invalidate drops every bucket that ends after from, e.g. when readings
+// from that time on were added to the history
+func (c *Cache) Invalidate(from time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, b := range c.buckets {
+		if b.End.After(from) {
+			delete(c.buckets, key)
+		}
+	}
+}
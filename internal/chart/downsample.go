@@ -0,0 +1,87 @@
+// Package chart prepares glucose data for display: it downsamples long
+// ranges and aggregates them into hourly or daily buckets, so the webview
+// draws a few hundred shapes instead of tens of thousands of readings.
+package chart
+
+import (
+	"math"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// Downsample reduces points to at most threshold using Largest-Triangle-
+// Three-Buckets. LTTB keeps the visual shape of the curve but can skip a
+// short low or high, so every bucket also keeps its lowest and highest point.
+// Points must be sorted by time.
+func Downsample(points []models.ChartEntry, threshold int) []models.ChartEntry {
+	if threshold < 3 || len(points) <= threshold {
+		return points
+	}
+
+	// Up to three points per bucket, plus the fixed first and last ones
+	buckets := max((threshold-2)/3, 1)
+	size := float64(len(points)-2) / float64(buckets)
+
+	out := make([]models.ChartEntry, 0, threshold)
+	out = append(out, points[0])
+	prev := points[0]
+
+	for b := 0; b < buckets; b++ {
+		start := int(float64(b)*size) + 1
+		end := min(int(float64(b+1)*size)+1, len(points)-1)
+
+		// The next bucket is represented by its average point
+		nextStart, nextEnd := end, min(int(float64(b+2)*size)+1, len(points)-1)
+		if b == buckets-1 || nextStart >= nextEnd {
+			nextStart, nextEnd = len(points)-1, len(points)
+		}
+		var avgTime, avgValue float64
+		for _, p := range points[nextStart:nextEnd] {
+			avgTime += float64(p.Time)
+			avgValue += p.Value
+		}
+		n := float64(nextEnd - nextStart)
+		avgTime, avgValue = avgTime/n, avgValue/n
+
+		picked, lowest, highest := start, start, start
+		maxArea := -1.0
+		for i := start; i < end; i++ {
+			p := points[i]
+			area := math.Abs((float64(prev.Time)-avgTime)*(p.Value-prev.Value) -
+				(float64(prev.Time)-float64(p.Time))*(avgValue-prev.Value))
+			if area > maxArea {
+				maxArea, picked = area, i
+			}
+			if p.Value < points[lowest].Value {
+				lowest = i
+			}
+			if p.Value > points[highest].Value {
+				highest = i
+			}
+		}
+
+		for _, i := range ordered(picked, lowest, highest) {
+			out = append(out, points[i])
+		}
+		prev = points[picked]
+	}
+
+	return append(out, points[len(points)-1])
+}
+
+// ordered returns the distinct indexes in ascending order
+func ordered(a, b, c int) []int {
+	idx := []int{a, b, c}
+	for i := 1; i < len(idx); i++ {
+		for j := i; j > 0 && idx[j] < idx[j-1]; j-- {
+			idx[j], idx[j-1] = idx[j-1], idx[j]
+		}
+	}
+	out := idx[:1]
+	for _, i := range idx[1:] {
+		if i != out[len(out)-1] {
+			out = append(out, i)
+		}
+	}
+	return out
+}
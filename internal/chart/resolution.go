@@ -0,0 +1,88 @@
+package chart
+
+import "time"
+
+// Chart resolutions
+const (
+	ResolutionRaw         = "raw"         // Every reading
+	ResolutionDownsampled = "downsampled" // Readings thinned out by Downsample
+	ResolutionHourly      = "hourly"      // One bucket per local hour
+	ResolutionDaily       = "daily"       // One bucket per local day
+)
+
+const (
+	// MaxPoints is the most readings sent to the chart before downsampling
+	MaxPoints = 1000
+
+	// maxReadingSpan is the longest range drawn from individual readings
+	maxReadingSpan = 7 * 24 * time.Hour
+
+	// maxHourlySpan is the longest range drawn from hourly buckets
+	maxHourlySpan = 45 * 24 * time.Hour
+
+	// SettleDelay is how long after a bucket ends late uploads are still
+	// expected; only buckets older than that are cached
+	SettleDelay = 2 * time.Hour
+)
+
+// SelectResolution picks how a range of the given length is drawn. Up to a
+// week, readings are shown (downsampled if there are too many); beyond that,
+// hourly and then daily aggregates keep the chart at a few hundred shapes.
+func SelectResolution(span time.Duration) string {
+	switch {
+	case span <= maxReadingSpan:
+		return ResolutionRaw
+	case span <= maxHourlySpan:
+		return ResolutionHourly
+	default:
+		return ResolutionDaily
+	}
+}
+
+// BucketMinutes returns the bucket width of an aggregate resolution. Daily
+// buckets span 23 or 25 hours on daylight saving changes.
+func BucketMinutes(resolution string) int {
+	switch resolution {
+	case ResolutionHourly:
+		return 60
+	case ResolutionDaily:
+		return 24 * 60
+	default:
+		return 0
+	}
+}
+
+// Span is the time range of one bucket, [Start, End)
+type Span struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Spans returns the buckets of resolution that cover [from, to), aligned to
+// local hours or midnights in loc
+func Spans(resolution string, from, to time.Time, loc *time.Location) []Span {
+	var spans []Span
+	for start := floor(resolution, from, loc); start.Before(to); {
+		end := next(resolution, start, loc)
+		spans = append(spans, Span{Start: start, End: end})
+		start = end
+	}
+	return spans
+}
+
+func floor(resolution string, t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	if resolution == ResolutionDaily {
+		return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	}
+	// Truncating the instant keeps repeated hours apart when clocks fall back
+	return local.Add(-time.Duration(local.Minute())*time.Minute -
+		time.Duration(local.Second())*time.Second - time.Duration(local.Nanosecond()))
+}
+
+func next(resolution string, start time.Time, loc *time.Location) time.Time {
+	if resolution == ResolutionDaily {
+		return time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, loc)
+	}
+	return floor(resolution, start.Add(time.Hour), loc)
+}
@@ -0,0 +1,166 @@
+package chart
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/basal"
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// Markers returns chart markers for the treatments in [from, to]. Temp
+// basals are left out unless withTempBasals is set, since looping systems
+// set one every few minutes and weeks of them would bury everything else.
+func Markers(treatments []models.Treatment, from, to time.Time, unit string, withTempBasals bool) []models.ChartMarker {
+	types := models.TreatmentEventTypes
+	var out []models.ChartMarker
+
+	for i := range treatments {
+		t := &treatments[i]
+		at := t.Time()
+		if at.Before(from) || at.After(to) {
+			continue
+		}
+
+		m := models.ChartMarker{
+			Time:      at.UnixMilli(),
+			EventType: t.EventType,
+			Insulin:   t.Insulin,
+			Carbs:     t.Carbs,
+			Notes:     t.Notes,
+			ID:        t.ID,
+		}
+		switch {
+		case t.EventType == types.TempBasal:
+			if !withTempBasals {
+				continue
+			}
+			m.Kind = models.ChartMarkerTempBasal
+			m.Duration, m.Rate, m.Percent = t.Duration, t.Absolute, t.Percent
+		case t.EventType == types.TemporaryTarget:
+			m.Kind = models.ChartMarkerTempTarget
+			m.Duration = t.Duration
+			m.TargetLow = toUnit(targetMgdl(t.TargetBottom, t.Units), unit)
+			m.TargetHigh = toUnit(targetMgdl(t.TargetTop, t.Units), unit)
+		case t.Insulin > 0 && t.IsBolus():
+			m.Kind = models.ChartMarkerBolus
+		case t.Carbs > 0:
+			m.Kind = models.ChartMarkerCarbs
+		case t.EventType == types.Note || t.EventType == types.Announcement ||
+			t.EventType == types.Question || t.Notes != "":
+			m.Kind = models.ChartMarkerNote
+		default:
+			continue
+		}
+		out = append(out, m)
+	}
+
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Time < out[j].Time })
+	return out
+}
+
+// targetMgdl converts a temp target bound to mg/dL. Uploaders store it in
+// mg/dL whatever the units field says, except for small values entered in
+// mmol/L.
+func targetMgdl(v float64, units string) float64 {
+	if v > 0 && v < 30 && strings.Contains(strings.ToLower(units), "mmol") {
+		return models.ToMgdl(v)
+	}
+	return v
+}
+
+func toUnit(mgdl float64, unit string) float64 {
+	if unit == "mmol/L" {
+		return models.ToMmol(mgdl)
+	}
+	return mgdl
+}
+
+// tempBasal is a temp basal with its effective end: its duration, or the
+// next temp basal if that starts earlier
+type tempBasal struct {
+	start, end time.Time
+	treatment  *models.Treatment
+}
+
+// BasalSteps returns the basal rate in effect over [from, to) as steps that
+// start whenever the delivered or scheduled rate changes. treatments must
+// be sorted by time and reach back far enough to include temp basals and
+// profile switches still in effect at from. Without profiles nothing is
+// known about the scheduled rate, so no steps are returned.
+func BasalSteps(treatments []models.Treatment, profiles []models.ProfileStore, from, to time.Time, loc *time.Location) []models.ChartBasalStep {
+	if len(profiles) == 0 || !from.Before(to) {
+		return nil
+	}
+
+	timeline := basal.NewTimeline(profiles, loc)
+	var temps []tempBasal
+	for i := range treatments {
+		t := &treatments[i]
+		switch t.EventType {
+		case models.TreatmentEventTypes.TempBasal:
+			at := t.Time()
+			if n := len(temps); n > 0 && temps[n-1].end.After(at) {
+				temps[n-1].end = at
+			}
+			if t.Duration > 0 {
+				temps = append(temps, tempBasal{
+					start:     at,
+					end:       at.Add(time.Duration(t.Duration * float64(time.Minute))),
+					treatment: t,
+				})
+			}
+		case models.TreatmentEventTypes.ProfileSwitch:
+			timeline.AddSwitch(t.Time(), t.Profile)
+		}
+	}
+
+	var steps []models.ChartBasalStep
+	for t := from; t.Before(to); {
+		scheduled, _, _ := timeline.Scheduled(t)
+		step := models.ChartBasalStep{Time: t.UnixMilli(), Rate: scheduled, Scheduled: scheduled}
+		if temp := tempAt(temps, t); temp != nil {
+			step.Temp = true
+			step.Rate = basal.TempRate(temp, scheduled)
+		}
+		step.Rate = round3(step.Rate)
+		step.Scheduled = round3(step.Scheduled)
+
+		if n := len(steps); n == 0 || steps[n-1].Rate != step.Rate ||
+			steps[n-1].Scheduled != step.Scheduled || steps[n-1].Temp != step.Temp {
+			steps = append(steps, step)
+		}
+
+		// The rate may also change when a temp basal starts or ends
+		next := timeline.NextChange(t, to)
+		for _, temp := range temps {
+			for _, c := range []time.Time{temp.start, temp.end} {
+				if c.After(t) && c.Before(next) {
+					next = c
+				}
+			}
+		}
+		t = next
+	}
+	return steps
+}
+
+// tempAt returns the temp basal running at t
+func tempAt(temps []tempBasal, t time.Time) *models.Treatment {
+	for i := len(temps) - 1; i >= 0; i-- {
+		temp := &temps[i]
+		if !temp.start.After(t) {
+			if temp.end.After(t) {
+				return temp.treatment
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+func round3(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
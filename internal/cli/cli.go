@@ -0,0 +1,680 @@
+// Package cli implements the headless daemon and command-line subcommands
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/app"
+	"github.com/mrcode/nightscout-tray/internal/backup"
+	"github.com/mrcode/nightscout-tray/internal/export"
+	"github.com/mrcode/nightscout-tray/internal/importer"
+	"github.com/mrcode/nightscout-tray/internal/instance"
+	"github.com/mrcode/nightscout-tray/internal/models"
+	"github.com/mrcode/nightscout-tray/internal/tray"
+)
+
+const (
+	unitMmolL = "mmol/L"
+
+	// passphraseEnv holds the passphrase for encrypted backups, so it never
+	// appears in the process list or shell history
+	passphraseEnv = "NIGHTSCOUT_TRAY_PASSPHRASE"
+
+	// chartWidth is the maximum number of sparkline columns printed by "chart"
+	chartWidth = 72
+)
+
+// command describes a CLI subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(svc *app.NightscoutService, args []string, out io.Writer) error
+}
+
+var commands = []command{
+	{"daemon", "Run headless: update loop, notifications and integrations without a window", runDaemon},
+	{"status", "Print the current glucose reading", runStatus},
+	{"chart", "Print a text sparkline of recent readings (--hours N)", runChart},
+	{"predict", "Print the current glucose prediction", runPredict},
+	{"analyze", "Calculate diabetes parameters (--days N --mode ml|statistical)", runAnalyze},
+	{"export", "Export entries and treatments (--days N | --from DATE --to DATE, --format json|csv|ndjson|fhir|tidepool, --output FILE)", runExport},
+	{"diagnostics", "Write a diagnostic bundle for bug reports (--output FILE)", runDiagnostics},
+	{"report", "Write an HTML and PDF report (--days N --output DIR)", runReport},
+	{"backup", "Back up settings, parameters and history (--output FILE --encrypt)", runBackup},
+	{"restore", "Restore a backup archive (restore FILE)", runRestore},
+	{"import", "Import a Dexcom Clarity or LibreView CSV export ([--format F] [--timezone TZ] [--upload] FILE)", runImport},
+	{"help", "Show this help", nil},
+}
+
+// IsCommand returns true if args start with a known subcommand or a headless flag
+func IsCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	if args[0] == "--headless" || args[0] == "-headless" {
+		return true
+	}
+	return lookup(args[0]) != nil
+}
+
+func lookup(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// Run executes the subcommand in args and returns the process exit code
+func Run(args []string) int {
+	return run(args, os.Stdout, os.Stderr)
+}
+
+func run(args []string, out, errOut io.Writer) int {
+	if len(args) == 0 {
+		printUsage(errOut)
+		return 2
+	}
+
+	name := args[0]
+	if name == "--headless" || name == "-headless" {
+		name = "daemon"
+	}
+
+	cmd := lookup(name)
+	if cmd == nil || cmd.run == nil {
+		printUsage(out)
+		if cmd == nil {
+			return 2
+		}
+		return 0
+	}
+
+	svc := app.NewNightscoutService()
+	if err := cmd.run(svc, args[1:], out); err != nil {
+		_, _ = fmt.Fprintf(errOut, "%s: %v\n", cmd.name, err)
+		return 1
+	}
+	return 0
+}
+
+func printUsage(w io.Writer) {
+	_, _ = fmt.Fprintln(w, "Usage: nightscout-tray [command] [flags]")
+	_, _ = fmt.Fprintln(w, "")
+	_, _ = fmt.Fprintln(w, "Without a command the tray application is started.")
+	_, _ = fmt.Fprintln(w, "")
+	_, _ = fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		_, _ = fmt.Fprintf(w, "  %-11s %s\n", c.name, c.summary)
+	}
+	_, _ = fmt.Fprintln(w, "")
+	_, _ = fmt.Fprintln(w, "Control flags (forwarded to the running instance):")
+	_, _ = fmt.Fprintln(w, "  --show          Show the dashboard")
+	_, _ = fmt.Fprintln(w, "  --snooze 30m    Snooze alerts (\"off\" cancels)")
+	_, _ = fmt.Fprintln(w, "  --refresh       Fetch the latest reading now")
+	_, _ = fmt.Fprintln(w, "  --status        Print the current reading")
+	_, _ = fmt.Fprintln(w, "  --quit          Quit the running instance")
+}
+
+// newFlagSet creates a flag set that reports errors instead of exiting
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+func runDaemon(svc *app.NightscoutService, args []string, out io.Writer) error {
+	fs := newFlagSet("daemon")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if !svc.GetSettings().IsConfigured() {
+		path, _ := models.GetConfigPath()
+		return fmt.Errorf("not configured: create %s with a nightscoutUrl", path)
+	}
+
+	configDir, err := models.GetConfigDir()
+	if err != nil {
+		return err
+	}
+	inst, err := instance.Acquire(configDir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = inst.Close()
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	inst.SetHandler(ControlHandler(svc, nil, stop))
+
+	_, _ = fmt.Fprintln(out, "Starting headless Nightscout monitor")
+	svc.Start()
+
+	<-ctx.Done()
+	_, _ = fmt.Fprintln(out, "Shutting down")
+	svc.Shutdown()
+	return nil
+}
+
+func runStatus(svc *app.NightscoutService, args []string, out io.Writer) error {
+	fs := newFlagSet("status")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := svc.Connect(); err != nil {
+		return err
+	}
+
+	status, err := svc.FetchCurrentStatus()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return writeJSON(out, status)
+	}
+
+	_, _ = fmt.Fprintln(out, describeStatus(status, svc.GetSettings().Unit))
+	if status.IsStale {
+		_, _ = fmt.Fprintln(out, "Warning: data is stale")
+	}
+	return nil
+}
+
+func runChart(svc *app.NightscoutService, args []string, out io.Writer) error {
+	fs := newFlagSet("chart")
+	hours := fs.Int("hours", 3, "hours of history to plot")
+	height := fs.Int("height", 10, "chart height in lines")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *hours < 1 {
+		return fmt.Errorf("--hours must be at least 1")
+	}
+
+	if err := svc.Connect(); err != nil {
+		return err
+	}
+
+	data, err := svc.GetChartData(*hours, 0)
+	if err != nil {
+		return err
+	}
+	if len(data.Entries) < 2 {
+		return fmt.Errorf("not enough readings in the last %d hours", *hours)
+	}
+
+	values := make([]float64, len(data.Entries))
+	for i, e := range data.Entries {
+		values[i] = e.Value
+	}
+
+	_, _ = fmt.Fprintf(out, "Last %dh (%s)", *hours, data.Unit)
+	_, _ = fmt.Fprintln(out, tray.RenderSparkline(bucketAverage(values, chartWidth), *height))
+	return nil
+}
+
+func runPredict(svc *app.NightscoutService, args []string, out io.Writer) error {
+	fs := newFlagSet("predict")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := svc.Connect(); err != nil {
+		return err
+	}
+
+	pred, err := svc.GetPrediction()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return writeJSON(out, pred)
+	}
+
+	unit := svc.GetSettings().Unit
+	_, _ = fmt.Fprintf(out, "Current: %s %s   IOB: %.2f U   COB: %.0f g\n",
+		formatMgdl(pred.BasedOnGlucose, unit), unit, pred.IOB, pred.COB)
+
+	for _, p := range pred.ShortTerm {
+		minutes := int(time.Until(time.UnixMilli(p.Time)).Round(time.Minute).Minutes())
+		if minutes <= 0 || minutes%15 != 0 {
+			continue
+		}
+		_, _ = fmt.Fprintf(out, "  +%3d min  %6s  (%.0f%% confidence)\n",
+			minutes, formatMgdl(p.Value, unit), p.Confidence)
+	}
+
+	if pred.LowInMinutes > 0 {
+		_, _ = fmt.Fprintf(out, "Low predicted in %.0f minutes\n", pred.LowInMinutes)
+	}
+	if pred.HighInMinutes > 0 {
+		_, _ = fmt.Fprintf(out, "High predicted in %.0f minutes\n", pred.HighInMinutes)
+	}
+	return nil
+}
+
+func runAnalyze(svc *app.NightscoutService, args []string, out io.Writer) error {
+	fs := newFlagSet("analyze")
+	days := fs.Int("days", 14, "days of history to analyze")
+	mode := fs.String("mode", "statistical", "analysis mode: statistical or ml")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *mode != "statistical" && *mode != "ml" {
+		return fmt.Errorf("--mode must be statistical or ml")
+	}
+	if *days < 1 {
+		return fmt.Errorf("--days must be at least 1")
+	}
+
+	if err := svc.Connect(); err != nil {
+		return err
+	}
+
+	before := svc.GetPredictionParameters().CalculatedAt
+	if err := svc.StartParameterCalculation(*days, *mode); err != nil {
+		return err
+	}
+
+	for svc.IsCalculating() {
+		p := svc.GetCalculationProgress()
+		if !*asJSON {
+			_, _ = fmt.Fprintf(out, "\r[%3.0f%%] %-40s", p.Progress, p.Stage)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	if !*asJSON {
+		_, _ = fmt.Fprintln(out)
+	}
+
+	params := svc.GetPredictionParameters()
+	if !params.CalculatedAt.After(before) {
+		if p := svc.GetCalculationProgress(); p.Error != "" {
+			return fmt.Errorf("calculation failed: %s", p.Error)
+		}
+		return fmt.Errorf("calculation did not produce new parameters")
+	}
+
+	if *asJSON {
+		return writeJSON(out, params)
+	}
+
+	_, _ = fmt.Fprintf(out, "ISF: %.1f mg/dL/U (%.0f%% confidence)\n", params.ISF, params.ISFConfidence)
+	_, _ = fmt.Fprintf(out, "ICR: %.1f g/U (%.0f%% confidence)\n", params.ICR, params.ICRConfidence)
+	_, _ = fmt.Fprintf(out, "DIA: %.1f h (%.0f%% confidence)\n", params.DIA, params.DIAConfidence)
+	_, _ = fmt.Fprintf(out, "Carb absorption: %.0f g/h\n", params.CarbAbsorptionRate)
+	_, _ = fmt.Fprintf(out, "Daily insulin: %.1f U (basal %.1f, bolus %.1f)  Daily carbs: %.0f g\n",
+		params.TotalDailyInsulin, params.BasalInsulin, params.BolusInsulin, params.TotalDailyCarbs)
+	_, _ = fmt.Fprintf(out, "Mean: %.0f mg/dL  SD: %.0f  CV: %.1f%%  GMI: %.1f%%\n",
+		params.AverageGlucose, params.GlucoseStdDev, params.CoefficientOfVariation, params.GMI)
+	_, _ = fmt.Fprintf(out, "TIR: %.1f%%  TBR: %.1f%%  TAR: %.1f%%\n",
+		params.TimeInRange, params.TimeBelowRange, params.TimeAboveRange)
+	_, _ = fmt.Fprintf(out, "Based on %d entries and %d treatments over %d days\n",
+		params.EntriesAnalyzed, params.TreatmentsAnalyzed, params.DataDays)
+	return nil
+}
+
+// serviceSource reads export data through the service, so the history
+// store is used where it covers the range
+type serviceSource struct {
+	svc *app.NightscoutService
+}
+
+func (s serviceSource) Entries(from, to time.Time) ([]models.GlucoseEntry, error) {
+	return s.svc.GetEntries(from, to)
+}
+
+func (s serviceSource) Treatments(from, to time.Time) ([]models.Treatment, error) {
+	return s.svc.GetTreatmentsRange(from, to)
+}
+
+func runExport(svc *app.NightscoutService, args []string, out io.Writer) error {
+	fs := newFlagSet("export")
+	days := fs.Int("days", 7, "days of history to export, ending now")
+	fromFlag := fs.String("from", "", "first day to export (YYYY-MM-DD, overrides --days)")
+	toFlag := fs.String("to", "", "last day to export (YYYY-MM-DD, default today)")
+	format := fs.String("format", export.FormatJSON, "output format: "+strings.Join(export.Formats, ", "))
+	output := fs.String("output", "", "output file (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !export.ValidFormat(*format) {
+		return fmt.Errorf("--format must be one of %s", strings.Join(export.Formats, ", "))
+	}
+
+	from, to, err := exportRange(*days, *fromFlag, *toFlag)
+	if err != nil {
+		return err
+	}
+
+	// Without a server, exports read imported local history
+	if svc.GetSettings().IsConfigured() {
+		if err := svc.Connect(); err != nil {
+			return err
+		}
+	}
+
+	if *output == "" {
+		opts := export.Options{Format: *format, Unit: svc.GetSettings().Unit}
+		if *format == export.FormatTidepool {
+			opts.Profiles, _ = svc.GetProfiles()
+		}
+		_, err := export.Export(out, serviceSource{svc}, from, to, opts)
+		return err
+	}
+
+	file, err := svc.ExportToFile(*output, *format, from, to)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(out, "Exported %d entries and %d treatments to %s\n", file.Entries, file.Treatments, file.Path)
+	return nil
+}
+
+// exportRange resolves the export flags into a time window. Dates are local
+// days; --to includes the whole day.
+func exportRange(days int, fromFlag, toFlag string) (time.Time, time.Time, error) {
+	to := time.Now()
+	if toFlag != "" {
+		day, err := time.ParseInLocation(time.DateOnly, toFlag, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("--to: %w", err)
+		}
+		to = day.AddDate(0, 0, 1)
+	}
+
+	if fromFlag == "" {
+		if days < 1 {
+			return time.Time{}, time.Time{}, fmt.Errorf("--days must be at least 1")
+		}
+		return to.AddDate(0, 0, -days), to, nil
+	}
+
+	from, err := time.ParseInLocation(time.DateOnly, fromFlag, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("--from: %w", err)
+	}
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("--from must be before --to")
+	}
+	return from, to, nil
+}
+
+func runImport(svc *app.NightscoutService, args []string, out io.Writer) error {
+	fs := newFlagSet("import")
+	format := fs.String("format", importer.FormatAuto, "file format: "+strings.Join(importer.Formats, ", "))
+	timezone := fs.String("timezone", "", "time zone the device clock was set to, e.g. Europe/Berlin (default: system zone)")
+	upload := fs.Bool("upload", false, "also upload records Nightscout doesn't have yet")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: import [--format F] [--timezone TZ] [--upload] FILE")
+	}
+
+	// A running app keeps its own copy of the history and would drop the
+	// imported records when it next compacts
+	configDir, err := models.GetConfigDir()
+	if err != nil {
+		return err
+	}
+	inst, err := instance.Acquire(configDir)
+	if errors.Is(err, instance.ErrAlreadyRunning) {
+		return fmt.Errorf("quit the running app before importing, or import from the app")
+	} else if err != nil {
+		return err
+	}
+	defer func() {
+		_ = inst.Close()
+	}()
+
+	if *upload {
+		if err := svc.Connect(); err != nil {
+			return err
+		}
+	}
+
+	result, err := svc.ImportHistory(fs.Arg(0), *format, *timezone, *upload)
+	if result != nil {
+		printImport(out, result)
+	}
+	return err
+}
+
+func printImport(out io.Writer, r *app.ImportResult) {
+	_, _ = fmt.Fprintf(out, "Read %d rows from %s (%s)\n", r.Rows, r.Path, r.Format)
+	if !r.From.IsZero() {
+		_, _ = fmt.Fprintf(out, "  Period:        %s to %s\n", r.From.Local().Format("2006-01-02 15:04"), r.To.Local().Format("2006-01-02 15:04"))
+	}
+	_, _ = fmt.Fprintf(out, "  Readings:      %d (%d new)\n", len(r.Entries), r.EntriesAdded)
+	_, _ = fmt.Fprintf(out, "  Treatments:    %d (%d new)\n", len(r.Treatments), r.TreatmentsAdded)
+	if r.BeyondRetention > 0 {
+		_, _ = fmt.Fprintf(out, "  Not stored:    %d older than the history retention\n", r.BeyondRetention)
+	}
+
+	reasons := make([]string, 0, len(r.Skipped))
+	for reason := range r.Skipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		_, _ = fmt.Fprintf(out, "  Skipped:       %d %s\n", r.Skipped[reason], reason)
+	}
+
+	if r.Uploaded {
+		_, _ = fmt.Fprintf(out, "  Uploaded:      %d readings, %d treatments (%d already on Nightscout)\n",
+			r.EntriesUploaded, r.TreatmentsUploaded, r.AlreadyOnServer)
+	}
+}
+
+// Helpers
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func formatValue(mgdl int, mmol float64, unit string) string {
+	if unit == unitMmolL {
+		return fmt.Sprintf("%.1f", mmol)
+	}
+	return fmt.Sprintf("%d", mgdl)
+}
+
+func formatMgdl(mgdl float64, unit string) string {
+	if unit == unitMmolL {
+		return fmt.Sprintf("%.1f", models.ToMmol(mgdl))
+	}
+	return fmt.Sprintf("%.0f", mgdl)
+}
+
+func formatAge(minutes int) string {
+	switch {
+	case minutes < 1:
+		return "just now"
+	case minutes == 1:
+		return "1 minute ago"
+	case minutes < 60:
+		return fmt.Sprintf("%d minutes ago", minutes)
+	default:
+		return fmt.Sprintf("%dh %dm ago", minutes/60, minutes%60)
+	}
+}
+
+// bucketAverage reduces values to at most width points by averaging consecutive buckets
+func bucketAverage(values []float64, width int) []float64 {
+	if len(values) <= width {
+		return values
+	}
+
+	result := make([]float64, width)
+	for i := 0; i < width; i++ {
+		start := i * len(values) / width
+		end := (i + 1) * len(values) / width
+		if end <= start {
+			end = start + 1
+		}
+		var sum float64
+		for _, v := range values[start:end] {
+			sum += v
+		}
+		result[i] = sum / float64(end-start)
+	}
+	return result
+}
+
+func runDiagnostics(svc *app.NightscoutService, args []string, out io.Writer) error {
+	fs := newFlagSet("diagnostics")
+	output := fs.String("output", "", "write the bundle to this file instead of the config directory")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if svc.GetSettings().IsConfigured() {
+		if err := svc.Connect(); err != nil {
+			return err
+		}
+	}
+
+	path := *output
+	if path == "" {
+		var err error
+		if path, err = svc.CreateDiagnosticBundle(); err != nil {
+			return err
+		}
+	} else if err := svc.WriteDiagnosticBundle(path); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(out, "Diagnostic bundle written to %s\n", path)
+	return nil
+}
+
+func runBackup(svc *app.NightscoutService, args []string, out io.Writer) error {
+	fs := newFlagSet("backup")
+	output := fs.String("output", "", "write the backup to this file instead of the config directory")
+	encrypt := fs.Bool("encrypt", false, "encrypt the backup and include credentials (passphrase from "+passphraseEnv+")")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	passphrase := ""
+	if *encrypt {
+		passphrase = os.Getenv(passphraseEnv)
+		if passphrase == "" {
+			return fmt.Errorf("--encrypt needs a passphrase in %s", passphraseEnv)
+		}
+	}
+
+	var info *app.BackupInfo
+	var err error
+	if *output == "" {
+		info, err = svc.CreateBackup(passphrase)
+	} else {
+		info, err = svc.WriteBackup(*output, passphrase)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(out, "Backup written to %s (%d history files)\n", info.Path, info.HistoryFiles)
+	if !info.Encrypted {
+		_, _ = fmt.Fprintln(out, "Credentials were not included; use --encrypt to back them up")
+	}
+	return nil
+}
+
+func runRestore(svc *app.NightscoutService, args []string, out io.Writer) error {
+	fs := newFlagSet("restore")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: restore FILE")
+	}
+
+	// A running app would keep writing its own settings and history
+	configDir, err := models.GetConfigDir()
+	if err != nil {
+		return err
+	}
+	inst, err := instance.Acquire(configDir)
+	if errors.Is(err, instance.ErrAlreadyRunning) {
+		return fmt.Errorf("quit the running app before restoring")
+	} else if err != nil {
+		return err
+	}
+	defer func() {
+		_ = inst.Close()
+	}()
+
+	info, err := svc.RestoreBackup(fs.Arg(0), os.Getenv(passphraseEnv))
+	if errors.Is(err, backup.ErrPassphraseRequired) {
+		return fmt.Errorf("%w (set %s)", err, passphraseEnv)
+	} else if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(out, "Restored backup from %s (%d history files)\n",
+		info.CreatedAt.Local().Format("2006-01-02 15:04"), info.HistoryFiles)
+	return nil
+}
+
+func runReport(svc *app.NightscoutService, args []string, out io.Writer) error {
+	fs := newFlagSet("report")
+	days := fs.Int("days", 14, "days covered by the report")
+	output := fs.String("output", "", "directory for the report files (default: reports folder in the config directory)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *days < 1 {
+		return fmt.Errorf("--days must be at least 1")
+	}
+
+	// Without a server, reports cover imported local history
+	if svc.GetSettings().IsConfigured() {
+		if err := svc.Connect(); err != nil {
+			return err
+		}
+	}
+
+	to := time.Now()
+	from := to.AddDate(0, 0, -*days)
+
+	var files *app.ReportFiles
+	var err error
+	if *output == "" {
+		files, err = svc.GenerateReport(from, to)
+	} else {
+		files, err = svc.WriteReport(*output, from, to)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(out, "Report written to\n  %s\n  %s\n", files.HTML, files.PDF)
+	return nil
+}
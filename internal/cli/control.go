@@ -0,0 +1,112 @@
+// Package cli implements the headless daemon and command-line subcommands
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/app"
+	"github.com/mrcode/nightscout-tray/internal/instance"
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// ControlHandler answers IPC requests from later launches. show is called for
+// the "show" command and may be nil when running without a window.
+func ControlHandler(svc *app.NightscoutService, show func(), quit func()) instance.Handler {
+	return func(req instance.Request) instance.Response {
+		switch req.Command {
+		case instance.CommandShow:
+			if show == nil {
+				return instance.Response{Message: "no window in headless mode"}
+			}
+			show()
+			return instance.Response{OK: true}
+
+		case instance.CommandSnooze:
+			arg := ""
+			if len(req.Args) > 0 {
+				arg = req.Args[0]
+			}
+			d, err := instance.ParseSnoozeDuration(arg)
+			if err != nil {
+				return instance.Response{Message: err.Error()}
+			}
+			until := svc.SnoozeAlertsFor(d)
+			if until.IsZero() {
+				return instance.Response{OK: true, Message: "Alerts active"}
+			}
+			return instance.Response{OK: true, Message: "Alerts snoozed until " + until.Format("15:04")}
+
+		case instance.CommandRefresh:
+			status, err := svc.RefreshNow()
+			if err != nil {
+				return instance.Response{Message: err.Error()}
+			}
+			return instance.Response{OK: true, Message: describeStatus(status, svc.GetSettings().Unit)}
+
+		case instance.CommandStatus:
+			status := svc.GetCurrentStatus()
+			if status == nil {
+				return instance.Response{Message: "no reading yet"}
+			}
+			msg := describeStatus(status, svc.GetSettings().Unit)
+			if until := svc.GetSnoozedUntil(); !until.IsZero() {
+				msg += "\nAlerts snoozed until " + until.Format("15:04")
+			}
+			return instance.Response{OK: true, Message: msg}
+
+		case instance.CommandQuit:
+			if quit == nil {
+				return instance.Response{Message: "quit not supported"}
+			}
+			// Answer before shutting down so the caller gets a response
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				quit()
+			}()
+			return instance.Response{OK: true, Message: "Quitting"}
+		}
+
+		return instance.Response{Message: fmt.Sprintf("unknown command %q", req.Command)}
+	}
+}
+
+// Forward sends a control request to the running instance and prints its reply.
+// It returns the process exit code.
+func Forward(req instance.Request) int {
+	return forward(req, os.Stdout, os.Stderr)
+}
+
+func forward(req instance.Request, out, errOut io.Writer) int {
+	dir, err := models.GetConfigDir()
+	if err != nil {
+		_, _ = fmt.Fprintf(errOut, "%v\n", err)
+		return 1
+	}
+
+	resp, err := instance.Send(dir, req)
+	if err != nil {
+		_, _ = fmt.Fprintf(errOut, "%v\n", err)
+		return 1
+	}
+
+	if resp.Message != "" {
+		w := out
+		if !resp.OK {
+			w = errOut
+		}
+		_, _ = fmt.Fprintln(w, resp.Message)
+	}
+	if !resp.OK {
+		return 1
+	}
+	return 0
+}
+
+func describeStatus(status *models.GlucoseStatus, unit string) string {
+	return fmt.Sprintf("%s %s %s (%s, %s)",
+		formatValue(status.Value, status.ValueMmol, unit), unit, status.Trend,
+		status.Status, formatAge(status.StaleMinutes))
+}
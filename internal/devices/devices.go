@@ -0,0 +1,123 @@
+// Package devices tracks how long the sensor, infusion site, insulin
+// reservoir and pump battery have been in use, from the change events
+// logged as treatments
+package devices
+
+import (
+	"math"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// Device is a tracked device and the treatment events that mark its change
+type Device struct {
+	Name       string // One of the models.Device kinds
+	Label      string
+	EventTypes []string
+}
+
+// Tracked lists the tracked devices in display order
+var Tracked = []Device{
+	{models.DeviceSensor, "SAGE", []string{models.TreatmentEventTypes.SensorStart, models.TreatmentEventTypes.SensorChange}},
+	{models.DeviceSite, "CAGE", []string{models.TreatmentEventTypes.SiteChange}},
+	{models.DeviceInsulin, "IAGE", []string{models.TreatmentEventTypes.InsulinChange}},
+	{models.DeviceBattery, "BAGE", []string{models.TreatmentEventTypes.PumpBatteryChange}},
+}
+
+// IsChange reports whether t marks a device change
+func IsChange(t *models.Treatment) bool {
+	for _, d := range Tracked {
+		if matches(d, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Thresholds are the ages at which a device needs attention. Zero disables
+// a level.
+type Thresholds struct {
+	Warn   time.Duration
+	Urgent time.Duration
+}
+
+// ThresholdsFromSettings returns the configured thresholds by device name
+func ThresholdsFromSettings(settings *models.Settings) map[string]Thresholds {
+	hours := func(h int) time.Duration { return time.Duration(h) * time.Hour }
+	return map[string]Thresholds{
+		models.DeviceSensor:  {hours(settings.SensorAgeWarnHours), hours(settings.SensorAgeUrgentHours)},
+		models.DeviceSite:    {hours(settings.SiteAgeWarnHours), hours(settings.SiteAgeUrgentHours)},
+		models.DeviceInsulin: {hours(settings.InsulinAgeWarnHours), hours(settings.InsulinAgeUrgentHours)},
+		models.DeviceBattery: {hours(settings.BatteryAgeWarnHours), hours(settings.BatteryAgeUrgentHours)},
+	}
+}
+
+// Latest returns the newest change event of each device in treatments,
+// keyed by device name
+func Latest(treatments []models.Treatment) map[string]models.Treatment {
+	latest := make(map[string]models.Treatment)
+	for _, d := range Tracked {
+		for _, t := range treatments {
+			if !matches(d, &t) {
+				continue
+			}
+			if prev, ok := latest[d.Name]; !ok || t.Time().After(prev.Time()) {
+				latest[d.Name] = t
+			}
+		}
+	}
+	return latest
+}
+
+// Ages returns the age of every tracked device at now, counted from its
+// change in changes. Devices without a change have level unknown.
+func Ages(changes map[string]models.Treatment, now time.Time, thresholds map[string]Thresholds) []models.DeviceAge {
+	ages := make([]models.DeviceAge, 0, len(Tracked))
+	for _, d := range Tracked {
+		age := models.DeviceAge{Device: d.Name, Label: d.Label, Level: models.DeviceAgeUnknown}
+		if change, ok := changes[d.Name]; ok {
+			changedAt := change.Time()
+			elapsed := max(now.Sub(changedAt), 0)
+			age.EventType = change.EventType
+			age.ChangedAt = &changedAt
+			age.AgeHours = math.Round(elapsed.Hours()*10) / 10
+			age.Level = level(elapsed, thresholds[d.Name])
+			age.Notes = change.Notes
+		}
+		ages = append(ages, age)
+	}
+	return ages
+}
+
+// WarmingUp reports whether a reading at t was taken within warmUp of the
+// sensor change in ages, when readings are often inaccurate
+func WarmingUp(ages []models.DeviceAge, t time.Time, warmUp time.Duration) bool {
+	for _, age := range ages {
+		if age.Device != models.DeviceSensor || age.ChangedAt == nil {
+			continue
+		}
+		return !t.Before(*age.ChangedAt) && t.Before(age.ChangedAt.Add(warmUp))
+	}
+	return false
+}
+
+func matches(d Device, t *models.Treatment) bool {
+	for _, eventType := range d.EventTypes {
+		if t.EventType == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func level(age time.Duration, th Thresholds) string {
+	switch {
+	case th.Urgent > 0 && age >= th.Urgent:
+		return models.DeviceAgeUrgent
+	case th.Warn > 0 && age >= th.Warn:
+		return models.DeviceAgeWarn
+	default:
+		return models.DeviceAgeOK
+	}
+}
@@ -0,0 +1,193 @@
+// Package diagnostics builds a zip bundle with logs, sanitized settings and
+// version information to attach to bug reports
+package diagnostics
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/logging"
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// Version is the application version, set at build time with
+// -ldflags "-X github.com/mrcode/nightscout-tray/internal/diagnostics.Version=1.2.3"
+var Version = "dev"
+
+// Bundle is the content of a diagnostic bundle
+type Bundle struct {
+	LogDir     string           // Directory holding the log files
+	Settings   *models.Settings // Current settings; sanitized before writing
+	Connection any              // Connection diagnostics, written as JSON
+}
+
+// VersionInfo describes the running build
+type VersionInfo struct {
+	Version     string `json:"version"`
+	GoVersion   string `json:"goVersion"`
+	OS          string `json:"os"`
+	Arch        string `json:"arch"`
+	Revision    string `json:"revision,omitempty"`
+	CommitTime  string `json:"commitTime,omitempty"`
+	Modified    bool   `json:"modified,omitempty"`
+	WailsModule string `json:"wails,omitempty"`
+}
+
+// CurrentVersion returns version information for the running binary
+func CurrentVersion() VersionInfo {
+	info := VersionInfo{
+		Version:   Version,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, s := range build.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.time":
+			info.CommitTime = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	for _, dep := range build.Deps {
+		if dep.Path == "github.com/wailsapp/wails/v3" {
+			info.WailsModule = dep.Version
+		}
+	}
+	return info
+}
+
+// WriteFile writes the bundle as a zip archive to path
+func WriteFile(path string, b Bundle) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600) //nolint:gosec // Path chosen by the app or the user running the CLI
+	if err != nil {
+		return err
+	}
+	if err := Write(f, b); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// Write writes the bundle as a zip archive to w
+func Write(w io.Writer, b Bundle) error {
+	zw := zip.NewWriter(w)
+
+	if err := writeJSON(zw, "version.json", CurrentVersion()); err != nil {
+		return err
+	}
+	if b.Settings != nil {
+		if err := writeJSON(zw, "settings.json", SanitizeSettings(b.Settings)); err != nil {
+			return err
+		}
+	}
+	if b.Connection != nil {
+		if err := writeJSON(zw, "connection.json", b.Connection); err != nil {
+			return err
+		}
+	}
+	if b.LogDir != "" {
+		for _, path := range logging.LogFiles(b.LogDir) {
+			if err := addLog(zw, path); err != nil {
+				return fmt.Errorf("adding %s: %w", filepath.Base(path), err)
+			}
+		}
+	}
+
+	return zw.Close()
+}
+
+// SanitizeSettings returns a copy of settings without credentials. Webhook
+// URLs are reduced to their host since they often embed tokens.
+func SanitizeSettings(settings *models.Settings) *models.Settings {
+	clean := settings.Clone()
+	clean.APISecret = redactIfSet(clean.APISecret)
+	clean.APIToken = redactIfSet(clean.APIToken)
+	clean.NightscoutURL = stripURL(clean.NightscoutURL, false)
+	for i := range clean.Webhooks {
+		clean.Webhooks[i].Secret = redactIfSet(clean.Webhooks[i].Secret)
+		clean.Webhooks[i].URL = stripURL(clean.Webhooks[i].URL, true)
+	}
+	return clean
+}
+
+func redactIfSet(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+// stripURL removes credentials and query strings, and the path if hostOnly is set
+func stripURL(raw string, hostOnly bool) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return logging.Redact(raw)
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	if hostOnly {
+		u.Path = ""
+		u.RawPath = ""
+	}
+	return u.String()
+}
+
+func writeJSON(zw *zip.Writer, name string, v any) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// addLog copies a log file into the archive, redacting each line again in
+// case it was written by an older version without redaction
+func addLog(zw *zip.Writer, path string) error {
+	f, err := os.Open(path) //nolint:gosec // Log files in the app's log directory
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "logs/" + filepath.Base(path), Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if _, err := io.WriteString(w, logging.Redact(scanner.Text())+"\n"); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,109 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// csvHeader names the columns of a CSV export. Entries and treatments share
+// one table; columns that don't apply to a record are left empty.
+var csvHeader = []string{
+	"record_type", "timestamp_utc", "local_date", "local_time", "utc_offset",
+	"glucose", "glucose_unit", "glucose_mgdl", "direction",
+	"event_type", "insulin_u", "carbs_g", "protein_g", "fat_g",
+	"duration_min", "basal_rate_u_h", "basal_percent", "notes", "device", "id",
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	opts    Options
+	started bool
+}
+
+func newCSVWriter(w io.Writer, opts Options) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w), opts: opts}
+}
+
+func (c *csvWriter) write(entries []models.GlucoseEntry, treatments []models.Treatment) error {
+	if !c.started {
+		c.started = true
+		if err := c.w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	for _, r := range merge(entries, treatments) {
+		row := make([]string, len(csvHeader))
+		local := r.time.In(c.opts.Location)
+		row[1] = r.time.UTC().Format(time.RFC3339)
+		row[2] = local.Format("2006-01-02")
+		row[3] = local.Format("15:04:05")
+		row[4] = local.Format("-07:00")
+
+		if e := r.entry; e != nil {
+			row[0] = "glucose"
+			c.glucose(row, float64(e.SGV))
+			row[8] = e.Direction
+			row[18] = e.Device
+			row[19] = e.ID
+		} else {
+			t := r.treatment
+			row[0] = "treatment"
+			c.glucose(row, treatmentGlucoseMgdl(t))
+			row[9] = t.EventType
+			row[10] = number(t.Insulin, 2)
+			row[11] = number(t.Carbs, 1)
+			row[12] = number(t.Protein, 1)
+			row[13] = number(t.Fat, 1)
+			row[14] = number(t.Duration, 0)
+			row[15] = number(t.Absolute, 3)
+			row[16] = number(t.Percent, 0)
+			row[17] = t.Notes
+			row[18] = t.Device
+			if row[18] == "" {
+				row[18] = t.EnteredBy
+			}
+			row[19] = t.ID
+		}
+		if err := c.w.Write(row); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// glucose fills the glucose columns of row for a value in mg/dL
+func (c *csvWriter) glucose(row []string, mgdl float64) {
+	if mgdl <= 0 {
+		return
+	}
+	row[5] = number(mgdl, 0)
+	row[6] = "mg/dL"
+	if c.opts.Unit == unitMmolL {
+		row[5] = number(models.ToMmol(mgdl), 1)
+		row[6] = unitMmolL
+	}
+	row[7] = number(mgdl, 0)
+}
+
+func (c *csvWriter) close() error {
+	if !c.started {
+		if err := c.w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// number formats v with up to precision decimals, or "" for zero
+func number(v float64, precision int) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', precision, 64)
+}
@@ -0,0 +1,218 @@
+// Package export writes glucose entries and treatments to files in formats
+// other tools understand. Data is read and written in windows, so large date
+// ranges never have to fit in memory at once.
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// Supported formats
+const (
+	FormatJSON     = "json"     // One JSON document with entries and treatments arrays
+	FormatCSV      = "csv"      // One flat row per record
+	FormatNDJSON   = "ndjson"   // One JSON record per line
+	FormatFHIR     = "fhir"     // HL7 FHIR R4 collection Bundle
+	FormatTidepool = "tidepool" // Tidepool platform data array
+)
+
+// Formats lists the supported formats
+var Formats = []string{FormatJSON, FormatCSV, FormatNDJSON, FormatFHIR, FormatTidepool}
+
+// windowDays is how much history is loaded and written at a time
+const windowDays = 7
+
+const unitMmolL = "mmol/L"
+
+// Source loads records in [from, to], oldest first
+type Source interface {
+	Entries(from, to time.Time) ([]models.GlucoseEntry, error)
+	Treatments(from, to time.Time) ([]models.Treatment, error)
+}
+
+// Options controls Export
+type Options struct {
+	Format   string
+	Unit     string         // Display unit for glucose values; values in mg/dL are always included
+	Location *time.Location // Time zone of local time columns; nil uses time.Local
+
+	// Profiles are the Nightscout profile documents, oldest first. Tidepool
+	// exports use them for pump settings and scheduled basal rates.
+	Profiles []models.ProfileStore
+}
+
+// Summary describes a finished export
+type Summary struct {
+	Format     string    `json:"format"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Entries    int       `json:"entries"`
+	Treatments int       `json:"treatments"`
+}
+
+// recordWriter is implemented by each format. write is called once per
+// window with records sorted by time; close finishes the document.
+type recordWriter interface {
+	write(entries []models.GlucoseEntry, treatments []models.Treatment) error
+	close() error
+}
+
+// FileExtension returns the usual file extension for format
+func FileExtension(format string) string {
+	if format == FormatNDJSON {
+		return ".ndjson"
+	}
+	if format == FormatFHIR {
+		return ".fhir.json"
+	}
+	if format == FormatTidepool {
+		return ".tidepool.json"
+	}
+	return "." + format
+}
+
+// ValidFormat reports whether format is supported
+func ValidFormat(format string) bool {
+	for _, f := range Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// Export writes all records in [from, to] from src to w
+func Export(w io.Writer, src Source, from, to time.Time, opts Options) (*Summary, error) {
+	if !ValidFormat(opts.Format) {
+		return nil, fmt.Errorf("unknown export format %q (use %s)", opts.Format, strings.Join(Formats, ", "))
+	}
+	if !to.After(from) {
+		return nil, fmt.Errorf("invalid time window: %s to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+
+	bw := bufio.NewWriter(w)
+	rw := newRecordWriter(bw, from, to, opts)
+	summary := &Summary{Format: opts.Format, From: from, To: to}
+
+	for start := from; start.Before(to); {
+		end := start.AddDate(0, 0, windowDays)
+		last := !end.Before(to)
+		if last {
+			end = to
+		}
+
+		entries, err := src.Entries(start, end)
+		if err != nil {
+			return nil, fmt.Errorf("loading entries: %w", err)
+		}
+		treatments, err := src.Treatments(start, end)
+		if err != nil {
+			return nil, fmt.Errorf("loading treatments: %w", err)
+		}
+
+		// Sources include both ends; a record on a window boundary belongs
+		// to the later window
+		entries = filterEntries(entries, start, end, last)
+		treatments = filterTreatments(treatments, start, end, last)
+
+		if err := rw.write(entries, treatments); err != nil {
+			return nil, err
+		}
+		summary.Entries += len(entries)
+		summary.Treatments += len(treatments)
+		start = end
+	}
+
+	if err := rw.close(); err != nil {
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+func newRecordWriter(w io.Writer, from, to time.Time, opts Options) recordWriter {
+	switch opts.Format {
+	case FormatCSV:
+		return newCSVWriter(w, opts)
+	case FormatNDJSON:
+		return newNDJSONWriter(w, opts)
+	case FormatFHIR:
+		return newFHIRWriter(w, opts)
+	case FormatTidepool:
+		return newTidepoolWriter(w, from, to, opts)
+	default:
+		return newJSONWriter(w, from, to)
+	}
+}
+
+func filterEntries(entries []models.GlucoseEntry, from, to time.Time, inclusive bool) []models.GlucoseEntry {
+	out := make([]models.GlucoseEntry, 0, len(entries))
+	for _, e := range entries {
+		t := e.Time()
+		if t.Before(from) || t.After(to) || (!inclusive && t.Equal(to)) {
+			continue
+		}
+		out = append(out, e)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Date < out[j].Date })
+	return out
+}
+
+func filterTreatments(treatments []models.Treatment, from, to time.Time, inclusive bool) []models.Treatment {
+	out := make([]models.Treatment, 0, len(treatments))
+	for _, t := range treatments {
+		at := t.Time()
+		if at.Before(from) || at.After(to) || (!inclusive && at.Equal(to)) {
+			continue
+		}
+		out = append(out, t)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Time().Before(out[j].Time()) })
+	return out
+}
+
+// record is one entry or treatment, used by formats that interleave both
+type record struct {
+	time      time.Time
+	entry     *models.GlucoseEntry
+	treatment *models.Treatment
+}
+
+// merge interleaves entries and treatments by time
+func merge(entries []models.GlucoseEntry, treatments []models.Treatment) []record {
+	out := make([]record, 0, len(entries)+len(treatments))
+	i, j := 0, 0
+	for i < len(entries) || j < len(treatments) {
+		if j >= len(treatments) || (i < len(entries) && !entries[i].Time().After(treatments[j].Time())) {
+			out = append(out, record{time: entries[i].Time(), entry: &entries[i]})
+			i++
+			continue
+		}
+		out = append(out, record{time: treatments[j].Time(), treatment: &treatments[j]})
+		j++
+	}
+	return out
+}
+
+// treatmentGlucoseMgdl returns a treatment's blood glucose in mg/dL, or 0
+func treatmentGlucoseMgdl(t *models.Treatment) float64 {
+	if t.Glucose <= 0 {
+		return 0
+	}
+	if strings.EqualFold(t.Units, "mmol") || strings.EqualFold(t.Units, "mmol/l") {
+		return models.ToMgdl(t.Glucose)
+	}
+	return t.Glucose
+}
@@ -0,0 +1,329 @@
+package export
+
+import (
+	"crypto/sha1" //nolint:gosec // Only used to derive stable resource ids
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// Code systems and codes used in FHIR exports
+const (
+	systemLOINC    = "http://loinc.org"
+	systemSNOMED   = "http://snomed.info/sct"
+	systemUCUM     = "http://unitsofmeasure.org"
+	systemCategory = "http://terminology.hl7.org/CodeSystem/observation-category"
+	systemEntry    = "urn:nightscout:entry"
+	systemTreat    = "urn:nightscout:treatment"
+)
+
+var (
+	codeSensorGlucose    = fhirCoding{System: systemLOINC, Code: "99504-3", Display: "Glucose [Mass/volume] in Interstitial fluid"}
+	codeBloodGlucose     = fhirCoding{System: systemLOINC, Code: "2339-0", Display: "Glucose [Mass/volume] in Blood"}
+	codeCapillaryGlucose = fhirCoding{System: systemLOINC, Code: "41653-7", Display: "Glucose [Mass/volume] in Capillary blood by Glucometer"}
+	codeCarbIntake       = fhirCoding{System: systemLOINC, Code: "9059-7", Display: "Carbohydrate intake Estimated"}
+	codeInsulin          = fhirCoding{System: systemSNOMED, Code: "67866001", Display: "Insulin"}
+	categoryLaboratory   = fhirCoding{System: systemCategory, Code: "laboratory", Display: "Laboratory"}
+	categoryActivity     = fhirCoding{System: systemCategory, Code: "activity", Display: "Activity"}
+)
+
+type fhirCoding struct {
+	System  string `json:"system"`
+	Code    string `json:"code"`
+	Display string `json:"display,omitempty"`
+}
+
+type fhirConcept struct {
+	Coding []fhirCoding `json:"coding,omitempty"`
+	Text   string       `json:"text,omitempty"`
+}
+
+type fhirQuantity struct {
+	Value  float64 `json:"value"`
+	Unit   string  `json:"unit"`
+	System string  `json:"system"`
+	Code   string  `json:"code"`
+}
+
+type fhirReference struct {
+	Reference string `json:"reference,omitempty"`
+	Display   string `json:"display,omitempty"`
+}
+
+type fhirIdentifier struct {
+	System string `json:"system"`
+	Value  string `json:"value"`
+}
+
+type fhirPeriod struct {
+	Start string `json:"start"`
+	End   string `json:"end,omitempty"`
+}
+
+type fhirAnnotation struct {
+	Text string `json:"text"`
+}
+
+type fhirObservation struct {
+	ResourceType      string           `json:"resourceType"`
+	ID                string           `json:"id"`
+	Identifier        []fhirIdentifier `json:"identifier,omitempty"`
+	Status            string           `json:"status"`
+	Category          []fhirConcept    `json:"category,omitempty"`
+	Code              fhirConcept      `json:"code"`
+	Subject           fhirReference    `json:"subject"`
+	EffectiveDateTime string           `json:"effectiveDateTime"`
+	ValueQuantity     fhirQuantity     `json:"valueQuantity"`
+	Device            *fhirReference   `json:"device,omitempty"`
+	Note              []fhirAnnotation `json:"note,omitempty"`
+}
+
+type fhirDosage struct {
+	Dose         *fhirQuantity `json:"dose,omitempty"`
+	RateQuantity *fhirQuantity `json:"rateQuantity,omitempty"`
+}
+
+type fhirMedicationAdministration struct {
+	ResourceType              string           `json:"resourceType"`
+	ID                        string           `json:"id"`
+	Identifier                []fhirIdentifier `json:"identifier,omitempty"`
+	Status                    string           `json:"status"`
+	MedicationCodeableConcept fhirConcept      `json:"medicationCodeableConcept"`
+	Subject                   fhirReference    `json:"subject"`
+	EffectiveDateTime         string           `json:"effectiveDateTime,omitempty"`
+	EffectivePeriod           *fhirPeriod      `json:"effectivePeriod,omitempty"`
+	Device                    []fhirReference  `json:"device,omitempty"`
+	Note                      []fhirAnnotation `json:"note,omitempty"`
+	Dosage                    fhirDosage       `json:"dosage"`
+}
+
+type fhirPatient struct {
+	ResourceType string `json:"resourceType"`
+	ID           string `json:"id"`
+}
+
+type fhirBundleEntry struct {
+	FullURL  string `json:"fullUrl"`
+	Resource any    `json:"resource"`
+}
+
+// fhirWriter streams a FHIR R4 collection Bundle. Sensor and meter glucose
+// become LOINC-coded Observations, carbs an intake Observation and insulin
+// MedicationAdministrations, all referring to one anonymous Patient.
+type fhirWriter struct {
+	w       io.Writer
+	opts    Options
+	started bool
+	patient fhirReference
+}
+
+func newFHIRWriter(w io.Writer, opts Options) *fhirWriter {
+	return &fhirWriter{w: w, opts: opts}
+}
+
+func (f *fhirWriter) start() error {
+	if f.started {
+		return nil
+	}
+	f.started = true
+
+	timestamp, _ := json.Marshal(time.Now().Format(time.RFC3339))
+	bundleID, _ := json.Marshal(resourceID("bundle", strconv.FormatInt(time.Now().UnixNano(), 10)))
+	if _, err := fmt.Fprintf(f.w, "{\n\"resourceType\": \"Bundle\",\n\"id\": %s,\n\"type\": \"collection\",\n\"timestamp\": %s,\n\"entry\": [\n",
+		bundleID, timestamp); err != nil {
+		return err
+	}
+
+	// Exports carry no demographics; the patient only ties resources together
+	patientID := resourceID("patient", "")
+	f.patient = fhirReference{Reference: "urn:uuid:" + patientID}
+	return f.entry(patientID, fhirPatient{ResourceType: "Patient", ID: patientID}, true)
+}
+
+func (f *fhirWriter) entry(id string, resource any, first bool) error {
+	data, err := json.Marshal(fhirBundleEntry{FullURL: "urn:uuid:" + id, Resource: resource})
+	if err != nil {
+		return err
+	}
+	if !first {
+		if _, err := io.WriteString(f.w, ",\n"); err != nil {
+			return err
+		}
+	}
+	_, err = f.w.Write(data)
+	return err
+}
+
+func (f *fhirWriter) write(entries []models.GlucoseEntry, treatments []models.Treatment) error {
+	if err := f.start(); err != nil {
+		return err
+	}
+	for _, r := range merge(entries, treatments) {
+		var resources []any
+		if r.entry != nil {
+			resources = f.entryResources(r.entry)
+		} else {
+			resources = f.treatmentResources(r.treatment)
+		}
+		for _, res := range resources {
+			if err := f.entry(fhirResourceID(res), res, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *fhirWriter) close() error {
+	if err := f.start(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(f.w, "\n]\n}\n")
+	return err
+}
+
+func (f *fhirWriter) entryResources(e *models.GlucoseEntry) []any {
+	if e.SGV <= 0 {
+		return nil
+	}
+	key := e.ID
+	if key == "" {
+		key = strconv.FormatInt(e.Date, 10)
+	}
+	obs := f.glucoseObservation(resourceID("entry", key), codeSensorGlucose, e.Time(), float64(e.SGV))
+	obs.Identifier = identifiers(systemEntry, e.ID)
+	if e.Device != "" {
+		obs.Device = &fhirReference{Display: e.Device}
+	}
+	return []any{obs}
+}
+
+func (f *fhirWriter) treatmentResources(t *models.Treatment) []any {
+	key := t.ID
+	if key == "" {
+		key = t.EventType + "@" + t.Time().UTC().Format(time.RFC3339Nano)
+	}
+	at := t.Time()
+	var out []any
+
+	if mgdl := treatmentGlucoseMgdl(t); mgdl > 0 {
+		code := codeBloodGlucose
+		if t.GlucoseType == "Finger" {
+			code = codeCapillaryGlucose
+		}
+		obs := f.glucoseObservation(resourceID("treatment-glucose", key), code, at, mgdl)
+		obs.Identifier = identifiers(systemTreat, t.ID)
+		out = append(out, obs)
+	}
+
+	if t.Carbs > 0 {
+		obs := &fhirObservation{
+			ResourceType:      "Observation",
+			ID:                resourceID("treatment-carbs", key),
+			Identifier:        identifiers(systemTreat, t.ID),
+			Status:            "final",
+			Category:          []fhirConcept{{Coding: []fhirCoding{categoryActivity}}},
+			Code:              fhirConcept{Coding: []fhirCoding{codeCarbIntake}, Text: "Carbohydrates"},
+			Subject:           f.patient,
+			EffectiveDateTime: fhirTime(at, f.opts.Location),
+			ValueQuantity:     fhirQuantity{Value: t.Carbs, Unit: "g", System: systemUCUM, Code: "g"},
+			Note:              notes(t.Notes),
+		}
+		out = append(out, obs)
+	}
+
+	switch {
+	case t.EventType == "Temp Basal" && t.Absolute > 0:
+		admin := f.insulinAdministration(resourceID("treatment-basal", key), t)
+		period := &fhirPeriod{Start: fhirTime(at, f.opts.Location)}
+		if t.Duration > 0 {
+			period.End = fhirTime(at.Add(time.Duration(t.Duration*float64(time.Minute))), f.opts.Location)
+		}
+		admin.EffectivePeriod = period
+		admin.Dosage.RateQuantity = &fhirQuantity{Value: round(t.Absolute, 3), Unit: "U/h", System: systemUCUM, Code: "[IU]/h"}
+		out = append(out, admin)
+	case t.Insulin > 0 && t.EventType != "Temp Basal":
+		admin := f.insulinAdministration(resourceID("treatment-insulin", key), t)
+		admin.EffectiveDateTime = fhirTime(at, f.opts.Location)
+		admin.Dosage.Dose = &fhirQuantity{Value: round(t.Insulin, 3), Unit: "U", System: systemUCUM, Code: "[IU]"}
+		out = append(out, admin)
+	}
+	return out
+}
+
+func (f *fhirWriter) glucoseObservation(id string, code fhirCoding, at time.Time, mgdl float64) *fhirObservation {
+	return &fhirObservation{
+		ResourceType:      "Observation",
+		ID:                id,
+		Status:            "final",
+		Category:          []fhirConcept{{Coding: []fhirCoding{categoryLaboratory}}},
+		Code:              fhirConcept{Coding: []fhirCoding{code}},
+		Subject:           f.patient,
+		EffectiveDateTime: fhirTime(at, f.opts.Location),
+		ValueQuantity:     fhirQuantity{Value: math.Round(mgdl), Unit: "mg/dL", System: systemUCUM, Code: "mg/dL"},
+	}
+}
+
+func (f *fhirWriter) insulinAdministration(id string, t *models.Treatment) *fhirMedicationAdministration {
+	admin := &fhirMedicationAdministration{
+		ResourceType:              "MedicationAdministration",
+		ID:                        id,
+		Identifier:                identifiers(systemTreat, t.ID),
+		Status:                    "completed",
+		MedicationCodeableConcept: fhirConcept{Coding: []fhirCoding{codeInsulin}, Text: t.EventType},
+		Subject:                   f.patient,
+		Note:                      notes(t.Notes),
+	}
+	if t.Device != "" {
+		admin.Device = []fhirReference{{Display: t.Device}}
+	}
+	return admin
+}
+
+// fhirResourceID returns the id of a resource built by this writer
+func fhirResourceID(resource any) string {
+	switch r := resource.(type) {
+	case *fhirObservation:
+		return r.ID
+	case *fhirMedicationAdministration:
+		return r.ID
+	}
+	return ""
+}
+
+// resourceID derives a stable UUID from a record key, so exporting the same
+// record twice yields the same resource id
+func resourceID(kind, key string) string {
+	sum := sha1.Sum([]byte(kind + "/" + key)) //nolint:gosec // Not used for security
+	sum[6] = sum[6]&0x0f | 0x50               // Version 5
+	sum[8] = sum[8]&0x3f | 0x80               // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func fhirTime(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format(time.RFC3339)
+}
+
+func identifiers(system, id string) []fhirIdentifier {
+	if id == "" {
+		return nil
+	}
+	return []fhirIdentifier{{System: system, Value: id}}
+}
+
+func notes(text string) []fhirAnnotation {
+	if text == "" {
+		return nil
+	}
+	return []fhirAnnotation{{Text: text}}
+}
+
+func round(v float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(v*p) / p
+}
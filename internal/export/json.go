@@ -0,0 +1,100 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// jsonWriter writes the document the export command has always produced:
+// {"exportedAt", "from", "to", "entries": [...], "treatments": [...]}.
+// Entries are streamed; treatments are far fewer and are held until the
+// entries array is closed.
+type jsonWriter struct {
+	w          io.Writer
+	started    bool
+	count      int
+	treatments []models.Treatment
+	from, to   time.Time
+}
+
+func newJSONWriter(w io.Writer, from, to time.Time) *jsonWriter {
+	return &jsonWriter{w: w, from: from, to: to}
+}
+
+func (j *jsonWriter) header() error {
+	if j.started {
+		return nil
+	}
+	j.started = true
+	exported, _ := json.Marshal(time.Now())
+	from, _ := json.Marshal(j.from)
+	to, _ := json.Marshal(j.to)
+	_, err := fmt.Fprintf(j.w, "{\n  \"exportedAt\": %s,\n  \"from\": %s,\n  \"to\": %s,\n  \"entries\": [", exported, from, to)
+	return err
+}
+
+func (j *jsonWriter) write(entries []models.GlucoseEntry, treatments []models.Treatment) error {
+	if err := j.header(); err != nil {
+		return err
+	}
+	for i := range entries {
+		if err := j.item(j.count, entries[i]); err != nil {
+			return err
+		}
+		j.count++
+	}
+	j.treatments = append(j.treatments, treatments...)
+	return nil
+}
+
+func (j *jsonWriter) item(index int, v any) error {
+	data, err := json.MarshalIndent(v, "    ", "  ")
+	if err != nil {
+		return err
+	}
+	sep := ",\n    "
+	if index == 0 {
+		sep = "\n    "
+	}
+	if _, err := io.WriteString(j.w, sep); err != nil {
+		return err
+	}
+	_, err = j.w.Write(data)
+	return err
+}
+
+// closeArray ends an array opened with count items written
+func (j *jsonWriter) closeArray(count int) error {
+	end := "]"
+	if count > 0 {
+		end = "\n  ]"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+func (j *jsonWriter) close() error {
+	if err := j.header(); err != nil {
+		return err
+	}
+	if err := j.closeArray(j.count); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(j.w, ",\n  \"treatments\": ["); err != nil {
+		return err
+	}
+	for i := range j.treatments {
+		if err := j.item(i, j.treatments[i]); err != nil {
+			return err
+		}
+	}
+	if err := j.closeArray(len(j.treatments)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(j.w, "\n}\n")
+	return err
+}
@@ -0,0 +1,60 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// ndjsonRecord is one line of an NDJSON export: the original record with
+// its local time and glucose in the display unit alongside
+type ndjsonRecord struct {
+	Type      string               `json:"type"` // "entry" or "treatment"
+	Time      string               `json:"time"` // Local time with UTC offset
+	Glucose   float64              `json:"glucose,omitempty"`
+	Unit      string               `json:"unit,omitempty"`
+	Entry     *models.GlucoseEntry `json:"entry,omitempty"`
+	Treatment *models.Treatment    `json:"treatment,omitempty"`
+}
+
+type ndjsonWriter struct {
+	enc  *json.Encoder
+	opts Options
+}
+
+func newNDJSONWriter(w io.Writer, opts Options) *ndjsonWriter {
+	return &ndjsonWriter{enc: json.NewEncoder(w), opts: opts}
+}
+
+func (n *ndjsonWriter) write(entries []models.GlucoseEntry, treatments []models.Treatment) error {
+	for _, r := range merge(entries, treatments) {
+		line := ndjsonRecord{Time: r.time.In(n.opts.Location).Format(time.RFC3339)}
+		var mgdl float64
+		if r.entry != nil {
+			line.Type = "entry"
+			line.Entry = r.entry
+			mgdl = float64(r.entry.SGV)
+		} else {
+			line.Type = "treatment"
+			line.Treatment = r.treatment
+			mgdl = treatmentGlucoseMgdl(r.treatment)
+		}
+		if mgdl > 0 {
+			line.Glucose, line.Unit = math.Round(mgdl), "mg/dL"
+			if n.opts.Unit == unitMmolL {
+				line.Glucose, line.Unit = math.Round(models.ToMmol(mgdl)*10)/10, unitMmolL
+			}
+		}
+		if err := n.enc.Encode(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *ndjsonWriter) close() error {
+	return nil
+}
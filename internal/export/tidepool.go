@@ -0,0 +1,438 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/basal"
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+const (
+	// tidepoolDeviceID identifies this app as the source of exported data
+	tidepoolDeviceID = "nightscout-tray"
+
+	// tidepoolMaxBasal is the longest basal segment Tidepool accepts
+	tidepoolMaxBasal = 7 * 24 * time.Hour
+
+	// minSensorGlucose is the lowest real sensor value; Dexcom uses smaller
+	// numbers as error codes
+	minSensorGlucose = 39
+)
+
+// tidepoolDatum is one record in Tidepool's data model. Common fields are
+// filled in by tidepoolWriter.datum; the rest depend on the type.
+type tidepoolDatum map[string]any
+
+// tidepoolWriter writes a JSON array of Tidepool platform data:
+//
+//   - cbg for sensor readings, smbg for meter readings
+//   - bolus, or wizard with the bolus embedded when carbs were entered
+//   - food for carbs without insulin, insulin for long-acting injections
+//   - basal for temp basals, with the scheduled rate they replace, and
+//     scheduled segments between them when a profile is known
+//   - pumpSettings for each Nightscout profile in effect
+//
+// Every datum carries the UTC time, the device's local time and the offset
+// between them in minutes, as Tidepool requires.
+type tidepoolWriter struct {
+	w        io.Writer
+	opts     Options
+	from, to time.Time
+	started  bool
+	count    int
+
+	timeline    *basal.Timeline // Profiles and the switches seen so far
+	pending     *tidepoolTemp   // Temp basal whose end isn't known yet
+	basalCursor time.Time       // Basal delivery is written up to here
+}
+
+// tidepoolTemp is a running temp basal
+type tidepoolTemp struct {
+	treatment models.Treatment
+	start     time.Time
+	end       time.Time
+	continued bool // Resumed after a profile switch; only the first part carries the origin
+}
+
+func newTidepoolWriter(w io.Writer, from, to time.Time, opts Options) *tidepoolWriter {
+	return &tidepoolWriter{
+		w:           w,
+		opts:        opts,
+		from:        from,
+		to:          to,
+		timeline:    basal.NewTimeline(opts.Profiles, opts.Location),
+		basalCursor: from,
+	}
+}
+
+func (tp *tidepoolWriter) start() error {
+	if tp.started {
+		return nil
+	}
+	tp.started = true
+	if _, err := io.WriteString(tp.w, "["); err != nil {
+		return err
+	}
+	return tp.writePumpSettings()
+}
+
+func (tp *tidepoolWriter) emit(d tidepoolDatum) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if tp.count == 0 {
+		sep = "\n"
+	}
+	tp.count++
+	if _, err := io.WriteString(tp.w, sep); err != nil {
+		return err
+	}
+	_, err = tp.w.Write(data)
+	return err
+}
+
+// datum creates a record of the given type with the time fields set
+func (tp *tidepoolWriter) datum(kind string, at time.Time, originID string) tidepoolDatum {
+	local := at.In(tp.opts.Location)
+	_, offset := local.Zone()
+	d := tidepoolDatum{
+		"type":             kind,
+		"time":             at.UTC().Format("2006-01-02T15:04:05.000Z"),
+		"deviceTime":       local.Format("2006-01-02T15:04:05"),
+		"timezoneOffset":   offset / 60,
+		"conversionOffset": 0,
+		"clockDriftOffset": 0,
+		"deviceId":         tidepoolDeviceID,
+	}
+	if originID != "" {
+		d["origin"] = map[string]any{"id": originID, "name": "nightscout"}
+	}
+	return d
+}
+
+func (tp *tidepoolWriter) write(entries []models.GlucoseEntry, treatments []models.Treatment) error {
+	if err := tp.start(); err != nil {
+		return err
+	}
+	for _, r := range merge(entries, treatments) {
+		var err error
+		if r.entry != nil {
+			err = tp.writeEntry(r.entry)
+		} else {
+			err = tp.writeTreatment(r.treatment)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tp *tidepoolWriter) close() error {
+	if err := tp.start(); err != nil {
+		return err
+	}
+	if err := tp.flushBasal(tp.to); err != nil {
+		return err
+	}
+	end := "]\n"
+	if tp.count > 0 {
+		end = "\n]\n"
+	}
+	_, err := io.WriteString(tp.w, end)
+	return err
+}
+
+func (tp *tidepoolWriter) writeEntry(e *models.GlucoseEntry) error {
+	if e.SGV < minSensorGlucose {
+		return nil
+	}
+	d := tp.datum("cbg", e.Time(), e.ID)
+	d["units"] = "mg/dL"
+	d["value"] = e.SGV
+	return tp.emit(d)
+}
+
+func (tp *tidepoolWriter) writeTreatment(t *models.Treatment) error {
+	at := t.Time()
+	types := models.TreatmentEventTypes
+
+	switch {
+	case t.EventType == types.TempBasal:
+		return tp.startTemp(t)
+
+	case t.EventType == types.ProfileSwitch:
+		return tp.switchProfile(t.Profile, at)
+
+	case t.EventType == types.LongActingInsulin && t.Insulin > 0:
+		d := tp.datum("insulin", at, t.ID)
+		d["dose"] = map[string]any{"total": round(t.Insulin, 3), "units": "Units"}
+		return tp.emit(d)
+
+	case t.Insulin > 0 && t.IsBolus():
+		bolus := tp.datum("bolus", at, t.ID)
+		bolus["subType"] = "normal"
+		bolus["normal"] = round(t.Insulin, 3)
+		if t.Carbs <= 0 {
+			if err := tp.emit(bolus); err != nil {
+				return err
+			}
+			return tp.writeMeter(t, at)
+		}
+
+		// Boluses given for carbs are reported as calculator records, as a
+		// pump would, with the bolus embedded
+		wizard := tp.datum("wizard", at, t.ID)
+		wizard["bolus"] = bolus
+		wizard["carbInput"] = round(t.Carbs, 1)
+		wizard["units"] = "mg/dL"
+		if mgdl := treatmentGlucoseMgdl(t); mgdl > 0 {
+			wizard["bgInput"] = math.Round(mgdl)
+		}
+		tp.addCalculatorSettings(wizard, at)
+		return tp.emit(wizard)
+
+	case t.Carbs > 0:
+		d := tp.datum("food", at, t.ID)
+		d["nutrition"] = map[string]any{
+			"carbohydrate": map[string]any{"net": round(t.Carbs, 1), "units": "grams"},
+		}
+		if err := tp.emit(d); err != nil {
+			return err
+		}
+		return tp.writeMeter(t, at)
+	}
+
+	return tp.writeMeter(t, at)
+}
+
+// writeMeter writes a meter reading recorded with a treatment
+func (tp *tidepoolWriter) writeMeter(t *models.Treatment, at time.Time) error {
+	mgdl := treatmentGlucoseMgdl(t)
+	if mgdl <= 0 || (t.EventType != models.TreatmentEventTypes.BGCheck && t.GlucoseType != "Finger") {
+		return nil
+	}
+	d := tp.datum("smbg", at, t.ID)
+	d["units"] = "mg/dL"
+	d["value"] = math.Round(mgdl)
+	d["subType"] = "manual"
+	return tp.emit(d)
+}
+
+// addCalculatorSettings adds the ratios in effect at a bolus, converted to mg/dL
+func (tp *tidepoolWriter) addCalculatorSettings(d tidepoolDatum, at time.Time) {
+	store, _, profile := tp.timeline.ProfileAt(at)
+	if profile == nil {
+		return
+	}
+	factor := 1.0
+	if profile.GlucoseUnits(store.Units) == unitMmolL {
+		factor = models.MgdlPerMmol
+	}
+	seconds := basal.SecondsOfDay(at.In(profile.Location(tp.opts.Location)))
+
+	if icr := models.ScheduleValue(profile.CarbRatio, seconds); icr > 0 {
+		d["insulinCarbRatio"] = icr
+	}
+	if isf := models.ScheduleValue(profile.Sens, seconds); isf > 0 {
+		d["insulinSensitivity"] = math.Round(isf * factor)
+	}
+	low := models.ScheduleValue(profile.TargetLow, seconds)
+	high := models.ScheduleValue(profile.TargetHigh, seconds)
+	if low > 0 && high >= low {
+		d["bgTarget"] = map[string]any{"low": math.Round(low * factor), "high": math.Round(high * factor)}
+	}
+}
+
+// startTemp begins a temp basal; a temp with no duration cancels the running one
+func (tp *tidepoolWriter) startTemp(t *models.Treatment) error {
+	start := t.Time()
+	if err := tp.flushBasal(start); err != nil {
+		return err
+	}
+	if t.Duration > 0 {
+		tp.pending = &tidepoolTemp{
+			treatment: *t,
+			start:     start,
+			end:       start.Add(time.Duration(t.Duration * float64(time.Minute))),
+		}
+	}
+	return nil
+}
+
+// switchProfile writes basal delivery up to a profile switch under the
+// previous profile, then continues a running temp basal under the new one
+func (tp *tidepoolWriter) switchProfile(name string, at time.Time) error {
+	running := tp.pending
+	if err := tp.flushBasal(at); err != nil {
+		return err
+	}
+	if running != nil && running.end.After(at) {
+		tp.pending = &tidepoolTemp{treatment: running.treatment, start: at, end: running.end, continued: true}
+	}
+	tp.timeline.AddSwitch(at, name)
+	return nil
+}
+
+// flushBasal writes basal delivery up to until: the running temp basal,
+// ended early if until comes first, then the scheduled rate
+func (tp *tidepoolWriter) flushBasal(until time.Time) error {
+	if p := tp.pending; p != nil {
+		end := p.end
+		if until.Before(end) {
+			end = until
+		}
+		if err := tp.writeTemp(p, end); err != nil {
+			return err
+		}
+		tp.pending = nil
+		if end.After(tp.basalCursor) {
+			tp.basalCursor = end
+		}
+	}
+	if until.After(tp.basalCursor) {
+		if err := tp.writeScheduled(tp.basalCursor, until); err != nil {
+			return err
+		}
+		tp.basalCursor = until
+	}
+	return nil
+}
+
+func (tp *tidepoolWriter) writeTemp(p *tidepoolTemp, end time.Time) error {
+	duration := end.Sub(p.start)
+	if duration <= 0 {
+		return nil
+	}
+	t := &p.treatment
+
+	scheduled, scheduleName, hasSchedule := tp.timeline.Scheduled(p.start)
+	if basal.IsPercent(t) && !hasSchedule {
+		// Without a schedule the rate is unknown; 0 U/h would read as a suspend
+		return nil
+	}
+	rate := basal.TempRate(t, scheduled)
+
+	originID := t.ID
+	if p.continued {
+		originID = ""
+	}
+	d := tp.datum("basal", p.start, originID)
+	d["deliveryType"] = "temp"
+	d["duration"] = min(duration, tidepoolMaxBasal).Milliseconds()
+	d["rate"] = round(rate, 3)
+	if hasSchedule {
+		d["suppressed"] = map[string]any{
+			"type":         "basal",
+			"deliveryType": "scheduled",
+			"rate":         round(scheduled, 3),
+			"scheduleName": scheduleName,
+		}
+		if scheduled > 0 {
+			d["percent"] = round(rate/scheduled, 3)
+		}
+	}
+	return tp.emit(d)
+}
+
+// writeScheduled writes scheduled basal segments for [from, to), split at
+// schedule steps and profile changes
+func (tp *tidepoolWriter) writeScheduled(from, to time.Time) error {
+	for t := from; t.Before(to); {
+		end := tp.timeline.NextChange(t, to)
+		rate, name, ok := tp.timeline.Scheduled(t)
+		if !ok {
+			// Nothing is known about delivery until the next change
+			t = end
+			continue
+		}
+
+		d := tp.datum("basal", t, "")
+		d["deliveryType"] = "scheduled"
+		d["rate"] = round(rate, 3)
+		d["duration"] = end.Sub(t).Milliseconds()
+		d["scheduleName"] = name
+		if err := tp.emit(d); err != nil {
+			return err
+		}
+		t = end
+	}
+	return nil
+}
+
+// writePumpSettings writes the profile in effect when the range starts and
+// every profile that takes effect within it
+func (tp *tidepoolWriter) writePumpSettings() error {
+	for i := range tp.opts.Profiles {
+		store := &tp.opts.Profiles[i]
+		start := store.Start()
+		if start.After(tp.to) {
+			break
+		}
+		if !start.After(tp.from) && i+1 < len(tp.opts.Profiles) && !tp.opts.Profiles[i+1].Start().After(tp.from) {
+			continue // Superseded before the range starts
+		}
+		if err := tp.emit(tp.pumpSettings(store)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tp *tidepoolWriter) pumpSettings(store *models.ProfileStore) tidepoolDatum {
+	type step = map[string]any
+	basalSchedules := map[string][]step{}
+	carbRatios := map[string][]step{}
+	sensitivities := map[string][]step{}
+	targets := map[string][]step{}
+
+	// Tidepool takes one unit for all schedules: the default profile's.
+	// Profiles in the other unit are converted to it.
+	bgUnits := (&models.Profile{}).GlucoseUnits(store.Units)
+	if _, def := store.Active(""); def != nil {
+		bgUnits = def.GlucoseUnits(store.Units)
+	}
+
+	for name, profile := range store.Store {
+		factor := 1.0
+		switch units := profile.GlucoseUnits(store.Units); {
+		case units == bgUnits:
+		case units == unitMmolL:
+			factor = models.MgdlPerMmol
+		default:
+			factor = 1 / models.MgdlPerMmol
+		}
+		for _, e := range models.SortedSchedule(profile.Basal) {
+			basalSchedules[name] = append(basalSchedules[name], step{"start": e.Seconds() * 1000, "rate": float64(e.Value)})
+		}
+		for _, e := range models.SortedSchedule(profile.CarbRatio) {
+			carbRatios[name] = append(carbRatios[name], step{"start": e.Seconds() * 1000, "amount": float64(e.Value)})
+		}
+		for _, e := range models.SortedSchedule(profile.Sens) {
+			sensitivities[name] = append(sensitivities[name], step{"start": e.Seconds() * 1000, "amount": round(float64(e.Value)*factor, 2)})
+		}
+		for _, e := range models.SortedSchedule(profile.TargetLow) {
+			high := models.ScheduleValue(profile.TargetHigh, e.Seconds())
+			low := float64(e.Value)
+			targets[name] = append(targets[name], step{"start": e.Seconds() * 1000, "low": round(low*factor, 2), "high": round(math.Max(high, low)*factor, 2)})
+		}
+	}
+
+	start := store.Start()
+	if start.IsZero() {
+		start = tp.from
+	}
+	d := tp.datum("pumpSettings", start, store.ID)
+	d["activeSchedule"] = store.DefaultProfile
+	d["basalSchedules"] = basalSchedules
+	d["carbRatios"] = carbRatios
+	d["insulinSensitivities"] = sensitivities
+	d["bgTargets"] = targets
+	d["units"] = map[string]any{"carb": "grams", "bg": bgUnits}
+	return d
+}
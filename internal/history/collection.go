@@ -0,0 +1,276 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// segmentLayout names the monthly segment files, e.g. "entries-2025-01.jsonl"
+const segmentLayout = "2006-01"
+
+// collection is one kind of record kept in memory, sorted by time, and
+// persisted as append-only JSON lines split into monthly segments
+type collection[T any] struct {
+	dir    string
+	name   string
+	keyOf  func(*T) string
+	timeOf func(*T) time.Time
+
+	items    []T
+	keys     map[string]struct{}
+	unsorted bool // An inserted record was older than its predecessor
+}
+
+func newCollection[T any](dir, name string, keyOf func(*T) string, timeOf func(*T) time.Time) *collection[T] {
+	return &collection[T]{
+		dir:    dir,
+		name:   name,
+		keyOf:  keyOf,
+		timeOf: timeOf,
+		keys:   make(map[string]struct{}),
+	}
+}
+
+// load reads all segments. Lines that fail to parse, e.g. a write cut short
+// by a crash, are skipped and dropped at the next compaction.
+func (c *collection[T]) load() error {
+	paths, err := filepath.Glob(filepath.Join(c.dir, c.name+"-*.jsonl"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		if err := c.loadSegment(path); err != nil {
+			return fmt.Errorf("loading %s: %w", filepath.Base(path), err)
+		}
+	}
+
+	c.sort()
+	return nil
+}
+
+func (c *collection[T]) loadSegment(path string) error {
+	f, err := os.Open(path) //nolint:gosec // Segment paths come from our own data directory
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var item T
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			continue
+		}
+		c.insert(item)
+	}
+	return scanner.Err()
+}
+
+// insert adds item to memory unless a record with the same key exists
+func (c *collection[T]) insert(item T) bool {
+	key := c.keyOf(&item)
+	if key == "" || c.timeOf(&item).IsZero() {
+		return false
+	}
+	if _, ok := c.keys[key]; ok {
+		return false
+	}
+	if n := len(c.items); n > 0 && c.timeOf(&item).Before(c.timeOf(&c.items[n-1])) {
+		c.unsorted = true
+	}
+	c.keys[key] = struct{}{}
+	c.items = append(c.items, item)
+	return true
+}
+
+// add appends new records to their segments on disk and then stores them.
+// Records are only kept in memory once their segment was written, so a failed
+// write leaves them unknown and they are fetched again on the next sync.
+// It returns the number of records that were not already known.
+func (c *collection[T]) add(items []T) (int, error) {
+	bySegment := make(map[string][]T)
+	seen := make(map[string]struct{})
+	for _, item := range items {
+		key := c.keyOf(&item)
+		if key == "" || c.timeOf(&item).IsZero() {
+			continue
+		}
+		if _, ok := c.keys[key]; ok {
+			continue
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		seg := c.segmentName(c.timeOf(&item))
+		bySegment[seg] = append(bySegment[seg], item)
+	}
+
+	added := 0
+	for seg, segItems := range bySegment {
+		if err := c.appendSegment(seg, segItems); err != nil {
+			c.sort()
+			return added, err
+		}
+		for _, item := range segItems {
+			c.insert(item)
+		}
+		added += len(segItems)
+	}
+
+	c.sort()
+	return added, nil
+}
+
+func (c *collection[T]) appendSegment(seg string, items []T) error {
+	//nolint:gosec // Segment paths come from our own data directory
+	f, err := os.OpenFile(filepath.Join(c.dir, seg), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+
+	// Terminate a line left incomplete by an interrupted write
+	if !endsWithNewline(f) {
+		_ = w.WriteByte('\n')
+	}
+
+	enc := json.NewEncoder(w)
+	for i := range items {
+		if err := enc.Encode(&items[i]); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// between returns copies of the records with from <= time <= to, oldest first.
+// A zero bound is open.
+func (c *collection[T]) between(from, to time.Time) []T {
+	start := 0
+	if !from.IsZero() {
+		start = sort.Search(len(c.items), func(i int) bool {
+			return !c.timeOf(&c.items[i]).Before(from)
+		})
+	}
+	end := len(c.items)
+	if !to.IsZero() {
+		end = sort.Search(len(c.items), func(i int) bool {
+			return c.timeOf(&c.items[i]).After(to)
+		})
+	}
+	if start >= end {
+		return nil
+	}
+	return append([]T(nil), c.items[start:end]...)
+}
+
+// latest returns the time of the newest record, or the zero time
+func (c *collection[T]) latest() time.Time {
+	if len(c.items) == 0 {
+		return time.Time{}
+	}
+	return c.timeOf(&c.items[len(c.items)-1])
+}
+
+// earliest returns the time of the oldest record, or the zero time
+func (c *collection[T]) earliest() time.Time {
+	if len(c.items) == 0 {
+		return time.Time{}
+	}
+	return c.timeOf(&c.items[0])
+}
+
+// compact drops records older than cutoff (if set) and rewrites every segment
+// from memory, removing duplicates and damaged lines
+func (c *collection[T]) compact(cutoff time.Time) error {
+	if !cutoff.IsZero() {
+		keep := c.between(cutoff, time.Time{})
+		c.items = keep
+		c.keys = make(map[string]struct{}, len(keep))
+		for i := range keep {
+			c.keys[c.keyOf(&keep[i])] = struct{}{}
+		}
+	}
+
+	bySegment := make(map[string][]T)
+	for _, item := range c.items {
+		seg := c.segmentName(c.timeOf(&item))
+		bySegment[seg] = append(bySegment[seg], item)
+	}
+
+	for seg, items := range bySegment {
+		if err := c.rewriteSegment(seg, items); err != nil {
+			return err
+		}
+	}
+
+	// Remove segments that no longer hold any records
+	paths, err := filepath.Glob(filepath.Join(c.dir, c.name+"-*.jsonl"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if _, ok := bySegment[filepath.Base(path)]; !ok {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rewriteSegment atomically replaces a segment with items
+func (c *collection[T]) rewriteSegment(seg string, items []T) error {
+	path := filepath.Join(c.dir, seg)
+	tmp := path + ".tmp"
+	_ = os.Remove(tmp)
+
+	if err := c.appendSegment(seg+".tmp", items); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// endsWithNewline reports whether f is empty or its last byte is a newline
+func endsWithNewline(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return true
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, info.Size()-1); err != nil {
+		return true
+	}
+	return last[0] == '\n'
+}
+
+func (c *collection[T]) segmentName(t time.Time) string {
+	return c.name + "-" + t.UTC().Format(segmentLayout) + ".jsonl"
+}
+
+func (c *collection[T]) sort() {
+	if !c.unsorted {
+		return
+	}
+	c.unsorted = false
+	sort.SliceStable(c.items, func(i, j int) bool {
+		return c.timeOf(&c.items[i]).Before(c.timeOf(&c.items[j]))
+	})
+}
@@ -0,0 +1,293 @@
+// Package history keeps a local copy of glucose entries, treatments and
+// device status so charts and analysis work offline and syncs stay incremental
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// compactedMarker is touched after every successful compaction
+const compactedMarker = ".compacted"
+
+// Store is the local history database. All records are held in memory and
+// persisted as append-only JSON lines in monthly segments under one directory.
+type Store struct {
+	mu           sync.RWMutex
+	dir          string
+	entries      *collection[models.GlucoseEntry]
+	treatments   *collection[models.Treatment]
+	deviceStatus *collection[models.DeviceStatus]
+}
+
+// Open loads the store in dir, creating the directory if needed
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		dir:          dir,
+		entries:      newCollection(dir, "entries", entryKey, (*models.GlucoseEntry).Time),
+		treatments:   newCollection(dir, "treatments", treatmentKey, (*models.Treatment).Time),
+		deviceStatus: newCollection(dir, "devicestatus", deviceStatusKey, (*models.DeviceStatus).Time),
+	}
+
+	if err := s.entries.load(); err != nil {
+		return nil, err
+	}
+	if err := s.treatments.load(); err != nil {
+		return nil, err
+	}
+	if err := s.deviceStatus.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Dir returns the directory the store lives in
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// AddEntries stores glucose entries, ignoring ones already present.
+// It returns how many were new.
+func (s *Store) AddEntries(entries []models.GlucoseEntry) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries.add(entries)
+}
+
+// AddTreatments stores treatments, ignoring ones already present
+func (s *Store) AddTreatments(treatments []models.Treatment) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.treatments.add(treatments)
+}
+
+// AddDeviceStatus stores device status reports, ignoring ones already present
+func (s *Store) AddDeviceStatus(statuses []models.DeviceStatus) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deviceStatus.add(statuses)
+}
+
+// Entries returns glucose entries between from and to, oldest first
+func (s *Store) Entries(from, to time.Time) []models.GlucoseEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.entries.between(from, to)
+}
+
+// Treatments returns treatments between from and to, oldest first
+func (s *Store) Treatments(from, to time.Time) []models.Treatment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.treatments.between(from, to)
+}
+
+// DeviceStatus returns device status reports between from and to, oldest first
+func (s *Store) DeviceStatus(from, to time.Time) []models.DeviceStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.deviceStatus.between(from, to)
+}
+
+// RecentEntries returns up to count of the newest entries, oldest first
+func (s *Store) RecentEntries(count int) []models.GlucoseEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	items := s.entries.items
+	if count > 0 && len(items) > count {
+		items = items[len(items)-count:]
+	}
+	return append([]models.GlucoseEntry(nil), items...)
+}
+
+// Range describes the time span covered by stored records
+type Range struct {
+	Earliest time.Time `json:"earliest"`
+	Latest   time.Time `json:"latest"`
+	Count    int       `json:"count"`
+}
+
+// Stats summarizes the contents of the store
+type Stats struct {
+	Entries      Range `json:"entries"`
+	Treatments   Range `json:"treatments"`
+	DeviceStatus Range `json:"deviceStatus"`
+}
+
+// Stats returns the time span and size of each record kind
+func (s *Store) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return Stats{
+		Entries:      Range{s.entries.earliest(), s.entries.latest(), len(s.entries.items)},
+		Treatments:   Range{s.treatments.earliest(), s.treatments.latest(), len(s.treatments.items)},
+		DeviceStatus: Range{s.deviceStatus.earliest(), s.deviceStatus.latest(), len(s.deviceStatus.items)},
+	}
+}
+
+// Compact drops records older than retention (0 keeps everything) and
+// rewrites all segments without duplicates or damaged lines
+func (s *Store) Compact(retention time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var cutoff time.Time
+	if retention > 0 {
+		cutoff = time.Now().Add(-retention)
+	}
+
+	if err := s.entries.compact(cutoff); err != nil {
+		return fmt.Errorf("compacting entries: %w", err)
+	}
+	if err := s.treatments.compact(cutoff); err != nil {
+		return fmt.Errorf("compacting treatments: %w", err)
+	}
+	if err := s.deviceStatus.compact(cutoff); err != nil {
+		return fmt.Errorf("compacting device status: %w", err)
+	}
+
+	// The marker's modification time records when compaction last ran
+	return os.WriteFile(filepath.Join(s.dir, compactedMarker), nil, 0600)
+}
+
+// LastCompacted returns when Compact last completed, or the zero time
+func (s *Store) LastCompacted() time.Time {
+	info, err := os.Stat(filepath.Join(s.dir, compactedMarker))
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// segmentPattern matches the segment files of every collection
+const segmentPattern = "*-*.jsonl"
+
+// Segments returns the contents of every segment file keyed by file name,
+// e.g. for a backup. Writes are blocked while the files are read.
+func (s *Store) Segments() (map[string][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	paths, err := filepath.Glob(filepath.Join(s.dir, segmentPattern))
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make(map[string][]byte, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path) //nolint:gosec // Segment paths come from our own data directory
+		if err != nil {
+			return nil, err
+		}
+		segments[filepath.Base(path)] = data
+	}
+	return segments, nil
+}
+
+// Replace discards all stored records and loads segments instead, e.g. when
+// restoring a backup. Names must be plain segment file names. The segments are
+// written and loaded next to the store first and swapped in as a whole, so a
+// failure leaves the current history in place.
+func (s *Store) Replace(segments map[string][]byte) error {
+	for name := range segments {
+		if ok, _ := filepath.Match(segmentPattern, name); !ok || filepath.Base(name) != name {
+			return fmt.Errorf("invalid segment name %q", name)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	staging := s.dir + ".restore"
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(staging, 0750); err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(staging)
+	}()
+
+	for name, data := range segments {
+		if err := os.WriteFile(filepath.Join(staging, name), data, 0600); err != nil {
+			return err
+		}
+	}
+
+	entries := newCollection(staging, "entries", entryKey, (*models.GlucoseEntry).Time)
+	treatments := newCollection(staging, "treatments", treatmentKey, (*models.Treatment).Time)
+	deviceStatus := newCollection(staging, "devicestatus", deviceStatusKey, (*models.DeviceStatus).Time)
+	if err := entries.load(); err != nil {
+		return err
+	}
+	if err := treatments.load(); err != nil {
+		return err
+	}
+	if err := deviceStatus.load(); err != nil {
+		return err
+	}
+
+	if err := swapDir(s.dir, staging); err != nil {
+		return fmt.Errorf("replacing history: %w", err)
+	}
+
+	entries.dir, treatments.dir, deviceStatus.dir = s.dir, s.dir, s.dir
+	s.entries, s.treatments, s.deviceStatus = entries, treatments, deviceStatus
+	return nil
+}
+
+// swapDir moves replacement to dir. The old directory is only deleted once
+// the replacement is in place, and is moved back if that fails.
+func swapDir(dir, replacement string) error {
+	old := dir + ".old"
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+	if err := os.Rename(dir, old); err != nil {
+		return err
+	}
+	if err := os.Rename(replacement, dir); err != nil {
+		_ = os.Rename(old, dir)
+		return err
+	}
+	// A leftover is removed before the next swap
+	_ = os.RemoveAll(old)
+	return nil
+}
+
+// entryKey identifies a reading by its timestamp; the same reading uploaded
+// twice gets a new _id but keeps its date
+func entryKey(e *models.GlucoseEntry) string {
+	if e.Date == 0 {
+		return ""
+	}
+	return strconv.FormatInt(e.Date, 10)
+}
+
+func treatmentKey(t *models.Treatment) string {
+	if t.ID != "" {
+		return t.ID
+	}
+	return t.EventType + "@" + strconv.FormatInt(t.Time().UnixMilli(), 10)
+}
+
+func deviceStatusKey(d *models.DeviceStatus) string {
+	if d.ID != "" {
+		return d.ID
+	}
+	return d.Device + "@" + strconv.FormatInt(d.Time().UnixMilli(), 10)
+}
@@ -0,0 +1,183 @@
+package importer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// clarityTimeLayouts are the timestamp layouts seen in Clarity exports;
+// older exports and spreadsheet round trips drop the T or the seconds
+var clarityTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+}
+
+// parseClarity reads a Dexcom Clarity CSV export. The first rows describe
+// the patient and devices and have no timestamp; data rows follow, one
+// event per row.
+func parseClarity(data []byte, opts Options) (*Result, error) {
+	t, err := readTable(data, "Event Type")
+	if err != nil {
+		return nil, fmt.Errorf("clarity: %w", err)
+	}
+
+	colTime, _ := t.column("Timestamp")
+	colType, _ := t.column("Event Type")
+	colSubtype, _ := t.column("Event Subtype")
+	colSource, _ := t.column("Source Device ID")
+	colGlucose, glucoseUnit := t.column("Glucose Value")
+	colInsulin, _ := t.column("Insulin Value")
+	colCarbs, _ := t.column("Carb Value")
+	colDuration, _ := t.column("Duration")
+	colRate, rateUnit := t.column("Glucose Rate of Change")
+	if colTime < 0 || colType < 0 || colGlucose < 0 {
+		return nil, fmt.Errorf("clarity: timestamp, event type or glucose column missing")
+	}
+	mmol := isMmol(glucoseUnit)
+	rateMmol := isMmol(rateUnit)
+
+	result := newResult(FormatClarity)
+	clock := &wallClock{loc: opts.Location}
+
+	for _, row := range t.rows {
+		stamp := field(row, colTime)
+		if stamp == "" {
+			continue // Patient and device information
+		}
+		result.Rows++
+
+		at, ok := parseLocal(stamp, clarityTimeLayouts, opts.Location)
+		if !ok {
+			result.skip("unreadable timestamp")
+			continue
+		}
+
+		eventType := field(row, colType)
+		subtype := field(row, colSubtype)
+		device := deviceName("Dexcom", field(row, colSource))
+
+		switch eventType {
+		case "EGV":
+			at = clock.resolve(at)
+			mgdl, ok := clarityGlucose(field(row, colGlucose), mmol)
+			if !ok {
+				result.skip("missing glucose value")
+				continue
+			}
+			var direction string
+			if rate, ok := number(field(row, colRate)); ok {
+				if rateMmol {
+					rate = models.ToMgdl(rate)
+				}
+				direction = directionFromRate(rate)
+			}
+			result.Entries = append(result.Entries, entry(at, mgdl, direction, device))
+
+		case "Calibration":
+			mgdl, ok := clarityGlucose(field(row, colGlucose), mmol)
+			if !ok {
+				result.skip("missing glucose value")
+				continue
+			}
+			result.Treatments = append(result.Treatments, treatment(at, models.TreatmentEventTypes.BGCheck, device, func(tr *models.Treatment) {
+				tr.Glucose = float64(mgdl)
+				tr.GlucoseType = "Finger"
+				tr.Units = "mg/dl"
+				tr.Notes = "Calibration"
+			}))
+
+		case "Insulin":
+			units, ok := number(field(row, colInsulin))
+			if !ok || units <= 0 {
+				result.skip("insulin without amount")
+				continue
+			}
+			eventType := models.TreatmentEventTypes.CorrectionBolus
+			if strings.EqualFold(subtype, "Long-Acting") {
+				eventType = models.TreatmentEventTypes.LongActingInsulin
+			}
+			result.Treatments = append(result.Treatments, treatment(at, eventType, device, func(tr *models.Treatment) {
+				tr.Insulin = units
+			}))
+
+		case "Carbs":
+			grams, ok := number(field(row, colCarbs))
+			if !ok || grams <= 0 {
+				result.skip("carbs without amount")
+				continue
+			}
+			result.Treatments = append(result.Treatments, treatment(at, models.TreatmentEventTypes.CarbCorrection, device, func(tr *models.Treatment) {
+				tr.Carbs = grams
+			}))
+
+		case "Exercise":
+			minutes := clarityDuration(field(row, colDuration))
+			result.Treatments = append(result.Treatments, treatment(at, models.TreatmentEventTypes.Exercise, device, func(tr *models.Treatment) {
+				tr.Duration = minutes
+				tr.Notes = subtype
+			}))
+
+		case "Health":
+			if subtype == "" {
+				result.skip("health event without details")
+				continue
+			}
+			result.Treatments = append(result.Treatments, treatment(at, models.TreatmentEventTypes.Note, device, func(tr *models.Treatment) {
+				tr.Notes = subtype
+			}))
+
+		default:
+			// Alerts, device and transmitter events have no Nightscout equivalent
+			result.skip(strings.ToLower(eventType) + " events")
+		}
+	}
+	return result, nil
+}
+
+// clarityGlucose parses a glucose value, mapping the Low and High markers
+// onto the sensor's measuring range
+func clarityGlucose(s string, mmol bool) (int, bool) {
+	switch strings.ToLower(s) {
+	case "low":
+		return sensorLow, true
+	case "high":
+		return dexcomSensorHigh, true
+	}
+	v, ok := number(s)
+	if !ok || v <= 0 {
+		return 0, false
+	}
+	return toMgdl(v, mmol), true
+}
+
+// clarityDuration parses an "hh:mm:ss" duration into minutes
+func clarityDuration(s string) float64 {
+	var h, m, sec int
+	if _, err := fmt.Sscanf(s, "%d:%d:%d", &h, &m, &sec); err != nil {
+		return 0
+	}
+	return float64(h*60+m) + float64(sec)/60
+}
+
+// parseLocal parses a wall-clock time in loc using the first matching layout
+func parseLocal(s string, layouts []string, loc *time.Location) (time.Time, bool) {
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// deviceName labels imported records with their source
+func deviceName(vendor, device string) string {
+	if device == "" {
+		return vendor + " import"
+	}
+	return vendor + " import (" + device + ")"
+}
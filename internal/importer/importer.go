@@ -0,0 +1,338 @@
+// Package importer reads glucose and treatment history from CSV files
+// exported by vendor portals (Dexcom Clarity, LibreView) and maps it onto
+// Nightscout records, so history that never went through Nightscout can be
+// analysed like any other.
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1" //nolint:gosec // Only used to derive stable record ids
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// Supported formats
+const (
+	FormatAuto      = "auto"
+	FormatClarity   = "clarity"
+	FormatLibreView = "libreview"
+)
+
+// Formats lists the formats Parse accepts
+var Formats = []string{FormatAuto, FormatClarity, FormatLibreView}
+
+const (
+	// Readings the sensors report only as "Low" or "High" are stored at
+	// the edge of their measuring range
+	sensorLow           = 40
+	dexcomSensorHigh    = 400
+	libreSensorHigh     = 500
+	defaultServingGrams = 15
+)
+
+// ErrUnknownFormat is returned when a file matches none of the supported layouts
+var ErrUnknownFormat = errors.New("unrecognised file: expected a Dexcom Clarity or LibreView CSV export")
+
+// Options controls Parse
+type Options struct {
+	Format string // One of Formats; empty means FormatAuto
+
+	// Location is the time zone the device clock was set to. Both portals
+	// write wall-clock times without an offset. nil uses time.Local.
+	Location *time.Location
+
+	// ServingGrams converts LibreView carb servings to grams; 0 uses 15
+	ServingGrams float64
+}
+
+// Result holds the records read from a file
+type Result struct {
+	Format     string                `json:"format"`
+	Entries    []models.GlucoseEntry `json:"-"`
+	Treatments []models.Treatment    `json:"-"`
+	Rows       int                   `json:"rows"`    // Data rows read
+	Skipped    map[string]int        `json:"skipped"` // Rows not imported, by reason
+	From       time.Time             `json:"from"`
+	To         time.Time             `json:"to"`
+}
+
+func newResult(format string) *Result {
+	return &Result{Format: format, Skipped: make(map[string]int)}
+}
+
+func (r *Result) skip(reason string) {
+	r.Skipped[reason]++
+}
+
+// finish sorts the records and fills in the covered time span
+func (r *Result) finish() {
+	sort.SliceStable(r.Entries, func(i, j int) bool { return r.Entries[i].Date < r.Entries[j].Date })
+	sort.SliceStable(r.Treatments, func(i, j int) bool { return r.Treatments[i].Date < r.Treatments[j].Date })
+
+	var first, last int64
+	if n := len(r.Entries); n > 0 {
+		first, last = r.Entries[0].Date, r.Entries[n-1].Date
+	}
+	if n := len(r.Treatments); n > 0 {
+		if first == 0 || r.Treatments[0].Date < first {
+			first = r.Treatments[0].Date
+		}
+		if r.Treatments[n-1].Date > last {
+			last = r.Treatments[n-1].Date
+		}
+	}
+	if first != 0 {
+		r.From, r.To = time.UnixMilli(first), time.UnixMilli(last)
+	}
+}
+
+// Parse reads a Clarity or LibreView CSV export
+func Parse(rd io.Reader, opts Options) (*Result, error) {
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	if opts.ServingGrams <= 0 {
+		opts.ServingGrams = defaultServingGrams
+	}
+
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	format := opts.Format
+	if format == "" || format == FormatAuto {
+		if format = detect(data); format == "" {
+			return nil, ErrUnknownFormat
+		}
+	}
+
+	var result *Result
+	switch format {
+	case FormatClarity:
+		result, err = parseClarity(data, opts)
+	case FormatLibreView:
+		result, err = parseLibreView(data, opts)
+	default:
+		return nil, fmt.Errorf("unknown import format %q (use %s)", format, strings.Join(Formats, ", "))
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.finish()
+	return result, nil
+}
+
+// detect recognises a format from the header lines
+func detect(data []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 0; line < 5 && scanner.Scan(); line++ {
+		text := scanner.Text()
+		switch {
+		case strings.Contains(text, "Timestamp (YYYY-MM-DDThh:mm:ss)") && strings.Contains(text, "Event Type"):
+			return FormatClarity
+		case strings.Contains(text, "Device Timestamp") && strings.Contains(text, "Record Type"):
+			return FormatLibreView
+		}
+	}
+	return ""
+}
+
+// table is a CSV file with its header row located
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+// readTable parses CSV data whose header is the first line containing marker.
+// The delimiter is taken from the header line, since exports made with some
+// locales use semicolons or tabs.
+func readTable(data []byte, marker string) (*table, error) {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	headerLine := -1
+	for i, l := range lines {
+		if i > 10 {
+			break
+		}
+		if bytes.Contains(l, []byte(marker)) {
+			headerLine = i
+			break
+		}
+	}
+	if headerLine < 0 {
+		return nil, fmt.Errorf("header row with %q not found", marker)
+	}
+
+	r := csv.NewReader(bytes.NewReader(bytes.Join(lines[headerLine:], nil)))
+	r.Comma = delimiter(lines[headerLine])
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	t := &table{header: header}
+	for i, name := range header {
+		t.header[i] = strings.TrimSpace(name)
+	}
+
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading rows: %w", err)
+		}
+		t.rows = append(t.rows, row)
+	}
+	return t, nil
+}
+
+func delimiter(header []byte) rune {
+	best, count := ',', bytes.Count(header, []byte(","))
+	for _, d := range []rune{';', '\t'} {
+		if n := bytes.Count(header, []byte(string(d))); n > count {
+			best, count = d, n
+		}
+	}
+	return best
+}
+
+// column returns the index of the first header starting with one of the
+// prefixes and the rest of its name, e.g. the unit in "Glucose Value (mg/dL)"
+func (t *table) column(prefixes ...string) (int, string) {
+	for _, p := range prefixes {
+		for i, name := range t.header {
+			if strings.HasPrefix(name, p) {
+				return i, strings.TrimSpace(strings.TrimPrefix(name, p))
+			}
+		}
+	}
+	return -1, ""
+}
+
+// field returns the trimmed value of column i, or "" if the row is short
+func field(row []string, i int) string {
+	if i < 0 || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+// number parses a decimal that may use a comma as the decimal separator
+func number(s string) (float64, bool) {
+	if s == "" {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// isMmol reports whether a column unit such as "(mmol/L)" or "mmol/L" is mmol/L
+func isMmol(unit string) bool {
+	return strings.Contains(strings.ToLower(unit), "mmol")
+}
+
+// toMgdl converts a glucose value in the column unit to mg/dL
+func toMgdl(v float64, mmol bool) int {
+	if mmol {
+		v = models.ToMgdl(v)
+	}
+	return int(v + 0.5)
+}
+
+// wallClock resolves local times written without an offset. When clocks go
+// back the repeated hour is ambiguous; readings are written in order, so the
+// earliest instant that doesn't step back in time is chosen.
+type wallClock struct {
+	loc  *time.Location
+	last time.Time
+}
+
+func (w *wallClock) resolve(t time.Time) time.Time {
+	const layout = "2006-01-02 15:04:05"
+	wall := t.In(w.loc).Format(layout)
+
+	var chosen time.Time
+	for _, c := range []time.Time{t.Add(-time.Hour), t, t.Add(time.Hour)} {
+		if c.In(w.loc).Format(layout) != wall {
+			continue
+		}
+		if chosen.IsZero() || (chosen.Before(w.last) && !c.Before(w.last)) {
+			chosen = c
+		}
+	}
+
+	if chosen.After(w.last) {
+		w.last = chosen
+	}
+	return chosen
+}
+
+// entry builds a sensor glucose entry
+func entry(t time.Time, mgdl int, direction, device string) models.GlucoseEntry {
+	return models.GlucoseEntry{
+		SGV:       mgdl,
+		Date:      t.UnixMilli(),
+		DateStr:   t.UTC().Format(time.RFC3339),
+		Direction: direction,
+		Device:    device,
+		Type:      "sgv",
+	}
+}
+
+// treatment builds a treatment with an id derived from its content, so a
+// file imported twice, or uploaded and synced back, is stored once
+func treatment(t time.Time, eventType, device string, fill func(*models.Treatment)) models.Treatment {
+	tr := models.Treatment{
+		EventType: eventType,
+		Date:      t.UnixMilli(),
+		CreatedAt: t.UTC().Format(time.RFC3339),
+		EnteredBy: device,
+	}
+	fill(&tr)
+
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s|%d|%g|%g|%g|%g|%s", //nolint:gosec // Not used for security
+		tr.EventType, tr.Date, tr.Insulin, tr.Carbs, tr.Glucose, tr.Duration, tr.Notes)))
+	// Nightscout ids are 12-byte object ids written as hex
+	tr.ID = hex.EncodeToString(sum[:12])
+	return tr
+}
+
+// directionFromRate maps a rate of change in mg/dL/min onto the Nightscout
+// trend names, using the same limits as Dexcom's arrows
+func directionFromRate(rate float64) string {
+	switch {
+	case rate > 3:
+		return "DoubleUp"
+	case rate > 2:
+		return "SingleUp"
+	case rate > 1:
+		return "FortyFiveUp"
+	case rate >= -1:
+		return "Flat"
+	case rate >= -2:
+		return "FortyFiveDown"
+	case rate >= -3:
+		return "SingleDown"
+	default:
+		return "DoubleDown"
+	}
+}
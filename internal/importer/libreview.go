@@ -0,0 +1,230 @@
+package importer
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// LibreView record types
+const (
+	libreHistoric = "0" // Automatic reading every 15 minutes
+	libreScan     = "1" // Reading taken by scanning the sensor
+	libreStrip    = "2" // Blood glucose strip
+	libreKetone   = "3"
+)
+
+// libreDate matches the numeric date at the start of a LibreView timestamp,
+// whose field order follows the locale of the account
+var libreDate = regexp.MustCompile(`^(\d{1,4})[-/.](\d{1,2})[-/.](\d{2,4})`)
+
+// parseLibreView reads a LibreView CSV export. The first line names the
+// report and its owner; the column header follows.
+func parseLibreView(data []byte, opts Options) (*Result, error) {
+	t, err := readTable(data, "Device Timestamp")
+	if err != nil {
+		return nil, fmt.Errorf("libreview: %w", err)
+	}
+
+	colDevice, _ := t.column("Device")
+	colTime, _ := t.column("Device Timestamp")
+	colType, _ := t.column("Record Type")
+	colHistoric, historicUnit := t.column("Historic Glucose")
+	colScan, _ := t.column("Scan Glucose")
+	colStrip, stripUnit := t.column("Strip Glucose")
+	colRapid, _ := t.column("Rapid-Acting Insulin (units)")
+	colLong, _ := t.column("Long-Acting Insulin Value (units)", "Long-Acting Insulin (units)")
+	colGrams, _ := t.column("Carbohydrates (grams)")
+	colServings, _ := t.column("Carbohydrates (servings)")
+	colNotes, _ := t.column("Notes")
+	if colTime < 0 || colHistoric < 0 {
+		return nil, fmt.Errorf("libreview: timestamp or glucose column missing")
+	}
+	historicMmol := isMmol(historicUnit)
+	stripMmol := isMmol(stripUnit)
+
+	stamps := make([]string, len(t.rows))
+	for i, row := range t.rows {
+		stamps[i] = field(row, colTime)
+	}
+	layouts := libreLayouts(stamps)
+
+	result := newResult(FormatLibreView)
+	clock := &wallClock{loc: opts.Location}
+
+	for i, row := range t.rows {
+		if stamps[i] == "" {
+			continue
+		}
+		result.Rows++
+
+		at, ok := parseLocal(normalizeLibreTime(stamps[i]), layouts, opts.Location)
+		if !ok {
+			result.skip("unreadable timestamp")
+			continue
+		}
+		device := deviceName("LibreView", field(row, colDevice))
+
+		switch field(row, colType) {
+		case libreHistoric:
+			at = clock.resolve(at)
+			mgdl, ok := libreGlucose(field(row, colHistoric), historicMmol)
+			if !ok {
+				result.skip("missing glucose value")
+				continue
+			}
+			result.Entries = append(result.Entries, entry(at, mgdl, "", device))
+
+		case libreScan:
+			// Scans repeat what the 15-minute history records and would
+			// weigh scanned periods more heavily in statistics
+			if field(row, colScan) != "" {
+				result.skip("scan readings (covered by historic readings)")
+			}
+
+		case libreStrip:
+			mgdl, ok := libreGlucose(field(row, colStrip), stripMmol)
+			if !ok {
+				result.skip("missing glucose value")
+				continue
+			}
+			result.Treatments = append(result.Treatments, treatment(at, models.TreatmentEventTypes.BGCheck, device, func(tr *models.Treatment) {
+				tr.Glucose = float64(mgdl)
+				tr.GlucoseType = "Finger"
+				tr.Units = "mg/dl"
+			}))
+
+		case libreKetone:
+			result.skip("ketone readings")
+
+		default:
+			tr, ok := libreTreatment(row, at, device, opts.ServingGrams, colRapid, colLong, colGrams, colServings, colNotes)
+			if !ok {
+				result.skip("rows without glucose, insulin, carbs or notes")
+				continue
+			}
+			result.Treatments = append(result.Treatments, tr...)
+		}
+	}
+	return result, nil
+}
+
+// libreTreatment maps insulin, food and note rows onto treatments
+func libreTreatment(row []string, at time.Time, device string, servingGrams float64, colRapid, colLong, colGrams, colServings, colNotes int) ([]models.Treatment, bool) {
+	rapid, _ := number(field(row, colRapid))
+	long, _ := number(field(row, colLong))
+	carbs, _ := number(field(row, colGrams))
+	if carbs <= 0 {
+		if servings, ok := number(field(row, colServings)); ok {
+			carbs = servings * servingGrams
+		}
+	}
+	notes := field(row, colNotes)
+
+	var out []models.Treatment
+	switch {
+	case rapid > 0 && carbs > 0:
+		out = append(out, treatment(at, models.TreatmentEventTypes.MealBolus, device, func(tr *models.Treatment) {
+			tr.Insulin, tr.Carbs, tr.Notes = rapid, carbs, notes
+		}))
+	case rapid > 0:
+		out = append(out, treatment(at, models.TreatmentEventTypes.CorrectionBolus, device, func(tr *models.Treatment) {
+			tr.Insulin, tr.Notes = rapid, notes
+		}))
+	case carbs > 0:
+		out = append(out, treatment(at, models.TreatmentEventTypes.CarbCorrection, device, func(tr *models.Treatment) {
+			tr.Carbs, tr.Notes = carbs, notes
+		}))
+	}
+	if long > 0 {
+		out = append(out, treatment(at, models.TreatmentEventTypes.LongActingInsulin, device, func(tr *models.Treatment) {
+			tr.Insulin = long
+			if len(out) == 0 {
+				tr.Notes = notes
+			}
+		}))
+	}
+	if len(out) == 0 && notes != "" {
+		out = append(out, treatment(at, models.TreatmentEventTypes.Note, device, func(tr *models.Treatment) {
+			tr.Notes = notes
+		}))
+	}
+	return out, len(out) > 0
+}
+
+// libreGlucose parses a glucose value; LibreView writes LO and HI outside
+// the sensor's range
+func libreGlucose(s string, mmol bool) (int, bool) {
+	switch strings.ToUpper(s) {
+	case "LO", "LOW":
+		return sensorLow, true
+	case "HI", "HIGH":
+		return libreSensorHigh, true
+	}
+	v, ok := number(s)
+	if !ok || v <= 0 {
+		return 0, false
+	}
+	return toMgdl(v, mmol), true
+}
+
+// normalizeLibreTime unifies date separators and the AM/PM marker so one
+// set of layouts applies
+func normalizeLibreTime(s string) string {
+	if m := libreDate.FindStringSubmatchIndex(s); m != nil {
+		date := strings.NewReplacer("/", "-", ".", "-").Replace(s[:m[1]])
+		s = date + s[m[1]:]
+	}
+	return strings.ToUpper(strings.Join(strings.Fields(s), " "))
+}
+
+// libreLayouts works out the date order of a file. US accounts write
+// month-day-year with a 12-hour clock, most others day-month-year; a day
+// above 12 anywhere in the file settles it.
+func libreLayouts(stamps []string) []string {
+	yearFirst, monthFirst, dayFirst, twelveHour := false, false, false, false
+	for _, s := range stamps {
+		m := libreDate.FindStringSubmatch(s)
+		if m == nil {
+			continue
+		}
+		if len(m[1]) == 4 {
+			yearFirst = true
+			continue
+		}
+		first, _ := strconv.Atoi(m[1])
+		second, _ := strconv.Atoi(m[2])
+		if first > 12 {
+			dayFirst = true
+		}
+		if second > 12 {
+			monthFirst = true
+		}
+		if strings.ContainsAny(s[len(m[0]):], "AaPp") {
+			twelveHour = true
+		}
+	}
+
+	var dates []string
+	switch {
+	case yearFirst:
+		dates = []string{"2006-1-2"}
+	case dayFirst && !monthFirst:
+		dates = []string{"2-1-2006", "2-1-06"}
+	case monthFirst && !dayFirst, twelveHour:
+		dates = []string{"1-2-2006", "1-2-06"}
+	default:
+		dates = []string{"2-1-2006", "2-1-06"}
+	}
+
+	var layouts []string
+	for _, d := range dates {
+		layouts = append(layouts,
+			d+" 15:04", d+" 15:04:05", d+" 3:04 PM", d+" 3:04:05 PM")
+	}
+	return layouts
+}
@@ -0,0 +1,206 @@
+// Package insights scans glucose history for recurring patterns, such as
+// lows on particular nights or spikes after breakfast, and reports them as
+// ranked findings with the occasions that support them
+package insights
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+	"github.com/mrcode/nightscout-tray/internal/stats"
+)
+
+const (
+	// maxEvidence is how many occasions are listed per finding
+	maxEvidence = 10
+
+	// Readings outside this range are sensor errors, not glucose values
+	minValidSGV = 39
+	maxValidSGV = 600
+)
+
+// severityWeight ranks urgent findings above warnings above information
+var severityWeight = map[string]float64{
+	models.InsightInfo:    1,
+	models.InsightWarning: 2,
+	models.InsightUrgent:  3,
+}
+
+// Options controls Analyze
+type Options struct {
+	Location *time.Location   // Time zone days and nights are split in; nil uses time.Local
+	Settings *models.Settings // Unit and target range
+}
+
+// Analyze looks for recurring patterns in [from, to]. treatments should
+// include those logged up to a few hours after to, so meals and exercise
+// near the end can be matched.
+func Analyze(entries []models.GlucoseEntry, treatments []models.Treatment, from, to time.Time, opts Options) *models.InsightReport {
+	loc := opts.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	settings := opts.Settings
+	if settings == nil {
+		settings = models.DefaultSettings()
+	}
+
+	a := newAnalysis(entries, treatments, from, to, loc, settings)
+	report := &models.InsightReport{
+		From:     from,
+		To:       to,
+		Days:     int(math.Round(to.Sub(from).Hours() / 24)),
+		Unit:     settings.Unit,
+		Heatmaps: stats.ComputeHeatmaps(entries, from, to, stats.ThresholdsFromSettings(settings), loc),
+	}
+
+	for _, detect := range []func(*analysis) []models.Insight{
+		nocturnalLows,
+		recurringLows,
+		breakfastSpikes,
+		dawnPhenomenon,
+		highsAfterExercise,
+	} {
+		report.Findings = append(report.Findings, detect(a)...)
+	}
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		return report.Findings[i].Score > report.Findings[j].Score
+	})
+	return report
+}
+
+// analysis holds the prepared history the detectors share
+type analysis struct {
+	from, to   time.Time
+	loc        *time.Location
+	settings   *models.Settings
+	readings   []models.GlucoseEntry // Valid readings, oldest first
+	treatments []models.Treatment    // Oldest first
+	episodes   []models.GlucoseEpisode
+	cadence    time.Duration // Observed interval between readings
+}
+
+func newAnalysis(entries []models.GlucoseEntry, treatments []models.Treatment, from, to time.Time, loc *time.Location, settings *models.Settings) *analysis {
+	a := &analysis{from: from, to: to, loc: loc, settings: settings}
+
+	for _, e := range entries {
+		if e.SGV >= minValidSGV && e.SGV <= maxValidSGV && !e.Time().Before(from) && !e.Time().After(to) {
+			a.readings = append(a.readings, e)
+		}
+	}
+	sort.Slice(a.readings, func(i, j int) bool { return a.readings[i].Date < a.readings[j].Date })
+
+	a.cadence = stats.Cadence(entries, from, to)
+
+	a.treatments = append([]models.Treatment(nil), treatments...)
+	sort.Slice(a.treatments, func(i, j int) bool { return a.treatments[i].Time().Before(a.treatments[j].Time()) })
+
+	a.episodes = stats.DetectEpisodes(entries, treatments, from, to, stats.EpisodeOptions{
+		Location: loc,
+		Settings: settings,
+	}).Episodes
+	return a
+}
+
+// between returns the readings in [from, to)
+func (a *analysis) between(from, to time.Time) []models.GlucoseEntry {
+	i := sort.Search(len(a.readings), func(i int) bool { return a.readings[i].Date >= from.UnixMilli() })
+	j := sort.Search(len(a.readings), func(i int) bool { return a.readings[i].Date >= to.UnixMilli() })
+	return a.readings[i:j]
+}
+
+// covered reports whether readings, taken from [from, to), hold at least
+// share of the readings expected there at the observed cadence
+func (a *analysis) covered(readings []models.GlucoseEntry, from, to time.Time, share float64) bool {
+	expected := float64(to.Sub(from)) / float64(a.cadence)
+	return expected > 0 && float64(len(readings)) >= share*expected
+}
+
+// treatmentsBetween returns the treatments in [from, to)
+func (a *analysis) treatmentsBetween(from, to time.Time) []models.Treatment {
+	i := sort.Search(len(a.treatments), func(i int) bool { return !a.treatments[i].Time().Before(from) })
+	j := sort.Search(len(a.treatments), func(i int) bool { return !a.treatments[i].Time().Before(to) })
+	return a.treatments[i:j]
+}
+
+// days returns the local midnights of the days in the window
+func (a *analysis) days() []time.Time {
+	var days []time.Time
+	local := a.from.In(a.loc)
+	for d := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, a.loc); d.Before(a.to); d = d.AddDate(0, 0, 1) {
+		days = append(days, d)
+	}
+	return days
+}
+
+// at returns the local time on day at the given hour and minute
+func at(day time.Time, hour, minute int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location())
+}
+
+// lowest and highest return the extreme reading of a non-empty slice
+func lowest(readings []models.GlucoseEntry) models.GlucoseEntry {
+	low := readings[0]
+	for _, r := range readings[1:] {
+		if r.SGV < low.SGV {
+			low = r
+		}
+	}
+	return low
+}
+
+func highest(readings []models.GlucoseEntry) models.GlucoseEntry {
+	high := readings[0]
+	for _, r := range readings[1:] {
+		if r.SGV > high.SGV {
+			high = r
+		}
+	}
+	return high
+}
+
+// value converts mg/dL to the selected unit
+func (a *analysis) value(mgdl float64) float64 {
+	if a.settings.Unit == "mmol/L" {
+		return math.Round(models.ToMmol(mgdl)*10) / 10
+	}
+	return math.Round(mgdl)
+}
+
+// glucose formats mg/dL in the selected unit
+func (a *analysis) glucose(mgdl float64) string {
+	if a.settings.Unit == "mmol/L" {
+		return fmt.Sprintf("%.1f mmol/L", models.ToMmol(mgdl))
+	}
+	return fmt.Sprintf("%.0f mg/dL", mgdl)
+}
+
+// evidence builds an evidence item for a reading
+func (a *analysis) evidence(t time.Time, mgdl int, detail string) models.InsightEvidence {
+	return models.InsightEvidence{Time: t, Value: a.value(float64(mgdl)), ValueMg: mgdl, Detail: detail}
+}
+
+// finalize orders evidence newest first, trims it and scores the finding.
+// Patterns seen more often and on more occasions rank higher.
+func finalize(in models.Insight) models.Insight {
+	sort.SliceStable(in.Evidence, func(i, j int) bool { return in.Evidence[i].Time.After(in.Evidence[j].Time) })
+	if len(in.Evidence) > maxEvidence {
+		in.Evidence = in.Evidence[:maxEvidence]
+	}
+	rate := 0.0
+	if in.Opportunities > 0 {
+		rate = float64(in.Occurrences) / float64(in.Opportunities)
+	}
+	in.Score = math.Round(severityWeight[in.Severity]*rate*(1+math.Log2(float64(in.Occurrences)))*100) / 100
+	return in
+}
+
+func percent(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) / float64(whole) * 100
+}
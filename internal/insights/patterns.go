@@ -0,0 +1,403 @@
+package insights
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+const (
+	// Night is 00:00 to 06:00 local time, as in the consensus report
+	nightEndHour = 6
+
+	// nightMinCoverage is the share of expected readings a night needs to be counted
+	nightMinCoverage = 0.5
+
+	// Nocturnal lows are reported when at least this many nights and this
+	// share of nights have one; a weekday stands out when its share is at
+	// least weekdayFactor times the overall one
+	nocturnalMinNights   = 3
+	nocturnalMinRate     = 0.2
+	weekdayMinNights     = 2
+	weekdayMinRate       = 0.5
+	weekdayFactor        = 2.0
+	recurringLowMinDays  = 3
+	recurringLowMinRate  = 0.2
+	recurringLowWindowHr = 2
+
+	// Breakfast is the first meal of at least breakfastMinCarbs between
+	// breakfastStart and breakfastEnd; a spike is a peak above
+	// breakfastSpikeMgdl within breakfastWindow
+	breakfastMinCarbs  = 10
+	breakfastStart     = 5 * 60
+	breakfastEnd       = 10*60 + 30
+	breakfastWindow    = 3 * time.Hour
+	breakfastSpikeMgdl = 250
+	breakfastMinSpikes = 3
+	breakfastMinRate   = 0.3
+
+	// Dawn phenomenon: glucose rises at least dawnMinRise from the 00:00-04:00
+	// nadir to 07:00 without food or insulin from 03:00 on
+	dawnNadirEndHour   = 4
+	dawnQuietFromHour  = 3
+	dawnMorningHour    = 7
+	dawnMinRise        = 30
+	dawnMinNadir       = 70 // Lower nadirs suggest a rebound instead
+	dawnMinNights      = 3
+	dawnMinRate        = 0.5
+	dawnGrowingRise    = 10 // Increase in mean rise between the halves of the window
+	dawnReadingMaxDiff = 15 * time.Minute
+
+	// Exercise: highs within exerciseWindow after a session ends
+	exerciseDefaultDuration = 30 * time.Minute
+	exerciseWindow          = 4 * time.Hour
+	exerciseMinHighs        = 2
+	exerciseMinRate         = 0.5
+
+	// windowMinCoverage is the share of expected readings a meal or exercise
+	// window needs
+	windowMinCoverage = 0.2
+)
+
+// nocturnalLows finds frequent lows at night, and weekdays whose nights
+// have them much more often than the others
+func nocturnalLows(a *analysis) []models.Insight {
+	var nights, lowNights [7]int
+	var total, totalLow int
+	var evidence [7][]models.InsightEvidence
+	var allEvidence []models.InsightEvidence
+	urgent := false
+
+	for _, day := range a.days() {
+		nightEnd := at(day, nightEndHour, 0)
+		if !a.covered(a.between(day, nightEnd), day, nightEnd, nightMinCoverage) {
+			continue
+		}
+		wd := day.Weekday()
+		nights[wd]++
+		total++
+
+		var found bool
+		for _, ep := range a.episodes {
+			start := ep.Start.In(a.loc)
+			if ep.Type != models.EpisodeHypo || !ep.Night || start.Before(day) || !start.Before(nightEnd) {
+				continue
+			}
+			if ep.Level == 2 {
+				urgent = true
+			}
+			if ep.Level != 1 || found {
+				continue
+			}
+			found = true
+			ev := a.evidence(ep.ExtremeTime, ep.Extreme, fmt.Sprintf("Low from %s for %.0f min, lowest %s",
+				start.Format("15:04"), ep.DurationMinutes, a.glucose(float64(ep.Extreme))))
+			evidence[wd] = append(evidence[wd], ev)
+			allEvidence = append(allEvidence, ev)
+		}
+		if found {
+			lowNights[wd]++
+			totalLow++
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+
+	severity := models.InsightWarning
+	if urgent {
+		severity = models.InsightUrgent
+	}
+	overall := float64(totalLow) / float64(total)
+
+	var out []models.Insight
+	if totalLow >= nocturnalMinNights && overall >= nocturnalMinRate {
+		out = append(out, finalize(models.Insight{
+			Kind:     models.InsightNocturnalLows,
+			Severity: severity,
+			Title:    "Frequent lows at night",
+			Summary: fmt.Sprintf("Glucose went low between midnight and 6:00 in %d of %d nights (%.0f%%).",
+				totalLow, total, percent(totalLow, total)),
+			Occurrences:   totalLow,
+			Opportunities: total,
+			Evidence:      allEvidence,
+		}))
+	}
+
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		if lowNights[wd] < weekdayMinNights || nights[wd] == 0 {
+			continue
+		}
+		rate := float64(lowNights[wd]) / float64(nights[wd])
+		if rate < weekdayMinRate || rate < weekdayFactor*overall && total > nights[wd] {
+			continue
+		}
+		weekday := wd
+		out = append(out, finalize(models.Insight{
+			Kind:     models.InsightNocturnalLows,
+			Severity: severity,
+			Title:    fmt.Sprintf("Night lows on %ss", wd),
+			Summary: fmt.Sprintf("%d of %d nights into %s had a low, against %.0f%% of all nights.",
+				lowNights[wd], nights[wd], wd, overall*100),
+			Occurrences:   lowNights[wd],
+			Opportunities: nights[wd],
+			Weekday:       &weekday,
+			Evidence:      evidence[wd],
+		}))
+	}
+	return out
+}
+
+// recurringLows finds a daytime hour around which lows keep starting
+func recurringLows(a *analysis) []models.Insight {
+	days := a.days()
+	var counted [24]map[string]bool
+	var evidence [24][]models.InsightEvidence
+	for h := range counted {
+		counted[h] = map[string]bool{}
+	}
+
+	for _, ep := range a.episodes {
+		if ep.Type != models.EpisodeHypo || ep.Level != 1 || ep.Night {
+			continue
+		}
+		start := ep.Start.In(a.loc)
+		date := start.Format("2006-01-02")
+		// Each low counts for every window that contains its start hour
+		for h := start.Hour() - recurringLowWindowHr + 1; h <= start.Hour(); h++ {
+			if h < nightEndHour || counted[h][date] {
+				continue
+			}
+			counted[h][date] = true
+			evidence[h] = append(evidence[h], a.evidence(ep.ExtremeTime, ep.Extreme,
+				fmt.Sprintf("Low at %s, lowest %s", start.Format("Mon 15:04"), a.glucose(float64(ep.Extreme)))))
+		}
+	}
+
+	best := -1
+	for h := nightEndHour; h < 24; h++ {
+		if best < 0 || len(counted[h]) > len(counted[best]) {
+			best = h
+		}
+	}
+	if best < 0 || len(days) == 0 {
+		return nil
+	}
+	n := len(counted[best])
+	if n < recurringLowMinDays || float64(n)/float64(len(days)) < recurringLowMinRate {
+		return nil
+	}
+
+	hour := best
+	return []models.Insight{finalize(models.Insight{
+		Kind:     models.InsightRecurringLows,
+		Severity: models.InsightWarning,
+		Title:    fmt.Sprintf("Lows around %02d:00-%02d:00", best, best+recurringLowWindowHr),
+		Summary: fmt.Sprintf("A low started between %02d:00 and %02d:00 on %d of %d days.",
+			best, best+recurringLowWindowHr, n, len(days)),
+		Occurrences:   n,
+		Opportunities: len(days),
+		Hour:          &hour,
+		Evidence:      evidence[best],
+	})}
+}
+
+// breakfastSpikes finds breakfasts regularly followed by very high glucose
+func breakfastSpikes(a *analysis) []models.Insight {
+	var meals, spikes int
+	var evidence []models.InsightEvidence
+
+	for _, day := range a.days() {
+		var meal *models.Treatment
+		for _, t := range a.treatmentsBetween(at(day, 0, breakfastStart), at(day, 0, breakfastEnd)) {
+			if t.Carbs >= breakfastMinCarbs {
+				meal = &t
+				break
+			}
+		}
+		if meal == nil {
+			continue
+		}
+		mealTime := meal.Time()
+		after := a.between(mealTime, mealTime.Add(breakfastWindow))
+		if !a.covered(after, mealTime, mealTime.Add(breakfastWindow), windowMinCoverage) {
+			continue
+		}
+		meals++
+
+		peak := highest(after)
+		if peak.SGV <= breakfastSpikeMgdl {
+			continue
+		}
+		spikes++
+		evidence = append(evidence, a.evidence(peak.Time(), peak.SGV, fmt.Sprintf("%.0f g at %s, peak %s %.0f min later",
+			meal.Carbs, mealTime.In(a.loc).Format("Mon 15:04"), a.glucose(float64(peak.SGV)), peak.Time().Sub(mealTime).Minutes())))
+	}
+
+	if spikes < breakfastMinSpikes || float64(spikes)/float64(meals) < breakfastMinRate {
+		return nil
+	}
+	return []models.Insight{finalize(models.Insight{
+		Kind:     models.InsightBreakfastSpikes,
+		Severity: models.InsightWarning,
+		Title:    "Spikes after breakfast",
+		Summary: fmt.Sprintf("Glucose rose above %s within %.0f hours of %d of %d breakfasts.",
+			a.glucose(breakfastSpikeMgdl), breakfastWindow.Hours(), spikes, meals),
+		Occurrences:   spikes,
+		Opportunities: meals,
+		Evidence:      evidence,
+	})}
+}
+
+// dawnPhenomenon finds mornings where glucose climbs from its night-time
+// low without food or insulin, and whether the climb is getting larger
+func dawnPhenomenon(a *analysis) []models.Insight {
+	var rises []float64
+	var dawnNights int
+	var morningSum float64
+	var evidence []models.InsightEvidence
+
+	for _, day := range a.days() {
+		nadirEnd := at(day, dawnNadirEndHour, 0)
+		night := a.between(day, nadirEnd)
+		if !a.covered(night, day, nadirEnd, nightMinCoverage) {
+			continue
+		}
+		morning, ok := a.nearest(at(day, dawnMorningHour, 0), dawnReadingMaxDiff)
+		if !ok {
+			continue
+		}
+		// Food or insulin explains a rise
+		quiet := true
+		for _, t := range a.treatmentsBetween(at(day, dawnQuietFromHour, 0), morning.Time()) {
+			if t.Carbs > 0 || t.Insulin > 0 {
+				quiet = false
+				break
+			}
+		}
+		nadir := lowest(night)
+		if !quiet || nadir.SGV < dawnMinNadir {
+			continue
+		}
+
+		rise := float64(morning.SGV - nadir.SGV)
+		rises = append(rises, rise)
+		if rise < dawnMinRise {
+			continue
+		}
+		dawnNights++
+		morningSum += float64(morning.SGV)
+		evidence = append(evidence, a.evidence(morning.Time(), morning.SGV, fmt.Sprintf("Rose from %s at %s to %s at %s",
+			a.glucose(float64(nadir.SGV)), nadir.Time().In(a.loc).Format("15:04"),
+			a.glucose(float64(morning.SGV)), morning.Time().In(a.loc).Format("Mon 15:04"))))
+	}
+
+	if dawnNights < dawnMinNights || float64(dawnNights)/float64(len(rises)) < dawnMinRate {
+		return nil
+	}
+
+	severity := models.InsightInfo
+	if morningSum/float64(dawnNights) > float64(a.settings.TargetHigh) {
+		severity = models.InsightWarning
+	}
+	summary := fmt.Sprintf("On %d of %d mornings glucose rose by at least %s between the night-time low and %02d:00 without food or insulin.",
+		dawnNights, len(rises), a.glucose(dawnMinRise), dawnMorningHour)
+	half := len(rises) / 2
+	if half >= 2 {
+		early, late := mean(rises[:half]), mean(rises[len(rises)-half:])
+		if late-early >= dawnGrowingRise {
+			summary += fmt.Sprintf(" The rise is growing: %s on average recently against %s earlier.",
+				a.glucose(late), a.glucose(early))
+		}
+	}
+
+	return []models.Insight{finalize(models.Insight{
+		Kind:          models.InsightDawnPhenomenon,
+		Severity:      severity,
+		Title:         "Dawn phenomenon",
+		Summary:       summary,
+		Occurrences:   dawnNights,
+		Opportunities: len(rises),
+		Evidence:      evidence,
+	})}
+}
+
+// highsAfterExercise finds exercise regularly followed by highs, e.g. from
+// reduced insulin or stress hormones
+func highsAfterExercise(a *analysis) []models.Insight {
+	var sessions, highs int
+	var evidence []models.InsightEvidence
+	severity := models.InsightInfo
+
+	for _, t := range a.treatmentsBetween(a.from, a.to) {
+		if t.EventType != models.TreatmentEventTypes.Exercise {
+			continue
+		}
+		duration := time.Duration(t.Duration * float64(time.Minute))
+		if duration <= 0 {
+			duration = exerciseDefaultDuration
+		}
+		end := t.Time().Add(duration)
+		after := a.between(end, end.Add(exerciseWindow))
+		if !a.covered(after, end, end.Add(exerciseWindow), windowMinCoverage) {
+			continue
+		}
+		sessions++
+
+		peak := highest(after)
+		if peak.SGV <= a.settings.TargetHigh {
+			continue
+		}
+		highs++
+		if peak.SGV > breakfastSpikeMgdl {
+			severity = models.InsightWarning
+		}
+		evidence = append(evidence, a.evidence(peak.Time(), peak.SGV, fmt.Sprintf("Exercise at %s, %s %.0f min after it ended",
+			t.Time().In(a.loc).Format("Mon 15:04"), a.glucose(float64(peak.SGV)), peak.Time().Sub(end).Minutes())))
+	}
+
+	if highs < exerciseMinHighs || float64(highs)/float64(sessions) < exerciseMinRate {
+		return nil
+	}
+	return []models.Insight{finalize(models.Insight{
+		Kind:     models.InsightHighAfterExercise,
+		Severity: severity,
+		Title:    "Highs after exercise",
+		Summary: fmt.Sprintf("Glucose went above %s within %.0f hours after %d of %d exercise sessions.",
+			a.glucose(float64(a.settings.TargetHigh)), exerciseWindow.Hours(), highs, sessions),
+		Occurrences:   highs,
+		Opportunities: sessions,
+		Evidence:      evidence,
+	})}
+}
+
+// nearest returns the reading closest to t, if one is within maxDiff
+func (a *analysis) nearest(t time.Time, maxDiff time.Duration) (models.GlucoseEntry, bool) {
+	candidates := a.between(t.Add(-maxDiff), t.Add(maxDiff))
+	if len(candidates) == 0 {
+		return models.GlucoseEntry{}, false
+	}
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if absDuration(c.Time().Sub(t)) < absDuration(best.Time().Sub(t)) {
+			best = c
+		}
+	}
+	return best, true
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
@@ -0,0 +1,278 @@
+// Package instance enforces a single running instance and forwards commands to it over local IPC
+package instance
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/logging"
+)
+
+const (
+	lockFileName   = "instance.lock"
+	socketFileName = "instance.sock"
+
+	// ioTimeout bounds how long a single IPC exchange may take
+	ioTimeout = 10 * time.Second
+)
+
+// Control commands understood by the running instance
+const (
+	CommandShow    = "show"
+	CommandSnooze  = "snooze"
+	CommandRefresh = "refresh"
+	CommandStatus  = "status"
+	CommandQuit    = "quit"
+)
+
+// ErrAlreadyRunning is returned by Acquire when another instance holds the lock
+var ErrAlreadyRunning = errors.New("another instance is already running")
+
+// Request is a control command sent by a second launch
+type Request struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// Response is the running instance's answer to a Request
+type Response struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// Handler processes control requests in the running instance
+type Handler func(req Request) Response
+
+// Instance is the lock and IPC endpoint held by the primary instance
+type Instance struct {
+	dir      string
+	lock     *os.File
+	listener net.Listener
+
+	mu      sync.RWMutex
+	handler Handler
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// Acquire takes the single-instance lock in dir and starts listening for
+// control requests. It returns ErrAlreadyRunning if another instance holds it.
+func Acquire(dir string) (*Instance, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	lockPath := filepath.Join(dir, lockFileName)
+	lock, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600) //nolint:gosec // Path is inside the app config dir
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file: %w", err)
+	}
+
+	if err := lockFile(lock); err != nil {
+		_ = lock.Close()
+		return nil, ErrAlreadyRunning
+	}
+
+	// Record our PID for diagnostics
+	_ = lock.Truncate(0)
+	_, _ = lock.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+
+	// We hold the lock, so any existing socket file is stale
+	sockPath := filepath.Join(dir, socketFileName)
+	_ = os.Remove(sockPath)
+
+	listener, err := net.Listen("unix", sockPath)
+	if err != nil {
+		_ = unlockFile(lock)
+		_ = lock.Close()
+		return nil, fmt.Errorf("listening on %s: %w", sockPath, err)
+	}
+
+	inst := &Instance{
+		dir:      dir,
+		lock:     lock,
+		listener: listener,
+	}
+
+	inst.wg.Add(1)
+	go inst.acceptLoop()
+
+	return inst, nil
+}
+
+// SetHandler installs the handler for incoming control requests.
+// Requests received before a handler is set are answered with an error.
+func (i *Instance) SetHandler(handler Handler) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.handler = handler
+}
+
+// Close stops listening and releases the lock
+func (i *Instance) Close() error {
+	i.mu.Lock()
+	if i.closed {
+		i.mu.Unlock()
+		return nil
+	}
+	i.closed = true
+	i.mu.Unlock()
+
+	err := i.listener.Close()
+	i.wg.Wait()
+
+	_ = os.Remove(filepath.Join(i.dir, socketFileName))
+	_ = unlockFile(i.lock)
+	if cerr := i.lock.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (i *Instance) acceptLoop() {
+	defer i.wg.Done()
+
+	for {
+		conn, err := i.listener.Accept()
+		if err != nil {
+			i.mu.RLock()
+			closed := i.closed
+			i.mu.RUnlock()
+			if closed {
+				return
+			}
+			logger.Warn("instance IPC accept failed", "error", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		i.wg.Add(1)
+		go i.serve(conn)
+	}
+}
+
+func (i *Instance) serve(conn net.Conn) {
+	defer i.wg.Done()
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	_ = conn.SetDeadline(time.Now().Add(ioTimeout))
+
+	var req Request
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return
+	}
+
+	var resp Response
+	if err := json.Unmarshal(line, &req); err != nil {
+		resp = Response{Message: "invalid request"}
+	} else {
+		i.mu.RLock()
+		handler := i.handler
+		i.mu.RUnlock()
+
+		if handler == nil {
+			resp = Response{Message: "instance is still starting"}
+		} else {
+			resp = handler(req)
+		}
+	}
+
+	data, _ := json.Marshal(resp)
+	_, _ = conn.Write(append(data, '\n'))
+}
+
+// Send forwards a request to the instance running with config dir dir
+func Send(dir string, req Request) (*Response, error) {
+	conn, err := net.DialTimeout("unix", filepath.Join(dir, socketFileName), ioTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to running instance: %w", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	_ = conn.SetDeadline(time.Now().Add(ioTimeout))
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("sending request: %w", err)
+	}
+
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("parsing response: %w", err)
+	}
+	return &resp, nil
+}
+
+// ParseArgs extracts a control request from command-line flags such as
+// "--snooze 30m", "--refresh", "--status", "--show" or "--quit".
+// It returns false if args contain no control flag.
+func ParseArgs(args []string) (Request, bool) {
+	for idx := 0; idx < len(args); idx++ {
+		arg := strings.TrimLeft(args[idx], "-")
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !strings.HasPrefix(args[idx], "-") {
+			continue
+		}
+
+		switch name {
+		case CommandShow, CommandRefresh, CommandStatus, CommandQuit:
+			return Request{Command: name}, true
+		case CommandSnooze:
+			if !hasValue && idx+1 < len(args) && !strings.HasPrefix(args[idx+1], "-") {
+				value = args[idx+1]
+			}
+			if value == "" {
+				value = "30m"
+			}
+			return Request{Command: CommandSnooze, Args: []string{value}}, true
+		}
+	}
+	return Request{}, false
+}
+
+// ParseSnoozeDuration parses a snooze argument: a Go duration ("30m", "1h"),
+// a plain number of minutes ("30"), or "off"/"0" to cancel a snooze
+func ParseSnoozeDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(strings.ToLower(value))
+	if value == "off" || value == "0" || value == "" {
+		return 0, nil
+	}
+	if minutes, err := strconv.Atoi(value); err == nil {
+		if minutes < 0 {
+			return 0, fmt.Errorf("snooze duration must not be negative")
+		}
+		return time.Duration(minutes) * time.Minute, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid snooze duration %q", value)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("snooze duration must not be negative")
+	}
+	return d, nil
+}
+
+var logger = logging.For(logging.ComponentInstance)
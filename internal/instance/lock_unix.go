@@ -0,0 +1,18 @@
+//go:build !windows
+
+package instance
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes a non-blocking exclusive lock on f
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+// unlockFile releases the lock taken by lockFile
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
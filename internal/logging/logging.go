@@ -0,0 +1,144 @@
+// Package logging sets up structured logging with per-component loggers,
+// a rotating log file and redaction of credentials
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// Component names used across the app
+const (
+	ComponentApp           = "app"
+	ComponentClient        = "client"
+	ComponentLoop          = "loop"
+	ComponentPrediction    = "prediction"
+	ComponentNotifications = "notifications"
+	ComponentHistory       = "history"
+	ComponentWebhooks      = "webhooks"
+	ComponentSettings      = "settings"
+	ComponentInstance      = "instance"
+	ComponentSecrets       = "secrets"
+	ComponentWails         = "wails"
+	ComponentTray          = "tray"
+)
+
+// LogFileName is the name of the current log file in the log directory
+const LogFileName = "nightscout-tray.log"
+
+var (
+	level = new(slog.LevelVar)
+
+	// current is the handler all component loggers write through; Setup replaces it
+	current atomic.Pointer[slog.Handler]
+)
+
+func init() {
+	var h slog.Handler = newRedactingHandler(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	current.Store(&h)
+}
+
+// For returns the logger for a component. Loggers may be created before
+// Setup runs; they pick up its output once it does.
+func For(component string) *slog.Logger {
+	return slog.New(forwardHandler{}).With("component", component)
+}
+
+// Setup writes logs to stderr and to a rotating file in dir
+func Setup(dir string) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	file, err := newRotatingFile(filepath.Join(dir, LogFileName), maxLogSize, maxLogBackups)
+	if err != nil {
+		return err
+	}
+
+	var h slog.Handler = newRedactingHandler(slog.NewTextHandler(io.MultiWriter(os.Stderr, file), &slog.HandlerOptions{Level: level}))
+	current.Store(&h)
+	slog.SetDefault(slog.New(forwardHandler{}))
+	return nil
+}
+
+// SetLevel changes the minimum level logged ("debug", "info", "warn", "error").
+// Unknown names select info.
+func SetLevel(name string) {
+	level.Set(ParseLevel(name))
+}
+
+// ParseLevel converts a level name to a slog level, defaulting to info
+func ParseLevel(name string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// LogFiles returns the current and rotated log files in dir, newest first
+func LogFiles(dir string) []string {
+	base := filepath.Join(dir, LogFileName)
+	files := []string{}
+	for i := 0; i <= maxLogBackups; i++ {
+		path := base
+		if i > 0 {
+			path = backupName(base, i)
+		}
+		if _, err := os.Stat(path); err == nil {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// forwardHandler passes records to the current handler. Attributes and
+// groups added with With are replayed so component loggers stay cheap.
+type forwardHandler struct {
+	attrs []slog.Attr
+	group string
+}
+
+func (f forwardHandler) handler() slog.Handler {
+	h := *current.Load()
+	if len(f.attrs) > 0 {
+		h = h.WithAttrs(f.attrs)
+	}
+	if f.group != "" {
+		h = h.WithGroup(f.group)
+	}
+	return h
+}
+
+func (f forwardHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= level.Level()
+}
+
+func (f forwardHandler) Handle(ctx context.Context, r slog.Record) error {
+	return f.handler().Handle(ctx, r)
+}
+
+func (f forwardHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if f.group != "" {
+		// Attributes after a group belong to it; resolve against the current handler
+		return f.handler().WithAttrs(attrs)
+	}
+	return forwardHandler{attrs: append(append([]slog.Attr(nil), f.attrs...), attrs...)}
+}
+
+func (f forwardHandler) WithGroup(name string) slog.Handler {
+	if f.group != "" {
+		return f.handler().WithGroup(name)
+	}
+	return forwardHandler{attrs: f.attrs, group: name}
+}
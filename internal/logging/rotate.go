@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+const (
+	// maxLogSize is the size at which the log file is rotated
+	maxLogSize = 5 * 1024 * 1024
+
+	// maxLogBackups is the number of rotated files kept
+	maxLogBackups = 3
+)
+
+// rotatingFile is an io.Writer that rotates the file once it exceeds maxSize,
+// keeping maxBackups older files as name.1 (newest) to name.N
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600) //nolint:gosec // Log path is in the app's config directory
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	r.file = f
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size+int64(len(p)) > r.maxSize && r.size > 0 {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts name.N-1 to name.N, ..., name to name.1 and reopens name.
+// If name cannot be moved aside it is reopened for appending, so logging
+// carries on and rotation is retried on the next write.
+func (r *rotatingFile) rotate() error {
+	_ = r.file.Close()
+
+	_ = os.Remove(backupName(r.path, r.maxBackups))
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		_ = os.Rename(backupName(r.path, i), backupName(r.path, i+1))
+	}
+	_ = os.Rename(r.path, backupName(r.path, 1))
+	return r.open()
+}
+
+func backupName(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
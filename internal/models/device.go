@@ -0,0 +1,31 @@
+// Package models contains data structures used throughout the application
+package models
+
+import "time"
+
+// Devices whose age is tracked from the change events logged as treatments
+const (
+	DeviceSensor  = "sensor"
+	DeviceSite    = "site"    // Infusion set cannula
+	DeviceInsulin = "insulin" // Pump reservoir or cartridge
+	DeviceBattery = "battery" // Pump battery
+)
+
+// Device age levels
+const (
+	DeviceAgeUnknown = "unknown" // No change logged
+	DeviceAgeOK      = "ok"
+	DeviceAgeWarn    = "warn"
+	DeviceAgeUrgent  = "urgent"
+)
+
+// DeviceAge is how long a device has been in use since its last logged change
+type DeviceAge struct {
+	Device    string     `json:"device"`              // One of the Device kinds
+	Label     string     `json:"label"`               // Nightscout name: SAGE, CAGE, IAGE or BAGE
+	EventType string     `json:"eventType,omitempty"` // Treatment event the age counts from
+	ChangedAt *time.Time `json:"changedAt,omitempty"`
+	AgeHours  float64    `json:"ageHours"`
+	Level     string     `json:"level"` // One of the DeviceAge levels
+	Notes     string     `json:"notes,omitempty"`
+}
@@ -0,0 +1,36 @@
+// Package models contains data structures used throughout the application
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DeviceStatus is a device status report from Nightscout (uploader, pump, loop)
+type DeviceStatus struct {
+	ID        string          `json:"_id"`
+	Device    string          `json:"device"`
+	CreatedAt string          `json:"created_at"`
+	Mills     int64           `json:"mills,omitempty"`
+	Uploader  *UploaderStatus `json:"uploader,omitempty"`
+	Pump      json.RawMessage `json:"pump,omitempty"`    // Pump state as reported by the uploader
+	OpenAPS   json.RawMessage `json:"openaps,omitempty"` // OpenAPS/AAPS loop state
+	Loop      json.RawMessage `json:"loop,omitempty"`    // Loop (iOS) state
+}
+
+// UploaderStatus describes the phone or device uploading to Nightscout
+type UploaderStatus struct {
+	Battery int `json:"battery"` // Percent
+}
+
+// Time returns the time of the device status report
+func (d *DeviceStatus) Time() time.Time {
+	if d.Mills > 0 {
+		return time.UnixMilli(d.Mills)
+	}
+	parsed, err := time.Parse(time.RFC3339, d.CreatedAt)
+	if err != nil {
+		return time.Time{}
+	}
+	return parsed
+}
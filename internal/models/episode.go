@@ -0,0 +1,68 @@
+// Package models contains data structures used throughout the application
+package models
+
+import "time"
+
+// Episode types
+const (
+	EpisodeHypo  = "hypo"
+	EpisodeHyper = "hyper"
+)
+
+// GlucoseEpisode is a sustained period below or above a glucose threshold
+type GlucoseEpisode struct {
+	Type            string    `json:"type"`      // EpisodeHypo or EpisodeHyper
+	Level           int       `json:"level"`     // 1 or 2 per the consensus definitions
+	Threshold       int       `json:"threshold"` // mg/dL
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"` // First reading back across the threshold, or the last reading if ongoing
+	DurationMinutes float64   `json:"durationMinutes"`
+	Ongoing         bool      `json:"ongoing"` // Data ended before the episode did
+	Night           bool      `json:"night"`   // Started between 00:00 and 06:00 local time
+
+	Extreme      int       `json:"extreme"`      // Nadir for lows, peak for highs, mg/dL
+	ExtremeValue float64   `json:"extremeValue"` // Extreme in the selected unit
+	ExtremeTime  time.Time `json:"extremeTime"`
+
+	// RateBefore is the glucose change in the 30 minutes before the start, in
+	// mg/dL per minute (negative when falling)
+	RateBefore float64 `json:"rateBefore"`
+
+	// Treatments logged during the episode and in the hour after it
+	Treatments        []EpisodeTreatment `json:"treatments"`
+	RescueCarbs       float64            `json:"rescueCarbs"`       // Carbs logged during or after a low
+	CorrectionInsulin float64            `json:"correctionInsulin"` // Insulin logged during or after a high
+
+	// Minutes from the start until glucose was back in the target range;
+	// zero if it did not recover within the data
+	RecoveryMinutes float64 `json:"recoveryMinutes"`
+	Recovered       bool    `json:"recovered"`
+}
+
+// EpisodeTreatment is a treatment logged around an episode
+type EpisodeTreatment struct {
+	Time      time.Time `json:"time"`
+	EventType string    `json:"eventType"`
+	Carbs     float64   `json:"carbs"`
+	Insulin   float64   `json:"insulin"`
+	After     bool      `json:"after"` // Logged after the episode ended
+}
+
+// EpisodeCounts counts episodes by time of day
+type EpisodeCounts struct {
+	Day   int `json:"day"`
+	Night int `json:"night"`
+	Total int `json:"total"`
+}
+
+// EpisodeReport lists the episodes in a time window
+type EpisodeReport struct {
+	From     time.Time        `json:"from"`
+	To       time.Time        `json:"to"`
+	Episodes []GlucoseEpisode `json:"episodes"` // Sorted by start; level 2 episodes also appear within a level 1 one
+	Hypo1    EpisodeCounts    `json:"hypo1"`
+	Hypo2    EpisodeCounts    `json:"hypo2"`
+	Hyper1   EpisodeCounts    `json:"hyper1"`
+	Hyper2   EpisodeCounts    `json:"hyper2"`
+	Unit     string           `json:"unit"` // "mg/dL" or "mmol/L"
+}
@@ -28,7 +28,7 @@ func (g *GlucoseEntry) ValueMgDL() int {
 
 // ValueMmolL returns the glucose value in mmol/L
 func (g *GlucoseEntry) ValueMmolL() float64 {
-	return float64(g.SGV) / 18.0182
+	return ToMmol(float64(g.SGV))
 }
 
 // TrendArrow returns the Unicode arrow character for the trend
@@ -80,17 +80,96 @@ type GlucoseStatus struct {
 	Status       string    `json:"status"`       // "normal", "high", "low", "urgent_high", "urgent_low"
 	StaleMinutes int       `json:"staleMinutes"` // Minutes since last reading
 	IsStale      bool      `json:"isStale"`      // True if data is stale (>15 min)
+	SensorWarmUp bool      `json:"sensorWarmUp"` // Reading taken while a new sensor warms up
+
+	DeviceAges []DeviceAge `json:"deviceAges,omitempty"` // Sensor, site, insulin and battery age
 }
 
 // ChartData represents data for the glucose chart
 type ChartData struct {
-	Entries    []ChartEntry `json:"entries"`
+	Entries    []ChartEntry `json:"entries"` // Readings, or the mean of each bucket
 	TargetLow  int          `json:"targetLow"`
 	TargetHigh int          `json:"targetHigh"`
 	UrgentLow  int          `json:"urgentLow"`
 	UrgentHigh int          `json:"urgentHigh"`
 	TimeRangeH int          `json:"timeRangeHours"`
 	Unit       string       `json:"unit"` // "mg/dL" or "mmol/L"
+
+	Resolution    string        `json:"resolution"`              // "raw", "downsampled", "hourly" or "daily"
+	BucketMinutes int           `json:"bucketMinutes,omitempty"` // Width of Buckets; 0 when Entries are readings
+	SourceCount   int           `json:"sourceCount"`             // Readings the chart was built from
+	Buckets       []ChartBucket `json:"buckets,omitempty"`       // Aggregates, for hourly and daily resolutions
+
+	Markers    []ChartMarker      `json:"markers,omitempty"`    // Treatments within the range
+	IOB        []ChartSeriesPoint `json:"iob,omitempty"`        // Insulin on board at each entry, units
+	COB        []ChartSeriesPoint `json:"cob,omitempty"`        // Carbs on board at each entry, grams
+	Basal      []ChartBasalStep   `json:"basal,omitempty"`      // Basal rate steps; up to a week only
+	Prediction []ChartPrediction  `json:"prediction,omitempty"` // Predicted curve from the last reading, when the range ends now
+}
+
+// Chart marker kinds
+const (
+	ChartMarkerBolus      = "bolus"
+	ChartMarkerCarbs      = "carbs"
+	ChartMarkerTempBasal  = "tempBasal"
+	ChartMarkerTempTarget = "tempTarget"
+	ChartMarkerNote       = "note"
+)
+
+// ChartMarker is a treatment drawn on the chart. Glucose values are in the
+// chart's unit.
+type ChartMarker struct {
+	Time       int64   `json:"time"` // Unix timestamp in milliseconds
+	Kind       string  `json:"kind"` // One of the ChartMarker kinds
+	EventType  string  `json:"eventType"`
+	Insulin    float64 `json:"insulin,omitempty"`   // Units
+	Carbs      float64 `json:"carbs,omitempty"`     // Grams
+	Duration   float64 `json:"duration,omitempty"`  // Minutes, for temp basals and targets
+	Rate       float64 `json:"rate,omitempty"`      // U/h, for absolute temp basals
+	Percent    float64 `json:"percent,omitempty"`   // Change from the scheduled rate, for percent temp basals
+	TargetLow  float64 `json:"targetLow,omitempty"` // Temp target range
+	TargetHigh float64 `json:"targetHigh,omitempty"`
+	Notes      string  `json:"notes,omitempty"`
+	ID         string  `json:"id,omitempty"`
+}
+
+// ChartSeriesPoint is one value of a time series
+type ChartSeriesPoint struct {
+	Time  int64   `json:"time"` // Unix timestamp in milliseconds
+	Value float64 `json:"value"`
+}
+
+// ChartBasalStep is a basal rate in effect from Time until the next step,
+// or the end of the chart for the last one
+type ChartBasalStep struct {
+	Time      int64   `json:"time"`      // Unix timestamp in milliseconds
+	Rate      float64 `json:"rate"`      // U/h delivered
+	Scheduled float64 `json:"scheduled"` // U/h the profile schedules
+	Temp      bool    `json:"temp"`      // Whether a temp basal sets Rate
+}
+
+// ChartPrediction is a predicted point, in the chart's unit
+type ChartPrediction struct {
+	Time       int64   `json:"time"` // Unix timestamp in milliseconds
+	Value      float64 `json:"value"`
+	ValueMg    float64 `json:"valueMg"`
+	Confidence float64 `json:"confidence"` // 0-100
+	LongTerm   bool    `json:"longTerm"`   // Beyond the short-term horizon
+}
+
+// ChartBucket summarises the readings of one hour or day, in the chart's unit
+type ChartBucket struct {
+	Start  int64   `json:"start"` // Unix timestamp in milliseconds
+	End    int64   `json:"end"`
+	Count  int     `json:"count"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	P10    float64 `json:"p10"`
+	P25    float64 `json:"p25"`
+	Median float64 `json:"median"`
+	P75    float64 `json:"p75"`
+	P90    float64 `json:"p90"`
 }
 
 // ChartEntry represents a single point on the chart
@@ -135,3 +214,37 @@ type Thresholds struct {
 	BGTargetTop    int `json:"bgTargetTop"`
 	BGTargetBottom int `json:"bgTargetBottom"`
 }
+
+// AGPData is an ambulatory glucose profile: percentile curves of glucose by
+// time of day over several days, plus each day's readings
+type AGPData struct {
+	From       time.Time    `json:"from"`
+	To         time.Time    `json:"to"`
+	Days       int          `json:"days"`
+	BinMinutes int          `json:"binMinutes"` // Width of each time-of-day bin
+	Bins       []AGPBin     `json:"bins"`       // Bins with enough readings, by time of day
+	Daily      []DailyCurve `json:"daily"`
+	TargetLow  int          `json:"targetLow"`
+	TargetHigh int          `json:"targetHigh"`
+	UrgentLow  int          `json:"urgentLow"`
+	UrgentHigh int          `json:"urgentHigh"`
+	Unit       string       `json:"unit"` // "mg/dL" or "mmol/L"
+}
+
+// AGPBin holds the smoothed glucose percentiles for one time-of-day bin, in the selected unit
+type AGPBin struct {
+	Minute int     `json:"minute"` // Start of the bin in minutes after midnight
+	Count  int     `json:"count"`  // Readings in the bin
+	P5     float64 `json:"p5"`
+	P25    float64 `json:"p25"`
+	P50    float64 `json:"p50"`
+	P75    float64 `json:"p75"`
+	P95    float64 `json:"p95"`
+}
+
+// DailyCurve is the glucose profile of a single day
+type DailyCurve struct {
+	Date    string       `json:"date"` // Local date, "2006-01-02"
+	Entries []ChartEntry `json:"entries"`
+	Mean    float64      `json:"mean"` // In the selected unit
+}
@@ -0,0 +1,73 @@
+// Package models contains data structures used throughout the application
+package models
+
+import "time"
+
+// Insight kinds
+const (
+	InsightNocturnalLows     = "nocturnal_lows"
+	InsightRecurringLows     = "recurring_lows"
+	InsightBreakfastSpikes   = "breakfast_spikes"
+	InsightDawnPhenomenon    = "dawn_phenomenon"
+	InsightHighAfterExercise = "high_after_exercise"
+)
+
+// Insight severities
+const (
+	InsightInfo    = "info"
+	InsightWarning = "warning"
+	InsightUrgent  = "urgent"
+)
+
+// InsightReport lists recurring patterns found in a history window, most
+// important first
+type InsightReport struct {
+	From     time.Time        `json:"from"`
+	To       time.Time        `json:"to"`
+	Days     int              `json:"days"`
+	Unit     string           `json:"unit"` // "mg/dL" or "mmol/L"
+	Findings []Insight        `json:"findings"`
+	Heatmaps *WeekdayHeatmaps `json:"heatmaps"`
+}
+
+// Insight is a recurring pattern with the occasions that show it
+type Insight struct {
+	Kind          string            `json:"kind"` // One of the Insight kinds
+	Severity      string            `json:"severity"`
+	Title         string            `json:"title"`
+	Summary       string            `json:"summary"`
+	Score         float64           `json:"score"`         // Ranking weight; higher is more important
+	Occurrences   int               `json:"occurrences"`   // Occasions showing the pattern
+	Opportunities int               `json:"opportunities"` // Occasions it could have shown on, e.g. nights with data
+	Weekday       *time.Weekday     `json:"weekday,omitempty"`
+	Hour          *int              `json:"hour,omitempty"` // Local hour the pattern clusters at
+	Evidence      []InsightEvidence `json:"evidence"`       // Most recent occasions first
+}
+
+// InsightEvidence is one occasion supporting an insight
+type InsightEvidence struct {
+	Time    time.Time `json:"time"`
+	Value   float64   `json:"value"` // Glucose in the selected unit
+	ValueMg int       `json:"valueMg"`
+	Detail  string    `json:"detail"`
+}
+
+// WeekdayHeatmaps hold percentages of readings by weekday and local hour
+type WeekdayHeatmaps struct {
+	TIR   Heatmap `json:"tir"`   // In the target range
+	Lows  Heatmap `json:"lows"`  // Below the target range
+	Highs Heatmap `json:"highs"` // Above the target range
+}
+
+// Heatmap is a weekday by hour grid. Cells are indexed [weekday][hour]
+// with Sunday as weekday 0.
+type Heatmap struct {
+	Metric string          `json:"metric"`
+	Cells  [][]HeatmapCell `json:"cells"`
+}
+
+// HeatmapCell is the share of readings in one weekday and hour
+type HeatmapCell struct {
+	Readings int     `json:"readings"`
+	Percent  float64 `json:"percent"`
+}
@@ -0,0 +1,41 @@
+// Package models contains data structures used throughout the application
+package models
+
+// Day overlay modes
+const (
+	OverlayYesterday = "yesterday" // Today and yesterday
+	OverlayWeekday   = "weekday"   // Today and the same weekday in previous weeks
+	OverlayDates     = "dates"     // Arbitrary dates
+)
+
+// DayOverlay holds several days of readings aligned on time of day, so they
+// can be drawn on top of each other
+type DayOverlay struct {
+	Mode       string     `json:"mode"`
+	Unit       string     `json:"unit"` // "mg/dL" or "mmol/L"
+	TargetLow  int        `json:"targetLow"`
+	TargetHigh int        `json:"targetHigh"`
+	UrgentLow  int        `json:"urgentLow"`
+	UrgentHigh int        `json:"urgentHigh"`
+	Days       []DayTrace `json:"days"` // Most recent first
+}
+
+// DayTrace is the glucose curve of one local day with a short summary
+type DayTrace struct {
+	Date     string          `json:"date"`  // YYYY-MM-DD
+	Label    string          `json:"label"` // "Today", "Yesterday" or e.g. "Mon 2 Sep"
+	Points   []DayTracePoint `json:"points"`
+	Readings int             `json:"readings"`
+	Mean     float64         `json:"mean"` // In the selected unit
+	TIR      float64         `json:"tir"`  // Percentages with the user's thresholds
+	TBR      float64         `json:"tbr"`
+	TAR      float64         `json:"tar"`
+}
+
+// DayTracePoint is a reading placed by its time of day
+type DayTracePoint struct {
+	Minute  float64 `json:"minute"` // Minutes after local midnight, as shown by the clock
+	Time    int64   `json:"time"`   // Unix timestamp in milliseconds
+	Value   float64 `json:"value"`  // In the selected unit
+	ValueMg int     `json:"valueMg"`
+}
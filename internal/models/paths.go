@@ -0,0 +1,80 @@
+// Package models contains data structures used throughout the application
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Files and directories kept in the config directory. Everything the app
+// stores on disk lives below GetConfigDir.
+const (
+	SettingsFileName         = "settings.json"
+	PredictionParamsFileName = "prediction-params.json"
+	HistoryDirName           = "history"     // Local history store
+	WebhooksDirName          = "webhooks"    // Webhook dead-letter logs
+	LogsDirName              = "logs"        // Rotating log files
+	DiagnosticsDirName       = "diagnostics" // Diagnostic bundles
+	BackupsDirName           = "backups"     // Backup archives
+	ReportsDirName           = "reports"     // Generated HTML and PDF reports
+	ExportsDirName           = "exports"     // Data exports
+)
+
+// GetConfigDir returns the configuration directory path
+func GetConfigDir() (string, error) {
+	var configDir string
+
+	switch runtime.GOOS {
+	case "windows":
+		configDir = os.Getenv("APPDATA")
+		if configDir == "" {
+			configDir = filepath.Join(os.Getenv("USERPROFILE"), "AppData", "Roaming")
+		}
+	case "darwin":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		configDir = filepath.Join(home, "Library", "Application Support")
+	default: // Linux and others
+		configDir = os.Getenv("XDG_CONFIG_HOME")
+		if configDir == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			configDir = filepath.Join(home, ".config")
+		}
+	}
+
+	appDir := filepath.Join(configDir, "nightscout-tray")
+	if err := os.MkdirAll(appDir, 0750); err != nil {
+		return "", err
+	}
+
+	return appDir, nil
+}
+
+// GetConfigPath returns the full path to the config file
+func GetConfigPath() (string, error) {
+	dir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, SettingsFileName), nil
+}
+
+// GetConfigSubdir returns a directory below the config directory, creating it if needed
+func GetConfigSubdir(name string) (string, error) {
+	dir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(path, 0750); err != nil {
+		return "", err
+	}
+	return path, nil
+}
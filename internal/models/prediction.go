@@ -192,12 +192,15 @@ func (d *DiabetesParameters) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// MgdlPerMmol is the glucose conversion factor from mmol/L to mg/dL
+const MgdlPerMmol = 18.0182
+
 // ToMmol converts a mg/dL value to mmol/L
 func ToMmol(mgdl float64) float64 {
-	return mgdl / 18.0182
+	return mgdl / MgdlPerMmol
 }
 
 // ToMgdl converts a mmol/L value to mg/dL
 func ToMgdl(mmol float64) float64 {
-	return mmol * 18.0182
+	return mmol * MgdlPerMmol
 }
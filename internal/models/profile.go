@@ -0,0 +1,146 @@
+package models
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProfileStore is a Nightscout profile document: named therapy profiles
+// that took effect at StartDate
+type ProfileStore struct {
+	ID             string             `json:"_id"`
+	DefaultProfile string             `json:"defaultProfile"`
+	StartDate      string             `json:"startDate"`
+	Mills          int64              `json:"mills"`
+	Units          string             `json:"units"`
+	Store          map[string]Profile `json:"store"`
+}
+
+// Profile holds the schedules of one therapy profile. Glucose values are in
+// Units, which falls back to the store's units when empty.
+type Profile struct {
+	DIA        FlexFloat       `json:"dia"` // Hours
+	CarbRatio  []ScheduleEntry `json:"carbratio"`
+	Sens       []ScheduleEntry `json:"sens"`
+	Basal      []ScheduleEntry `json:"basal"`
+	TargetLow  []ScheduleEntry `json:"target_low"`
+	TargetHigh []ScheduleEntry `json:"target_high"`
+	Timezone   string          `json:"timezone"`
+	Units      string          `json:"units"`
+}
+
+// ScheduleEntry is one step of a daily schedule
+type ScheduleEntry struct {
+	Time          string    `json:"time"` // "HH:MM"
+	Value         FlexFloat `json:"value"`
+	TimeAsSeconds FlexFloat `json:"timeAsSeconds"`
+}
+
+// FlexFloat is a number Nightscout clients write either as a JSON number or
+// as a string
+type FlexFloat float64
+
+// UnmarshalJSON accepts numbers, numeric strings and empty values
+func (f *FlexFloat) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		// Strings that aren't numbers are treated as unset
+		*f = 0
+		if v, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+			*f = FlexFloat(v)
+		}
+		return nil
+	}
+	var v *float64
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v != nil {
+		*f = FlexFloat(*v)
+	}
+	return nil
+}
+
+// Start returns when the profile document took effect
+func (p *ProfileStore) Start() time.Time {
+	if p.Mills > 0 {
+		return time.UnixMilli(p.Mills)
+	}
+	t, err := time.Parse(time.RFC3339, p.StartDate)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// Active returns the named profile, or the default one when name is empty
+// or unknown
+func (p *ProfileStore) Active(name string) (string, *Profile) {
+	if prof, ok := p.Store[name]; ok && name != "" {
+		return name, &prof
+	}
+	if prof, ok := p.Store[p.DefaultProfile]; ok {
+		return p.DefaultProfile, &prof
+	}
+	return "", nil
+}
+
+// GlucoseUnits returns "mg/dL" or "mmol/L" for the profile's glucose values
+func (p *Profile) GlucoseUnits(storeUnits string) string {
+	units := p.Units
+	if units == "" {
+		units = storeUnits
+	}
+	if strings.Contains(strings.ToLower(units), "mmol") {
+		return "mmol/L"
+	}
+	return "mg/dL"
+}
+
+// Location returns the profile's time zone, or fallback when it has none
+func (p *Profile) Location(fallback *time.Location) *time.Location {
+	if p.Timezone != "" {
+		if loc, err := time.LoadLocation(p.Timezone); err == nil {
+			return loc
+		}
+	}
+	return fallback
+}
+
+// Seconds returns the schedule entry's offset from midnight in seconds
+func (e ScheduleEntry) Seconds() int {
+	if e.TimeAsSeconds > 0 {
+		return int(e.TimeAsSeconds)
+	}
+	parts := strings.SplitN(e.Time, ":", 2)
+	h, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0
+	}
+	var m int
+	if len(parts) == 2 {
+		m, _ = strconv.Atoi(strings.TrimSpace(parts[1]))
+	}
+	return h*3600 + m*60
+}
+
+// SortedSchedule returns schedule entries ordered by time of day
+func SortedSchedule(schedule []ScheduleEntry) []ScheduleEntry {
+	out := append([]ScheduleEntry(nil), schedule...)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Seconds() < out[j].Seconds() })
+	return out
+}
+
+// ScheduleValue returns the value of schedule in effect secondsOfDay after midnight
+func ScheduleValue(schedule []ScheduleEntry, secondsOfDay int) float64 {
+	var value float64
+	for i, e := range SortedSchedule(schedule) {
+		if i == 0 || e.Seconds() <= secondsOfDay {
+			value = float64(e.Value)
+		}
+	}
+	return value
+}
@@ -3,21 +3,38 @@ package models
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
-	"path/filepath"
-	"runtime"
 	"sync"
+
+	"github.com/mrcode/nightscout-tray/internal/logging"
+)
+
+// Poll modes used by the update loop depending on the power source
+const (
+	PollModeNormal   = "normal"    // Fetch aligned to every CGM reading
+	PollModeSlow     = "slow"      // Fetch at BatteryRefreshInterval
+	PollModePushOnly = "push-only" // Only check for urgent alerts
 )
 
 // Settings contains all application settings
 type Settings struct {
 	mu sync.RWMutex `json:"-"`
 
+	SettingsFields
+}
+
+// SettingsFields holds the persisted settings values. Keeping them apart from
+// the mutex lets Clone and Update copy every field without listing them.
+type SettingsFields struct {
+	Version int `json:"version"` // Schema version of the settings file, see SettingsVersion
+
 	// Connection settings
 	NightscoutURL string `json:"nightscoutUrl"`
-	APISecret     string `json:"apiSecret"` // Plain API secret (will be hashed)
-	APIToken      string `json:"apiToken"`  // Token-based auth
-	UseToken      bool   `json:"useToken"`  // Use token instead of secret
+	APISecret     string `json:"apiSecret,omitempty"`  // Plain API secret (will be hashed)
+	APIToken      string `json:"apiToken,omitempty"`   // Token-based auth
+	UseToken      bool   `json:"useToken"`             // Use token instead of secret
+	SecretsRef    string `json:"secretsRef,omitempty"` // Where APISecret and APIToken are stored, if not in this file
 
 	// Display settings
 	Unit            string `json:"unit"`            // "mg/dL" or "mmol/L"
@@ -37,6 +54,18 @@ type Settings struct {
 	EnableSoundAlerts     bool `json:"enableSoundAlerts"`
 	RepeatAlertMinutes    int  `json:"repeatAlertMinutes"` // 0 = no repeat
 
+	// Device age settings, in hours of use (0 = no alert at that level)
+	EnableDeviceAgeAlerts bool `json:"enableDeviceAgeAlerts"`
+	SensorAgeWarnHours    int  `json:"sensorAgeWarnHours"`
+	SensorAgeUrgentHours  int  `json:"sensorAgeUrgentHours"`
+	SiteAgeWarnHours      int  `json:"siteAgeWarnHours"`
+	SiteAgeUrgentHours    int  `json:"siteAgeUrgentHours"`
+	InsulinAgeWarnHours   int  `json:"insulinAgeWarnHours"`
+	InsulinAgeUrgentHours int  `json:"insulinAgeUrgentHours"`
+	BatteryAgeWarnHours   int  `json:"batteryAgeWarnHours"`
+	BatteryAgeUrgentHours int  `json:"batteryAgeUrgentHours"`
+	SensorWarmUpMinutes   int  `json:"sensorWarmUpMinutes"` // Readings after a sensor start flagged as warm-up
+
 	// Chart settings
 	ChartTimeRange    int    `json:"chartTimeRange"`    // Hours (default 4)
 	ChartMaxHistory   int    `json:"chartMaxHistory"`   // Days (default 7)
@@ -47,16 +76,30 @@ type Settings struct {
 	ChartColorUrgent  string `json:"chartColorUrgent"`
 	ChartShowTarget   bool   `json:"chartShowTarget"` // Show target range band
 	ChartShowNow      bool   `json:"chartShowNow"`    // Show current time marker
+	AGPDays           int    `json:"agpDays"`         // Days in the ambulatory glucose profile (default 14)
+
+	// History settings
+	HistoryRetentionDays int `json:"historyRetentionDays"` // Days kept in the local history store (0 = forever)
 
 	// System settings
-	StartMinimized bool `json:"startMinimized"`
-	AutoStart      bool `json:"autoStart"`
-	ShowInTaskbar  bool `json:"showInTaskbar"` // Windows only
+	StartMinimized bool   `json:"startMinimized"`
+	AutoStart      bool   `json:"autoStart"`
+	ShowInTaskbar  bool   `json:"showInTaskbar"` // Windows only
+	LogLevel       string `json:"logLevel"`      // "debug", "info", "warn" or "error"
 
 	// Prediction settings
 	PredictionMode string `json:"predictionMode"` // "statistical" or "ml"
 	ShowKEFactor   bool   `json:"showKEFactor"`   // Show KE Factor instead of/alongside ICR
 
+	// Integration settings
+	Webhooks []WebhookTarget `json:"webhooks"` // Outbound webhook targets
+
+	// Power settings
+	BatteryPollMode        string `json:"batteryPollMode"`        // PollMode* used while on battery
+	LowBatteryPollMode     string `json:"lowBatteryPollMode"`     // PollMode* used below LowBatteryPercent
+	LowBatteryPercent      int    `json:"lowBatteryPercent"`      // Battery level considered low
+	BatteryRefreshInterval int    `json:"batteryRefreshInterval"` // Seconds between fetches in "slow" mode, capped at one CGM interval
+
 	// Window state (not user-configurable)
 	WindowWidth  int `json:"windowWidth"`
 	WindowHeight int `json:"windowHeight"`
@@ -66,7 +109,9 @@ type Settings struct {
 
 // DefaultSettings returns settings with default values
 func DefaultSettings() *Settings {
-	return &Settings{
+	return &Settings{SettingsFields: SettingsFields{
+		Version: SettingsVersion,
+
 		NightscoutURL:   "",
 		APISecret:       "",
 		APIToken:        "",
@@ -86,6 +131,17 @@ func DefaultSettings() *Settings {
 		EnableSoundAlerts:     true,
 		RepeatAlertMinutes:    15,
 
+		EnableDeviceAgeAlerts: true,
+		SensorAgeWarnHours:    9 * 24, // Day 10
+		SensorAgeUrgentHours:  10 * 24,
+		SiteAgeWarnHours:      48,
+		SiteAgeUrgentHours:    72,
+		InsulinAgeWarnHours:   72,
+		InsulinAgeUrgentHours: 96,
+		BatteryAgeWarnHours:   14 * 24,
+		BatteryAgeUrgentHours: 15 * 24,
+		SensorWarmUpMinutes:   120,
+
 		ChartTimeRange:    4,
 		ChartMaxHistory:   7,
 		ChartStyle:        "both",
@@ -95,63 +151,28 @@ func DefaultSettings() *Settings {
 		ChartColorUrgent:  "#ef4444", // Red
 		ChartShowTarget:   true,
 		ChartShowNow:      true,
+		AGPDays:           14,
+
+		HistoryRetentionDays: 365,
 
 		StartMinimized: true,
 		AutoStart:      false,
 		ShowInTaskbar:  true,
+		LogLevel:       "info",
 
 		PredictionMode: "statistical",
 		ShowKEFactor:   true,
 
+		BatteryPollMode:        PollModeSlow,
+		LowBatteryPollMode:     PollModePushOnly,
+		LowBatteryPercent:      20,
+		BatteryRefreshInterval: 300,
+
 		WindowWidth:  900,
 		WindowHeight: 700,
 		WindowX:      -1,
 		WindowY:      -1,
-	}
-}
-
-// GetConfigDir returns the configuration directory path
-func GetConfigDir() (string, error) {
-	var configDir string
-
-	switch runtime.GOOS {
-	case "windows":
-		configDir = os.Getenv("APPDATA")
-		if configDir == "" {
-			configDir = filepath.Join(os.Getenv("USERPROFILE"), "AppData", "Roaming")
-		}
-	case "darwin":
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return "", err
-		}
-		configDir = filepath.Join(home, "Library", "Application Support")
-	default: // Linux and others
-		configDir = os.Getenv("XDG_CONFIG_HOME")
-		if configDir == "" {
-			home, err := os.UserHomeDir()
-			if err != nil {
-				return "", err
-			}
-			configDir = filepath.Join(home, ".config")
-		}
-	}
-
-	appDir := filepath.Join(configDir, "nightscout-tray")
-	if err := os.MkdirAll(appDir, 0750); err != nil {
-		return "", err
-	}
-
-	return appDir, nil
-}
-
-// GetConfigPath returns the full path to the config file
-func GetConfigPath() (string, error) {
-	dir, err := GetConfigDir()
-	if err != nil {
-		return "", err
-	}
-	return filepath.Join(dir, "settings.json"), nil
+	}}
 }
 
 // Load loads settings from disk
@@ -174,10 +195,33 @@ func (s *Settings) Load() error {
 		return err
 	}
 
-	if err := json.Unmarshal(data, s); err != nil {
+	migrated, from, err := migrateSettings(data)
+	if err != nil {
+		return fmt.Errorf("migrating settings: %w", err)
+	}
+
+	if err := json.Unmarshal(migrated, s); err != nil {
 		return err
 	}
 
+	if err := s.resolveSecretsLocked(path); err != nil {
+		settingsLogger.Error("loading credentials failed", "error", err)
+	}
+
+	if from > SettingsVersion {
+		settingsLogger.Warn("settings file is newer than supported", "version", from, "supported", SettingsVersion)
+	}
+	if from < SettingsVersion {
+		// Keep the original so a failed migration can be recovered by hand
+		if err := backupSettings(path, data, from); err != nil {
+			return fmt.Errorf("backing up settings: %w", err)
+		}
+		if err := s.writeLocked(path); err != nil {
+			return fmt.Errorf("saving migrated settings: %w", err)
+		}
+		settingsLogger.Info("migrated settings", "from", from, "to", SettingsVersion)
+	}
+
 	return nil
 }
 
@@ -191,7 +235,18 @@ func (s *Settings) Save() error {
 		return err
 	}
 
-	data, err := json.MarshalIndent(s, "", "  ")
+	return s.writeLocked(path)
+}
+
+// writeLocked writes the settings to path. Credentials are moved to the
+// secret store when one is set. The caller must hold s.mu.
+func (s *Settings) writeLocked(path string) error {
+	fields := s.SettingsFields
+	if err := storeSecrets(&fields); err != nil {
+		return fmt.Errorf("storing credentials: %w", err)
+	}
+
+	data, err := json.MarshalIndent(&fields, "", "  ")
 	if err != nil {
 		return err
 	}
@@ -217,44 +272,22 @@ func (s *Settings) Update(other *Settings) {
 	other.mu.RLock()
 	defer other.mu.RUnlock()
 
+	// The schema version describes the file, not the values being applied
+	version := s.Version
 	s.copySettingsFields(other)
+	s.Version = version
 }
 
-// copySettingsFields copies all fields from other to s, excluding the mutex
-// The caller must hold the necessary locks on s and other (if other is shared)
+// copySettingsFields copies all fields from other to s, excluding the mutex.
+// The caller must hold the necessary locks on s and other (if other is shared).
 func (s *Settings) copySettingsFields(other *Settings) {
-	s.NightscoutURL = other.NightscoutURL
-	s.APISecret = other.APISecret
-	s.APIToken = other.APIToken
-	s.UseToken = other.UseToken
-	s.Unit = other.Unit
-	s.RefreshInterval = other.RefreshInterval
-	s.TargetLow = other.TargetLow
-	s.TargetHigh = other.TargetHigh
-	s.UrgentLow = other.UrgentLow
-	s.UrgentHigh = other.UrgentHigh
-	s.EnableHighAlert = other.EnableHighAlert
-	s.EnableLowAlert = other.EnableLowAlert
-	s.EnableUrgentHighAlert = other.EnableUrgentHighAlert
-	s.EnableUrgentLowAlert = other.EnableUrgentLowAlert
-	s.EnableSoundAlerts = other.EnableSoundAlerts
-	s.RepeatAlertMinutes = other.RepeatAlertMinutes
-	s.ChartTimeRange = other.ChartTimeRange
-	s.ChartMaxHistory = other.ChartMaxHistory
-	s.ChartStyle = other.ChartStyle
-	s.ChartColorInRange = other.ChartColorInRange
-	s.ChartColorHigh = other.ChartColorHigh
-	s.ChartColorLow = other.ChartColorLow
-	s.ChartColorUrgent = other.ChartColorUrgent
-	s.ChartShowTarget = other.ChartShowTarget
-	s.ChartShowNow = other.ChartShowNow
-	s.StartMinimized = other.StartMinimized
-	s.AutoStart = other.AutoStart
-	s.ShowInTaskbar = other.ShowInTaskbar
-	s.WindowWidth = other.WindowWidth
-	s.WindowHeight = other.WindowHeight
-	s.WindowX = other.WindowX
-	s.WindowY = other.WindowY
+	s.SettingsFields = other.SettingsFields
+
+	// Reference types are deep-copied so copies never share state
+	s.Webhooks = nil
+	for _, w := range other.Webhooks {
+		s.Webhooks = append(s.Webhooks, w.Clone())
+	}
 }
 
 // IsConfigured returns true if minimum required settings are set
@@ -283,3 +316,5 @@ func (s *Settings) GetGlucoseStatus(mgdl int) string {
 		return "normal"
 	}
 }
+
+var settingsLogger = logging.For(logging.ComponentSettings)
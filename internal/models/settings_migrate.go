@@ -0,0 +1,130 @@
+// Package models contains data structures used throughout the application
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SettingsVersion is the current settings schema version. When a field is
+// renamed, removed or restructured, bump it and append a migration to
+// settingsMigrations that rewrites older documents.
+const SettingsVersion = 1
+
+// settingsMigration upgrades a raw settings document by one version
+type settingsMigration struct {
+	version int // Version the document has after this migration
+	migrate func(doc map[string]json.RawMessage) error
+}
+
+// settingsMigrations are applied in order to documents older than their version
+var settingsMigrations = []settingsMigration{
+	{version: 1, migrate: migrateSettingsV1},
+}
+
+// migrateSettings upgrades a settings file to SettingsVersion. It returns the
+// migrated document and the version the file had. Files written by a newer
+// version are returned unchanged.
+func migrateSettings(data []byte) ([]byte, int, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, 0, err
+	}
+
+	from := 0
+	if raw, ok := doc["version"]; ok {
+		if err := json.Unmarshal(raw, &from); err != nil {
+			return nil, 0, fmt.Errorf("reading version: %w", err)
+		}
+	}
+	if from >= SettingsVersion {
+		return data, from, nil
+	}
+
+	for _, m := range settingsMigrations {
+		if m.version <= from {
+			continue
+		}
+		if err := m.migrate(doc); err != nil {
+			return nil, from, fmt.Errorf("migrating to version %d: %w", m.version, err)
+		}
+		doc["version"] = json.RawMessage(fmt.Sprintf("%d", m.version))
+	}
+
+	migrated, err := json.Marshal(doc)
+	if err != nil {
+		return nil, from, err
+	}
+	return migrated, from, nil
+}
+
+// ParseSettings reads a settings document from outside the config directory,
+// such as a backup, migrating it to the current schema. Credential
+// references are dropped since they point at another machine's secret store.
+func ParseSettings(data []byte) (*Settings, error) {
+	migrated, _, err := migrateSettings(data)
+	if err != nil {
+		return nil, fmt.Errorf("migrating settings: %w", err)
+	}
+
+	settings := DefaultSettings()
+	if err := json.Unmarshal(migrated, settings); err != nil {
+		return nil, err
+	}
+	settings.Version = SettingsVersion
+	settings.SecretsRef = ""
+	return settings, nil
+}
+
+// migrateSettingsV1 handles files written before settings were versioned.
+// Settings added since then are filled in with their defaults explicitly,
+// so the file no longer depends on defaults being applied before loading.
+func migrateSettingsV1(doc map[string]json.RawMessage) error {
+	defaults, err := json.Marshal(DefaultSettings())
+	if err != nil {
+		return err
+	}
+
+	var defaultDoc map[string]json.RawMessage
+	if err := json.Unmarshal(defaults, &defaultDoc); err != nil {
+		return err
+	}
+
+	for key, value := range defaultDoc {
+		if _, ok := doc[key]; !ok {
+			doc[key] = value
+		}
+	}
+	return nil
+}
+
+// backupSettings writes the pre-migration settings next to the original,
+// never overwriting an earlier backup. Credentials are left out when a
+// secret store will hold them.
+func backupSettings(path string, data []byte, version int) error {
+	if currentSecretStore() != nil {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		delete(doc, "apiSecret")
+		delete(doc, "apiToken")
+
+		scrubbed, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return err
+		}
+		data = scrubbed
+	}
+
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	backup := fmt.Sprintf("%s.v%d.bak.json", base, version)
+	if _, err := os.Stat(backup); err == nil {
+		backup = fmt.Sprintf("%s.v%d.%s.bak.json", base, version, time.Now().Format("20060102-150405"))
+	}
+	return os.WriteFile(backup, data, 0600)
+}
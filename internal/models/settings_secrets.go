@@ -0,0 +1,115 @@
+// Package models contains data structures used throughout the application
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Keys of the credentials in the secret store
+const (
+	secretKeyAPISecret = "api-secret"
+	secretKeyAPIToken  = "api-token"
+)
+
+// SecretStore keeps credentials outside the settings file
+type SecretStore interface {
+	// Get returns the value stored under key; a missing key is an error
+	Get(key string) (string, error)
+	// Set stores value under key and returns the name of the backend used.
+	// An empty value removes the key.
+	Set(key, value string) (string, error)
+}
+
+var (
+	secretStoreMu sync.RWMutex
+	secretStore   SecretStore
+)
+
+// SetSecretStore makes Load and Save keep credentials in store instead of the
+// settings file. Plaintext credentials found by Load are moved to the store.
+func SetSecretStore(store SecretStore) {
+	secretStoreMu.Lock()
+	defer secretStoreMu.Unlock()
+	secretStore = store
+}
+
+func currentSecretStore() SecretStore {
+	secretStoreMu.RLock()
+	defer secretStoreMu.RUnlock()
+	return secretStore
+}
+
+// storeSecrets moves the credentials in fields into the secret store and
+// replaces them with a reference. Without a store, fields are left as is.
+func storeSecrets(fields *SettingsFields) error {
+	store := currentSecretStore()
+	if store == nil {
+		return nil
+	}
+
+	backendSecret, err := store.Set(secretKeyAPISecret, fields.APISecret)
+	if err != nil {
+		return err
+	}
+	backendToken, err := store.Set(secretKeyAPIToken, fields.APIToken)
+	if err != nil {
+		return err
+	}
+
+	fields.APISecret = ""
+	fields.APIToken = ""
+	fields.SecretsRef = backendSecret
+	if fields.SecretsRef == "" {
+		fields.SecretsRef = backendToken
+	}
+	return nil
+}
+
+// resolveSecretsLocked fills in credentials after loading. Referenced
+// credentials are read from the secret store; plaintext ones are migrated
+// into it by rewriting the file. The caller must hold s.mu.
+func (s *Settings) resolveSecretsLocked(path string) error {
+	store := currentSecretStore()
+	if store == nil {
+		if s.SecretsRef != "" {
+			return fmt.Errorf("credentials are stored in %s, but no secret store is available", s.SecretsRef)
+		}
+		return nil
+	}
+
+	if s.SecretsRef == "" {
+		if s.APISecret == "" && s.APIToken == "" {
+			return nil
+		}
+		if err := s.writeLocked(path); err != nil {
+			return err
+		}
+		settingsLogger.Info("moved credentials from the settings file to the secret store")
+		return nil
+	}
+
+	var errs []error
+	secret, err := store.Get(secretKeyAPISecret)
+	if err == nil {
+		s.APISecret = secret
+	} else if !isNotFound(err) {
+		errs = append(errs, fmt.Errorf("reading API secret: %w", err))
+	}
+
+	token, err := store.Get(secretKeyAPIToken)
+	if err == nil {
+		s.APIToken = token
+	} else if !isNotFound(err) {
+		errs = append(errs, fmt.Errorf("reading API token: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// isNotFound reports whether err means a key has no stored value
+func isNotFound(err error) bool {
+	var nf interface{ NotFound() bool }
+	return errors.As(err, &nf) && nf.NotFound()
+}
@@ -0,0 +1,226 @@
+// Package models contains data structures used throughout the application
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Validation error codes, stable for the frontend to map to messages
+const (
+	ErrCodeRequired     = "required"      // A value must be given
+	ErrCodeInvalidURL   = "invalid_url"   // Not an absolute http(s) URL
+	ErrCodeInvalidValue = "invalid_value" // Not one of the allowed values
+	ErrCodeOutOfRange   = "out_of_range"  // Number outside the allowed range
+	ErrCodeInvalidOrder = "invalid_order" // Thresholds are not in ascending order
+	ErrCodeInvalidColor = "invalid_color" // Not a #rgb or #rrggbb hex color
+)
+
+// Bounds applied by Normalize and Validate
+const (
+	MinRefreshInterval = 30  // Seconds
+	MaxRefreshInterval = 600 // Seconds
+	minGlucoseSetting  = 20  // mg/dL
+	maxGlucoseSetting  = 600 // mg/dL
+	maxDeviceAgeHours  = 60 * 24
+)
+
+var hexColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// FieldError describes one invalid setting. Field is the JSON field name,
+// with an index for list items (e.g. "webhooks[0].url").
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid setting found
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+// Error implements error
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return "invalid settings: " + strings.Join(msgs, "; ")
+}
+
+// Add records an invalid field
+func (e *ValidationError) Add(field, code, format string, args ...any) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// OrNil returns e if it holds any errors, nil otherwise
+func (e *ValidationError) OrNil() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Normalize cleans up values that have an obvious intended meaning: it trims
+// the Nightscout URL and strips a trailing /api/v1, clamps the refresh
+// interval to MinRefreshInterval-MaxRefreshInterval and fills in a missing
+// log level
+func (s *Settings) Normalize() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.NightscoutURL = NormalizeNightscoutURL(s.NightscoutURL)
+	s.APISecret = strings.TrimSpace(s.APISecret)
+	s.APIToken = strings.TrimSpace(s.APIToken)
+	s.RefreshInterval = max(MinRefreshInterval, min(MaxRefreshInterval, s.RefreshInterval))
+	if s.LogLevel == "" {
+		s.LogLevel = "info"
+	}
+
+	for i := range s.Webhooks {
+		s.Webhooks[i].URL = strings.TrimSpace(s.Webhooks[i].URL)
+	}
+}
+
+// NormalizeNightscoutURL trims whitespace, trailing slashes and an /api/v1 suffix
+func NormalizeNightscoutURL(raw string) string {
+	u := strings.TrimRight(strings.TrimSpace(raw), "/")
+	if strings.HasSuffix(strings.ToLower(u), "/api/v1") {
+		u = strings.TrimRight(u[:len(u)-len("/api/v1")], "/")
+	}
+	return u
+}
+
+// Validate checks all settings and returns a *ValidationError listing every
+// problem, or nil
+func (s *Settings) Validate() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	errs := &ValidationError{}
+
+	if s.NightscoutURL != "" {
+		validateURL(errs, "nightscoutUrl", s.NightscoutURL)
+	}
+	if s.UseToken && s.NightscoutURL != "" && s.APIToken == "" {
+		errs.Add("apiToken", ErrCodeRequired, "an access token is required when token authentication is enabled")
+	}
+
+	validateOneOf(errs, "unit", s.Unit, "mg/dL", "mmol/L")
+	validateRange(errs, "refreshInterval", s.RefreshInterval, MinRefreshInterval, MaxRefreshInterval)
+
+	thresholds := []struct {
+		field string
+		value int
+	}{
+		{"urgentLow", s.UrgentLow},
+		{"targetLow", s.TargetLow},
+		{"targetHigh", s.TargetHigh},
+		{"urgentHigh", s.UrgentHigh},
+	}
+	inRange := true
+	for _, t := range thresholds {
+		if !validateRange(errs, t.field, t.value, minGlucoseSetting, maxGlucoseSetting) {
+			inRange = false
+		}
+	}
+	if inRange {
+		if s.UrgentLow > s.TargetLow {
+			errs.Add("urgentLow", ErrCodeInvalidOrder, "urgent low must not be above target low")
+		}
+		if s.TargetLow >= s.TargetHigh {
+			errs.Add("targetHigh", ErrCodeInvalidOrder, "target high must be above target low")
+		}
+		if s.TargetHigh > s.UrgentHigh {
+			errs.Add("urgentHigh", ErrCodeInvalidOrder, "urgent high must not be below target high")
+		}
+	}
+
+	validateRange(errs, "repeatAlertMinutes", s.RepeatAlertMinutes, 0, 24*60)
+
+	deviceAges := []struct {
+		device       string
+		warn, urgent int
+	}{
+		{"sensor", s.SensorAgeWarnHours, s.SensorAgeUrgentHours},
+		{"site", s.SiteAgeWarnHours, s.SiteAgeUrgentHours},
+		{"insulin", s.InsulinAgeWarnHours, s.InsulinAgeUrgentHours},
+		{"battery", s.BatteryAgeWarnHours, s.BatteryAgeUrgentHours},
+	}
+	for _, d := range deviceAges {
+		warnOK := validateRange(errs, d.device+"AgeWarnHours", d.warn, 0, maxDeviceAgeHours)
+		urgentOK := validateRange(errs, d.device+"AgeUrgentHours", d.urgent, 0, maxDeviceAgeHours)
+		if warnOK && urgentOK && d.warn > 0 && d.urgent > 0 && d.warn > d.urgent {
+			errs.Add(d.device+"AgeUrgentHours", ErrCodeInvalidOrder, "urgent age must not be below warning age")
+		}
+	}
+	validateRange(errs, "sensorWarmUpMinutes", s.SensorWarmUpMinutes, 0, 24*60)
+	validateRange(errs, "chartTimeRange", s.ChartTimeRange, 1, 24*90)
+	validateRange(errs, "chartMaxHistory", s.ChartMaxHistory, 1, 365)
+	validateOneOf(errs, "chartStyle", s.ChartStyle, "line", "points", "both")
+	validateColor(errs, "chartColorInRange", s.ChartColorInRange)
+	validateColor(errs, "chartColorHigh", s.ChartColorHigh)
+	validateColor(errs, "chartColorLow", s.ChartColorLow)
+	validateColor(errs, "chartColorUrgent", s.ChartColorUrgent)
+	validateRange(errs, "agpDays", s.AGPDays, 1, 90)
+
+	validateOneOf(errs, "logLevel", s.LogLevel, "debug", "info", "warn", "error")
+	validateRange(errs, "historyRetentionDays", s.HistoryRetentionDays, 0, 3650)
+	validateOneOf(errs, "predictionMode", s.PredictionMode, "statistical", "ml")
+
+	validateOneOf(errs, "batteryPollMode", s.BatteryPollMode, PollModeNormal, PollModeSlow, PollModePushOnly)
+	validateOneOf(errs, "lowBatteryPollMode", s.LowBatteryPollMode, PollModeNormal, PollModeSlow, PollModePushOnly)
+	validateRange(errs, "lowBatteryPercent", s.LowBatteryPercent, 0, 100)
+	validateRange(errs, "batteryRefreshInterval", s.BatteryRefreshInterval, MinRefreshInterval, 3600)
+
+	for i, w := range s.Webhooks {
+		prefix := fmt.Sprintf("webhooks[%d].", i)
+		if strings.TrimSpace(w.Name) == "" {
+			errs.Add(prefix+"name", ErrCodeRequired, "a name is required")
+		}
+		if w.URL == "" {
+			errs.Add(prefix+"url", ErrCodeRequired, "a URL is required")
+		} else {
+			validateURL(errs, prefix+"url", w.URL)
+		}
+	}
+
+	return errs.OrNil()
+}
+
+func validateURL(errs *ValidationError, field, raw string) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		errs.Add(field, ErrCodeInvalidURL, "must be an absolute URL such as https://example.com")
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		errs.Add(field, ErrCodeInvalidURL, "must start with http:// or https://")
+	}
+}
+
+func validateRange(errs *ValidationError, field string, value, lo, hi int) bool {
+	if value < lo || value > hi {
+		errs.Add(field, ErrCodeOutOfRange, "must be between %d and %d", lo, hi)
+		return false
+	}
+	return true
+}
+
+func validateOneOf(errs *ValidationError, field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	errs.Add(field, ErrCodeInvalidValue, "must be one of %s", strings.Join(allowed, ", "))
+}
+
+func validateColor(errs *ValidationError, field, value string) {
+	if !hexColorPattern.MatchString(value) {
+		errs.Add(field, ErrCodeInvalidColor, "must be a hex color like #4ade80")
+	}
+}
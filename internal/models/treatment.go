@@ -68,7 +68,8 @@ func (t *Treatment) IsBolus() bool {
 		"Combo Bolus":      true,
 		"Bolus Wizard":     true,
 	}
-	return bolusTypes[t.EventType] || (t.HasInsulin() && t.EventType != "Temp Basal")
+	// Long-acting injections (e.g. imported from pen logs) count as basal
+	return bolusTypes[t.EventType] || (t.HasInsulin() && t.EventType != "Temp Basal" && t.EventType != "Long-Acting Insulin")
 }
 
 // IsMealBolus returns true if this appears to be a meal-related bolus
@@ -103,6 +104,7 @@ var TreatmentEventTypes = struct {
 	TemporaryTarget    string
 	OpenAPSOffline     string
 	BolusWizard        string
+	LongActingInsulin  string
 }{
 	BGCheck:            "BG Check",
 	SnackBolus:         "Snack Bolus",
@@ -125,4 +127,5 @@ var TreatmentEventTypes = struct {
 	TemporaryTarget:    "Temporary Target",
 	OpenAPSOffline:     "OpenAPS Offline",
 	BolusWizard:        "Bolus Wizard",
+	LongActingInsulin:  "Long-Acting Insulin",
 }
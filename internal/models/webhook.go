@@ -0,0 +1,51 @@
+// Package models contains data structures used throughout the application
+package models
+
+import "strings"
+
+// Webhook event types
+const (
+	WebhookEventReading = "reading" // A new glucose reading arrived
+	WebhookEventAlert   = "alert"   // A glucose alert fired
+)
+
+// WebhookTarget describes an outbound webhook destination
+type WebhookTarget struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	URL          string   `json:"url"`
+	Enabled      bool     `json:"enabled"`
+	Events       []string `json:"events"`       // "reading", "alert" or "alert:<type>"; empty = all events
+	Secret       string   `json:"secret"`       // Optional HMAC-SHA256 signing secret
+	BodyTemplate string   `json:"bodyTemplate"` // Optional Go template producing the JSON body
+}
+
+// Accepts returns true if the target subscribes to the given event.
+// alertType is only considered for alert events (e.g. "urgent_low").
+func (w *WebhookTarget) Accepts(event, alertType string) bool {
+	if !w.Enabled || w.URL == "" {
+		return false
+	}
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		e = strings.TrimSpace(e)
+		if e == event {
+			return true
+		}
+		if event == WebhookEventAlert && alertType != "" && e == WebhookEventAlert+":"+alertType {
+			return true
+		}
+	}
+	return false
+}
+
+// Clone returns a deep copy of the webhook target
+func (w WebhookTarget) Clone() WebhookTarget {
+	c := w
+	if w.Events != nil {
+		c.Events = append([]string(nil), w.Events...)
+	}
+	return c
+}
@@ -12,9 +12,13 @@ import (
 	"strings"
 	"time"
 
+	"github.com/mrcode/nightscout-tray/internal/logging"
 	"github.com/mrcode/nightscout-tray/internal/models"
 )
 
+// maxErrorSnippet bounds how much of an error response ends up in errors
+const maxErrorSnippet = 200
+
 // Client handles communication with the Nightscout API
 type Client struct {
 	baseURL    string
@@ -86,12 +90,23 @@ func (c *Client) doRequest(req *http.Request) ([]byte, error) {
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+		logger.Debug("API error response", "status", resp.StatusCode, "path", req.URL.Path, "body", string(body))
+		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, errorSnippet(body))
 	}
 
 	return body, nil
 }
 
+// errorSnippet returns the start of an error response body for error
+// messages; the full body is only logged at debug level
+func errorSnippet(body []byte) string {
+	snippet := strings.TrimSpace(string(body))
+	if len(snippet) > maxErrorSnippet {
+		snippet = strings.ToValidUTF8(snippet[:maxErrorSnippet], "") + "..."
+	}
+	return snippet
+}
+
 // GetStatus retrieves the Nightscout server status
 func (c *Client) GetStatus() (*models.ServerStatus, error) {
 	req, err := c.buildRequest("GET", "/api/v1/status", nil)
@@ -167,7 +182,7 @@ func (c *Client) GetEntries(from, to time.Time, count int) ([]models.GlucoseEntr
 		params.Set("find[date][$lte]", fmt.Sprintf("%d", currentTo.UnixMilli()))
 		params.Set("count", fmt.Sprintf("%d", maxPerRequest))
 
-		fmt.Printf("GetEntries page %d: from=%s to=%s\n", pageNum, from.Format("2006-01-02"), currentTo.Format("2006-01-02 15:04"))
+		logger.Debug("fetching entries page", "page", pageNum, "from", from, "to", currentTo)
 		
 		req, err := c.buildRequest("GET", "/api/v1/entries/sgv", params)
 		if err != nil {
@@ -184,18 +199,16 @@ func (c *Client) GetEntries(from, to time.Time, count int) ([]models.GlucoseEntr
 			return nil, fmt.Errorf("parsing entries: %w", err)
 		}
 		
-		fmt.Printf("  Page %d: received %d entries\n", pageNum, len(entries))
+		logger.Debug("received entries page", "page", pageNum, "count", len(entries), "total", len(allEntries)+len(entries))
 
 		if len(entries) == 0 {
 			break
 		}
 
 		allEntries = append(allEntries, entries...)
-		fmt.Printf("  Total entries so far: %d\n", len(allEntries))
 
 		// Check if we got fewer entries than requested - we've reached the end
 		if len(entries) < maxPerRequest {
-			fmt.Println("  Less than maxPerRequest, done paginating")
 			break
 		}
 
@@ -241,9 +254,8 @@ func (c *Client) GetEntries(from, to time.Time, count int) ([]models.GlucoseEntr
 				newest = t
 			}
 		}
-		days := newest.Sub(oldest).Hours() / 24
-		fmt.Printf("GetEntries complete: %d entries spanning %.1f days (%s to %s)\n", 
-			len(allEntries), days, oldest.Format("2006-01-02"), newest.Format("2006-01-02"))
+		logger.Debug("fetched entries", "count", len(allEntries),
+			"days", fmt.Sprintf("%.1f", newest.Sub(oldest).Hours()/24), "oldest", oldest, "newest", newest)
 	}
 
 	return allEntries, nil
@@ -443,3 +455,67 @@ func (c *Client) GetCarbTreatments(from, to time.Time) ([]models.Treatment, erro
 
 	return carbTreatments, nil
 }
+
+// GetLatestTreatment retrieves the most recent treatment of the given event
+// type created since from, or nil if there is none. Without a date filter
+// Nightscout only searches the last few days.
+func (c *Client) GetLatestTreatment(eventType string, from time.Time) (*models.Treatment, error) {
+	params := url.Values{}
+	params.Set("find[eventType]", eventType)
+	params.Set("find[created_at][$gte]", from.Format(time.RFC3339))
+	params.Set("count", "1")
+
+	req, err := c.buildRequest("GET", "/api/v1/treatments", params)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var treatments []models.Treatment
+	if err := json.Unmarshal(body, &treatments); err != nil {
+		return nil, fmt.Errorf("parsing treatments: %w", err)
+	}
+	if len(treatments) == 0 {
+		return nil, nil
+	}
+
+	return &treatments[0], nil
+}
+
+// GetDeviceStatus retrieves device status reports created between from and to
+func (c *Client) GetDeviceStatus(from, to time.Time, count int) ([]models.DeviceStatus, error) {
+	params := url.Values{}
+	if !from.IsZero() {
+		params.Set("find[created_at][$gte]", from.UTC().Format(time.RFC3339))
+	}
+	if !to.IsZero() {
+		params.Set("find[created_at][$lte]", to.UTC().Format(time.RFC3339))
+	}
+	if count <= 0 {
+		count = 10000
+	}
+	params.Set("count", fmt.Sprintf("%d", count))
+
+	req, err := c.buildRequest("GET", "/api/v1/devicestatus.json", params)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []models.DeviceStatus
+	if err := json.Unmarshal(body, &statuses); err != nil {
+		return nil, fmt.Errorf("parsing device status: %w", err)
+	}
+
+	return statuses, nil
+}
+
+var logger = logging.For(logging.ComponentClient)
@@ -0,0 +1,40 @@
+package nightscout
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// maxProfiles bounds how many profile documents are read; each profile
+// change in Nightscout creates a new one
+const maxProfiles = 1000
+
+// GetProfiles retrieves the profile documents, oldest first
+func (c *Client) GetProfiles() ([]models.ProfileStore, error) {
+	params := url.Values{}
+	params.Set("count", fmt.Sprintf("%d", maxProfiles))
+
+	req, err := c.buildRequest("GET", "/api/v1/profile", params)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []models.ProfileStore
+	if err := json.Unmarshal(body, &profiles); err != nil {
+		return nil, fmt.Errorf("parsing profiles: %w", err)
+	}
+
+	sort.SliceStable(profiles, func(i, j int) bool {
+		return profiles[i].Start().Before(profiles[j].Start())
+	})
+	return profiles, nil
+}
@@ -0,0 +1,117 @@
+package nightscout
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// uploadBatchSize bounds the records sent per request
+const uploadBatchSize = 500
+
+// UploadEntries posts glucose entries to Nightscout in batches.
+// It needs an API secret or a token with write access.
+func (c *Client) UploadEntries(entries []models.GlucoseEntry) error {
+	docs := make([]map[string]any, len(entries))
+	for i, e := range entries {
+		doc := map[string]any{
+			"type":       "sgv",
+			"sgv":        e.SGV,
+			"date":       e.Date,
+			"dateString": e.DateStr,
+		}
+		if e.Direction != "" {
+			doc["direction"] = e.Direction
+		}
+		if e.Device != "" {
+			doc["device"] = e.Device
+		}
+		docs[i] = doc
+	}
+	return c.postBatches("/api/v1/entries", docs)
+}
+
+// UploadTreatments posts treatments to Nightscout in batches. Fields that
+// are unset are left out, so the server doesn't record zero values.
+func (c *Client) UploadTreatments(treatments []models.Treatment) error {
+	docs := make([]map[string]any, len(treatments))
+	for i := range treatments {
+		docs[i] = treatmentDocument(&treatments[i])
+	}
+	return c.postBatches("/api/v1/treatments", docs)
+}
+
+func treatmentDocument(t *models.Treatment) map[string]any {
+	doc := map[string]any{
+		"eventType":  t.EventType,
+		"created_at": t.CreatedAt,
+	}
+	if t.ID != "" {
+		doc["_id"] = t.ID
+	}
+	if t.Date > 0 {
+		doc["date"] = t.Date
+	}
+	for key, v := range map[string]float64{
+		"insulin":  t.Insulin,
+		"carbs":    t.Carbs,
+		"protein":  t.Protein,
+		"fat":      t.Fat,
+		"duration": t.Duration,
+		"glucose":  t.Glucose,
+		"absolute": t.Absolute,
+		"percent":  t.Percent,
+	} {
+		if v != 0 {
+			doc[key] = v
+		}
+	}
+	for key, v := range map[string]string{
+		"glucoseType": t.GlucoseType,
+		"units":       t.Units,
+		"notes":       t.Notes,
+		"enteredBy":   t.EnteredBy,
+		"device":      t.Device,
+	} {
+		if v != "" {
+			doc[key] = v
+		}
+	}
+	return doc
+}
+
+func (c *Client) postBatches(endpoint string, docs []map[string]any) error {
+	for start := 0; start < len(docs); start += uploadBatchSize {
+		end := min(start+uploadBatchSize, len(docs))
+		if err := c.postJSON(endpoint, docs[start:end]); err != nil {
+			return fmt.Errorf("uploading records %d-%d of %d: %w", start+1, end, len(docs), err)
+		}
+		logger.Debug("uploaded batch", "endpoint", endpoint, "count", end-start)
+	}
+	return nil
+}
+
+// postJSON sends v as the JSON body of a POST request
+func (c *Client) postJSON(endpoint string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := c.buildRequest(http.MethodPost, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	_, err = c.doRequest(req)
+	return err
+}
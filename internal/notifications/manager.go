@@ -3,6 +3,7 @@ package notifications
 
 import (
 	"fmt"
+	"math"
 	"sync"
 	"time"
 
@@ -18,10 +19,17 @@ const (
 	alertHigh       = "high"
 )
 
+// AlertHandler is called whenever an alert fires, with the alert type and triggering status
+type AlertHandler func(alertType string, status *models.GlucoseStatus)
+
 // Manager handles glucose alerts and notifications
 type Manager struct {
 	settings      *models.Settings
 	lastAlertTime map[string]time.Time
+	alertHandler  AlertHandler
+	snoozedUntil  time.Time
+	urgentOnly    bool
+	deviceAlerts  map[string]string // Device to the level and change last notified
 	mu            sync.Mutex
 }
 
@@ -30,6 +38,7 @@ func NewManager(settings *models.Settings) *Manager {
 	return &Manager{
 		settings:      settings,
 		lastAlertTime: make(map[string]time.Time),
+		deviceAlerts:  make(map[string]string),
 	}
 }
 
@@ -40,16 +49,49 @@ func (m *Manager) UpdateSettings(settings *models.Settings) {
 	m.settings = settings
 }
 
+// SetAlertHandler registers a callback invoked for every fired alert.
+// The handler must not block; it is called with the manager's lock held.
+func (m *Manager) SetAlertHandler(handler AlertHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.alertHandler = handler
+}
+
+// SetUrgentOnly limits alerts to urgent low and urgent high, e.g. to save power
+func (m *Manager) SetUrgentOnly(urgentOnly bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.urgentOnly = urgentOnly
+}
+
 // CheckAndNotify checks glucose value and sends notification if needed
 func (m *Manager) CheckAndNotify(status *models.GlucoseStatus) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	// Never alert on old data; it may no longer reflect the current glucose
+	if status.IsStale {
+		return nil
+	}
+
 	alertType := m.shouldAlert(status)
 	if alertType == "" {
 		return nil
 	}
 
+	// Readings of a warming-up sensor are unreliable; only urgent ones alert
+	if status.SensorWarmUp && alertType != alertUrgentLow && alertType != alertUrgentHigh {
+		return nil
+	}
+
+	if time.Now().Before(m.snoozedUntil) {
+		return nil
+	}
+
+	if m.urgentOnly && alertType != alertUrgentLow && alertType != alertUrgentHigh {
+		return nil
+	}
+
 	// Check if we should repeat the alert
 	if lastTime, ok := m.lastAlertTime[alertType]; ok {
 		if m.settings.RepeatAlertMinutes > 0 {
@@ -63,15 +105,16 @@ func (m *Manager) CheckAndNotify(status *models.GlucoseStatus) error {
 		}
 	}
 
-	// Send notification
-	title, message := m.formatNotification(status, alertType)
-	err := m.sendNotification(title, message)
-	if err != nil {
-		return err
+	// The alert counts as fired even if the desktop notification fails, e.g.
+	// on a headless host, so webhooks still honour the repeat interval
+	m.lastAlertTime[alertType] = time.Now()
+
+	if m.alertHandler != nil {
+		m.alertHandler(alertType, status)
 	}
 
-	m.lastAlertTime[alertType] = time.Now()
-	return nil
+	title, message := m.formatNotification(status, alertType)
+	return m.sendNotification(title, message)
 }
 
 // shouldAlert determines if an alert should be sent
@@ -126,12 +169,104 @@ func (m *Manager) formatNotification(status *models.GlucoseStatus, alertType str
 	return title, message
 }
 
+// CheckDeviceAges notifies once when a device reaches its warning or urgent
+// age, and again after it is changed and ages past a threshold once more
+func (m *Manager) CheckDeviceAges(ages []models.DeviceAge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.settings.EnableDeviceAgeAlerts || time.Now().Before(m.snoozedUntil) {
+		return nil
+	}
+
+	for _, age := range ages {
+		if age.ChangedAt == nil || (age.Level != models.DeviceAgeWarn && age.Level != models.DeviceAgeUrgent) {
+			continue
+		}
+		if m.urgentOnly && age.Level != models.DeviceAgeUrgent {
+			continue
+		}
+
+		key := fmt.Sprintf("%s@%d", age.Level, age.ChangedAt.Unix())
+		if m.deviceAlerts[age.Device] == key {
+			continue
+		}
+
+		title, message := formatDeviceAge(age)
+		if err := m.sendNotification(title, message); err != nil {
+			return err
+		}
+		m.deviceAlerts[age.Device] = key
+	}
+	return nil
+}
+
+// formatDeviceAge creates the notification title and message for a device age
+func formatDeviceAge(age models.DeviceAge) (string, string) {
+	names := map[string]string{
+		models.DeviceSensor:  "Sensor",
+		models.DeviceSite:    "Infusion site",
+		models.DeviceInsulin: "Insulin",
+		models.DeviceBattery: "Pump battery",
+	}
+	name := names[age.Device]
+
+	var elapsed string
+	if age.AgeHours >= 48 {
+		elapsed = fmt.Sprintf("%.0f days %.0f hours", math.Floor(age.AgeHours/24), math.Mod(math.Floor(age.AgeHours), 24))
+	} else {
+		elapsed = fmt.Sprintf("%.0f hours", math.Floor(age.AgeHours))
+	}
+
+	if age.Level == models.DeviceAgeUrgent {
+		return fmt.Sprintf("⚠️ %s change overdue", name), fmt.Sprintf("%s (%s) is %s old", name, age.Label, elapsed)
+	}
+	return fmt.Sprintf("🔄 %s change due soon", name), fmt.Sprintf("%s (%s) is %s old", name, age.Label, elapsed)
+}
+
 // sendNotification sends a system notification
 func (m *Manager) sendNotification(title, message string) error {
 	// Use beeep for cross-platform notifications
 	return beeep.Notify(title, message, "")
 }
 
+// Snooze suppresses all alerts for the given duration. A zero duration cancels the snooze.
+func (m *Manager) Snooze(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if d <= 0 {
+		m.snoozedUntil = time.Time{}
+		return
+	}
+	m.snoozedUntil = time.Now().Add(d)
+}
+
+// SnoozedUntil returns the end of the current snooze, or the zero time if alerts are active
+func (m *Manager) SnoozedUntil() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if time.Now().After(m.snoozedUntil) {
+		return time.Time{}
+	}
+	return m.snoozedUntil
+}
+
+// ResyncClock strips monotonic clock readings from the alert state after the
+// system resumed from sleep. The monotonic clock does not advance while
+// suspended, so repeat intervals and snoozes would otherwise ignore the
+// time spent asleep and suppress alerts that are due.
+func (m *Manager) ResyncClock() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for alertType, t := range m.lastAlertTime {
+		m.lastAlertTime[alertType] = t.Round(0)
+	}
+	m.snoozedUntil = m.snoozedUntil.Round(0)
+}
+
 // ClearAlertState clears the alert state for a specific type or all types
 func (m *Manager) ClearAlertState(alertType string) {
 	m.mu.Lock()
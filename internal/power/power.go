@@ -0,0 +1,139 @@
+// Package power reports whether the machine runs on battery
+package power
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// DefaultSysfsRoot is where Linux exposes power supplies
+const DefaultSysfsRoot = "/sys/class/power_supply"
+
+// State describes the current power source
+type State struct {
+	HasBattery bool `json:"hasBattery"` // A system battery was found
+	OnBattery  bool `json:"onBattery"`  // Running from battery (no external power)
+	Percent    int  `json:"percent"`    // Remaining charge 0-100, or -1 if unknown
+}
+
+// Provider reports the current power state
+type Provider interface {
+	State() (State, error)
+}
+
+// NewProvider returns the provider for the current platform.
+// Platforms without support always report external power.
+func NewProvider() Provider {
+	if runtime.GOOS == "linux" {
+		return NewSysfsProvider(DefaultSysfsRoot)
+	}
+	return staticProvider{}
+}
+
+// staticProvider always reports external power
+type staticProvider struct{}
+
+func (staticProvider) State() (State, error) {
+	return State{Percent: -1}, nil
+}
+
+// SysfsProvider reads power supplies from a sysfs-style directory tree.
+// The root is configurable so tests can point it at fake files.
+type SysfsProvider struct {
+	Root string
+}
+
+// NewSysfsProvider creates a provider reading from root
+func NewSysfsProvider(root string) *SysfsProvider {
+	return &SysfsProvider{Root: root}
+}
+
+// State reads all power supplies under Root
+func (p *SysfsProvider) State() (State, error) {
+	state := State{Percent: -1}
+
+	dirs, err := os.ReadDir(p.Root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return state, err
+	}
+
+	mainsFound, mainsOnline := false, false
+	discharging := false
+	var totalPercent, batteries int
+
+	for _, d := range dirs {
+		dir := filepath.Join(p.Root, d.Name())
+		switch readString(dir, "type") {
+		case "Mains", "USB", "USB_C", "USB_PD":
+			mainsFound = true
+			if readString(dir, "online") == "1" {
+				mainsOnline = true
+			}
+		case "Battery":
+			// Peripheral batteries (mice, headsets) report scope "Device"
+			if readString(dir, "scope") == "Device" {
+				continue
+			}
+			if present := readString(dir, "present"); present == "0" {
+				continue
+			}
+
+			state.HasBattery = true
+			if readString(dir, "status") == "Discharging" {
+				discharging = true
+			}
+			if pct, ok := batteryPercent(dir); ok {
+				totalPercent += pct
+				batteries++
+			}
+		}
+	}
+
+	if batteries > 0 {
+		state.Percent = totalPercent / batteries
+	}
+	// Without any external supply described, only the battery status tells
+	// whether we are running from it
+	state.OnBattery = state.HasBattery && !mainsOnline && (discharging || mainsFound)
+
+	return state, nil
+}
+
+// batteryPercent reads capacity, falling back to energy or charge counters
+func batteryPercent(dir string) (int, bool) {
+	if v, err := strconv.Atoi(readString(dir, "capacity")); err == nil {
+		return clampPercent(v), true
+	}
+	for _, prefix := range []string{"energy", "charge"} {
+		now, err1 := strconv.ParseFloat(readString(dir, prefix+"_now"), 64)
+		full, err2 := strconv.ParseFloat(readString(dir, prefix+"_full"), 64)
+		if err1 == nil && err2 == nil && full > 0 {
+			return clampPercent(int(now / full * 100)), true
+		}
+	}
+	return 0, false
+}
+
+func clampPercent(v int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > 100 {
+		return 100
+	}
+	return v
+}
+
+func readString(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name)) //nolint:gosec // Reading sysfs attributes
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -238,6 +238,15 @@ func (a *Analyzer) calculateDailyAverages(treatments []models.Treatment, params
 	}
 }
 
+// DailyAverages returns the average daily insulin and carbs in treatments,
+// computed the same way as during parameter calculation. Only the insulin
+// and carb fields of the result are set.
+func (a *Analyzer) DailyAverages(treatments []models.Treatment) *models.DiabetesParameters {
+	params := &models.DiabetesParameters{}
+	a.calculateDailyAverages(treatments, params)
+	return params
+}
+
 // calculateISF calculates Insulin Sensitivity Factor
 func (a *Analyzer) calculateISF(entries []models.GlucoseEntry, treatments []models.Treatment, params *models.DiabetesParameters) {
 	// Find correction boluses (insulin without carbs) and measure BG drop
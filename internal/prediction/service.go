@@ -6,16 +6,29 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/mrcode/nightscout-tray/internal/logging"
 	"github.com/mrcode/nightscout-tray/internal/models"
 	"github.com/mrcode/nightscout-tray/internal/nightscout"
 )
 
+// HistorySource supplies historical data for parameter calculation, e.g.
+// from the local history store so analysis works offline
+type HistorySource interface {
+	Entries(from, to time.Time) ([]models.GlucoseEntry, error)
+	Treatments(from, to time.Time) ([]models.Treatment, error)
+}
+
+// OnBoardWindow is how long insulin and carbs are assumed to act at most
+const OnBoardWindow = 12 * time.Hour
+
 // Service provides prediction functionality to the application
 type Service struct {
 	client      *nightscout.Client
+	history     HistorySource
 	analyzer    *Analyzer
 	predictor   *Predictor
 	mlPredictor *MLPredictor
@@ -49,7 +62,7 @@ func NewService(client *nightscout.Client) *Service {
 
 	// Try to load saved parameters
 	if err := s.loadParams(); err != nil {
-		fmt.Printf("Could not load saved parameters: %v\n", err)
+		logger.Info("no saved parameters loaded", "error", err)
 	} else {
 		s.predictor.SetParameters(s.params)
 		s.mlPredictor.SetParameters(s.params)
@@ -66,6 +79,14 @@ func (s *Service) SetClient(client *nightscout.Client) {
 	s.client = client
 }
 
+// SetHistorySource sets where parameter calculation reads its data from.
+// Without one, data is fetched from Nightscout directly.
+func (s *Service) SetHistorySource(source HistorySource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history = source
+}
+
 // GetParameters returns the current diabetes parameters
 func (s *Service) GetParameters() *models.DiabetesParameters {
 	s.mu.RLock()
@@ -122,29 +143,42 @@ func (s *Service) runCalculation(days int, mode string) {
 
 	s.mu.RLock()
 	client := s.client
+	source := s.history
 	s.mu.RUnlock()
 
-	if client == nil {
+	if client == nil && source == nil {
 		return
 	}
 
 	// Fetch entries
-	entries, err := client.GetEntriesDays(days)
+	var entries []models.GlucoseEntry
+	var err error
+	from := time.Now().AddDate(0, 0, -days)
+	if source != nil {
+		entries, err = source.Entries(from, time.Now())
+	} else {
+		entries, err = client.GetEntriesDays(days)
+	}
 	if err != nil {
-		fmt.Printf("Error fetching entries: %v\n", err)
+		logger.Error("fetching entries failed", "error", err)
 		return
 	}
 	
-	fmt.Printf("Fetched %d glucose entries for %d days\n", len(entries), days)
+	logger.Info("fetched glucose entries", "count", len(entries), "days", days)
 
 	// Fetch treatments
-	treatments, err := client.GetTreatmentsDays(days)
+	var treatments []models.Treatment
+	if source != nil {
+		treatments, err = source.Treatments(from, time.Now())
+	} else {
+		treatments, err = client.GetTreatmentsDays(days)
+	}
 	if err != nil {
-		fmt.Printf("Error fetching treatments: %v\n", err)
+		logger.Error("fetching treatments failed", "error", err)
 		return
 	}
 	
-	fmt.Printf("Fetched %d treatments for %d days\n", len(treatments), days)
+	logger.Info("fetched treatments", "count", len(treatments), "days", days)
 
 	// Run analysis based on mode
 	var params *models.DiabetesParameters
@@ -154,15 +188,14 @@ func (s *Service) runCalculation(days int, mode string) {
 		
 		// Train the new oref engine with historical patterns
 		if err == nil {
-			fmt.Println("Training oref prediction engine with historical patterns...")
+			logger.Info("training oref prediction engine with historical patterns")
 			s.orefEngine.LearnFromHistory(entries, treatments)
 			
 			mealPatterns, correctionPatterns := s.orefEngine.GetPatternStats()
-			fmt.Printf("Oref engine learned %d meal patterns, %d correction patterns\n", 
-				mealPatterns, correctionPatterns)
+			logger.Info("oref engine trained", "mealPatterns", mealPatterns, "correctionPatterns", correctionPatterns)
 			
 			autosens := s.orefEngine.GetAutosensRatio()
-			fmt.Printf("Current Autosens ratio: %.2f\n", autosens)
+			logger.Info("autosens ratio", "ratio", autosens)
 			
 			// Also update time-of-day parameters from learned circadian profile
 			profile := s.orefEngine.GetCircadianProfile()
@@ -170,7 +203,7 @@ func (s *Service) runCalculation(days int, mode string) {
 			
 			// Train legacy ML predictor as well for comparison
 			s.mlPredictor.LearnFromHistory(entries, treatments)
-			fmt.Printf("ML predictor learned %d patterns\n", len(s.mlPredictor.patterns.patterns))
+			logger.Info("ML predictor trained", "patterns", len(s.mlPredictor.patterns.patterns))
 		}
 		
 		s.mu.Lock()
@@ -186,7 +219,7 @@ func (s *Service) runCalculation(days int, mode string) {
 	}
 	
 	if err != nil {
-		fmt.Printf("Error analyzing data: %v\n", err)
+		logger.Error("analyzing data failed", "error", err)
 		return
 	}
 
@@ -200,7 +233,7 @@ func (s *Service) runCalculation(days int, mode string) {
 
 	// Save parameters
 	if err := s.saveParams(); err != nil {
-		fmt.Printf("Error saving parameters: %v\n", err)
+		logger.Error("saving parameters failed", "error", err)
 	}
 }
 
@@ -245,11 +278,11 @@ func (s *Service) updateTimeOfDayParams(params *models.DiabetesParameters, profi
 	params.ICRByTimeOfDay[string(models.Evening)] = params.ICR * eveningICR
 	params.ICRByTimeOfDay[string(models.Night)] = params.ICR * nightICR
 	
-	fmt.Printf("Time-of-day ISF: Morning=%.1f, Midday=%.1f, Evening=%.1f, Night=%.1f\n",
-		params.ISFByTimeOfDay[string(models.Morning)], 
-		params.ISFByTimeOfDay[string(models.Midday)],
-		params.ISFByTimeOfDay[string(models.Evening)],
-		params.ISFByTimeOfDay[string(models.Night)])
+	logger.Info("time-of-day ISF",
+		"morning", params.ISFByTimeOfDay[string(models.Morning)],
+		"midday", params.ISFByTimeOfDay[string(models.Midday)],
+		"evening", params.ISFByTimeOfDay[string(models.Evening)],
+		"night", params.ISFByTimeOfDay[string(models.Night)])
 }
 
 // GetPrediction generates a new prediction based on current data
@@ -359,6 +392,33 @@ func (s *Service) GetIOBCOB() (iob, cob float64, err error) {
 	return prediction.IOB, prediction.COB, nil
 }
 
+// OnBoard returns insulin and carbs on board at each time, using the same
+// model as the predictions. treatments must be sorted by time and reach
+// back OnBoardWindow before the first time.
+func (s *Service) OnBoard(treatments []models.Treatment, times []time.Time) (iob, cob []float64) {
+	s.mu.RLock()
+	useML := s.useMLPrediction
+	s.mu.RUnlock()
+
+	iob = make([]float64, len(times))
+	cob = make([]float64, len(times))
+	for i, t := range times {
+		// Only treatments that can still be active matter
+		lo := sort.Search(len(treatments), func(j int) bool { return !treatments[j].Time().Before(t.Add(-OnBoardWindow)) })
+		hi := sort.Search(len(treatments), func(j int) bool { return treatments[j].Time().After(t) })
+		active := treatments[lo:hi]
+
+		if useML {
+			iob[i] = s.orefEngine.calculateIOB(active, t)
+			cob[i] = s.orefEngine.calculateCOB(active, t)
+		} else {
+			iob[i] = s.predictor.calculateIOB(active, t)
+			cob[i] = s.predictor.calculateCOB(active, t)
+		}
+	}
+	return iob, cob
+}
+
 func (s *Service) getRecentData() ([]models.GlucoseEntry, []models.Treatment, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -393,18 +453,41 @@ func (s *Service) getRecentData() ([]models.GlucoseEntry, []models.Treatment, er
 }
 
 func (s *Service) getParamsPath() (string, error) {
-	configDir, err := os.UserConfigDir()
+	configDir, err := models.GetConfigDir()
 	if err != nil {
 		return "", err
 	}
 
-	appDir := filepath.Join(configDir, "nightscout-tray")
-	//nolint:gosec // 0755 is standard permission for application directories
-	if err := os.MkdirAll(appDir, 0755); err != nil {
-		return "", err
+	path := filepath.Join(configDir, models.PredictionParamsFileName)
+	migrateLegacyParams(path)
+	return path, nil
+}
+
+// migrateLegacyParams moves parameters saved by older versions, which used
+// os.UserConfigDir and could end up outside the config directory (e.g. when
+// APPDATA was unset on Windows)
+func migrateLegacyParams(path string) {
+	userDir, err := os.UserConfigDir()
+	if err != nil {
+		return
 	}
 
-	return filepath.Join(appDir, "prediction-params.json"), nil
+	legacy := filepath.Join(userDir, "nightscout-tray", models.PredictionParamsFileName)
+	if legacy == path {
+		return
+	}
+	if _, err := os.Stat(path); err == nil {
+		return
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		return
+	}
+
+	if err := os.Rename(legacy, path); err != nil {
+		logger.Warn("moving prediction parameters failed", "from", legacy, "error", err)
+		return
+	}
+	logger.Info("moved prediction parameters to the config directory", "from", legacy)
 }
 
 func (s *Service) saveParams() error {
@@ -448,6 +531,36 @@ func (s *Service) loadParams() error {
 	return nil
 }
 
+// ReloadParameters reads the saved parameters again, e.g. after a backup was
+// restored, and applies them to the predictors
+func (s *Service) ReloadParameters() error {
+	if err := s.loadParams(); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	params := s.params
+	s.mu.RUnlock()
+
+	s.predictor.SetParameters(params)
+	s.mlPredictor.SetParameters(params)
+	s.orefEngine.SetParameters(params)
+	s.InvalidateCache()
+	return nil
+}
+
+// InvalidateCache discards cached entries and treatments and the last prediction
+// so the next request fetches fresh data
+func (s *Service) InvalidateCache() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cacheTime = time.Time{}
+	s.cachedEntries = nil
+	s.cachedTreatments = nil
+	s.lastPrediction = nil
+}
+
 // RefreshCache forces a cache refresh
 func (s *Service) RefreshCache() error {
 	s.mu.Lock()
@@ -519,3 +632,5 @@ type ChartPredictionPoint struct {
 	Value      float64 `json:"value"`
 	Confidence float64 `json:"confidence"`
 }
+
+var logger = logging.For(logging.ComponentPrediction)
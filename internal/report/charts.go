@@ -0,0 +1,200 @@
+package report
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// point is a position on a canvas, in points from the top left corner
+type point struct {
+	X, Y float64
+}
+
+// Text alignment
+const (
+	alignLeft = iota
+	alignCenter
+	alignRight
+)
+
+// textStyle describes how text is drawn
+type textStyle struct {
+	Size  float64
+	Bold  bool
+	Color string
+	Align int
+}
+
+// canvas is the drawing surface charts are rendered on. It is implemented
+// for SVG (HTML reports) and PDF pages, so both show identical charts.
+type canvas interface {
+	Rect(x, y, w, h float64, fill string)
+	Line(x1, y1, x2, y2 float64, stroke string, width float64)
+	Polyline(points []point, stroke string, width float64)
+	Polygon(points []point, fill string)
+	Text(x, y float64, s string, style textStyle)
+}
+
+// maxDayGap breaks a daily curve where readings are missing
+const maxDayGap = 30 * time.Minute
+
+// chartArea maps glucose values and minutes of the day onto a plot area
+type chartArea struct {
+	x, y, w, h float64
+	maxValue   float64 // Top of the value axis, in the report unit
+}
+
+func (a chartArea) px(minute float64) float64 {
+	return a.x + minute/(24*60)*a.w
+}
+
+func (a chartArea) py(value float64) float64 {
+	return a.y + a.h - math.Min(value, a.maxValue)/a.maxValue*a.h
+}
+
+// newChartArea reserves room for axis labels inside the given box
+func newChartArea(d *Data, x, y, w, h float64) chartArea {
+	maxValue := 350.0
+	if d.useMmol() {
+		maxValue = 20
+	}
+	return chartArea{x: x + 28, y: y + 6, w: w - 44, h: h - 22, maxValue: maxValue}
+}
+
+// drawAxes draws the target band, grid and axis labels of a time-of-day chart
+func drawAxes(c canvas, d *Data, a chartArea) {
+	low, high := float64(d.AGP.TargetLow), float64(d.AGP.TargetHigh)
+	if d.useMmol() {
+		low, high = models.ToMmol(low), models.ToMmol(high)
+	}
+	c.Rect(a.x, a.py(high), a.w, a.py(low)-a.py(high), colorTarget)
+
+	step := 50.0
+	if d.useMmol() {
+		step = 4
+	}
+	label := textStyle{Size: 7, Color: colorMuted, Align: alignRight}
+	for v := 0.0; v <= a.maxValue+0.001; v += step {
+		c.Line(a.x, a.py(v), a.x+a.w, a.py(v), colorGrid, 0.5)
+		c.Text(a.x-4, a.py(v)+2.5, fmt.Sprintf("%.0f", v), label)
+	}
+
+	label.Align = alignCenter
+	for hour := 0; hour <= 24; hour += 3 {
+		x := a.px(float64(hour * 60))
+		c.Line(x, a.y, x, a.y+a.h, colorGrid, 0.5)
+		c.Text(x, a.y+a.h+11, fmt.Sprintf("%02d:00", hour%24), label)
+	}
+
+	c.Line(a.x, a.py(low), a.x+a.w, a.py(low), colorTargetRim, 0.8)
+	c.Line(a.x, a.py(high), a.x+a.w, a.py(high), colorTargetRim, 0.8)
+}
+
+// drawAGP draws the percentile bands and median of the glucose profile
+func drawAGP(c canvas, d *Data, x, y, w, h float64) {
+	a := newChartArea(d, x, y, w, h)
+	drawAxes(c, d, a)
+
+	for _, segment := range agpSegments(d.AGP) {
+		band := func(lower, upper func(models.AGPBin) float64) []point {
+			pts := make([]point, 0, 2*len(segment))
+			for _, b := range segment {
+				pts = append(pts, point{a.px(binCenter(d.AGP, b)), a.py(upper(b))})
+			}
+			for i := len(segment) - 1; i >= 0; i-- {
+				b := segment[i]
+				pts = append(pts, point{a.px(binCenter(d.AGP, b)), a.py(lower(b))})
+			}
+			return pts
+		}
+
+		c.Polygon(band(func(b models.AGPBin) float64 { return b.P5 }, func(b models.AGPBin) float64 { return b.P95 }), colorOuterBand)
+		c.Polygon(band(func(b models.AGPBin) float64 { return b.P25 }, func(b models.AGPBin) float64 { return b.P75 }), colorInnerBand)
+
+		median := make([]point, len(segment))
+		for i, b := range segment {
+			median[i] = point{a.px(binCenter(d.AGP, b)), a.py(b.P50)}
+		}
+		c.Polyline(median, colorMedian, 1.6)
+	}
+}
+
+// drawOverlay draws every day's readings on one time-of-day axis
+func drawOverlay(c canvas, d *Data, x, y, w, h float64) {
+	a := newChartArea(d, x, y, w, h)
+	drawAxes(c, d, a)
+
+	for _, day := range d.AGP.Daily {
+		var line []point
+		var last int64
+		for _, e := range day.Entries {
+			t := time.UnixMilli(e.Time).Local()
+			if len(line) > 0 && time.Duration(e.Time-last)*time.Millisecond > maxDayGap {
+				c.Polyline(line, colorDay, 0.6)
+				line = nil
+			}
+			minute := float64(t.Hour()*60+t.Minute()) + float64(t.Second())/60
+			line = append(line, point{a.px(minute), a.py(e.Value)})
+			last = e.Time
+		}
+		if len(line) > 1 {
+			c.Polyline(line, colorDay, 0.6)
+		}
+	}
+
+	// The median keeps the typical day readable among the daily lines
+	for _, segment := range agpSegments(d.AGP) {
+		median := make([]point, len(segment))
+		for i, b := range segment {
+			median[i] = point{a.px(binCenter(d.AGP, b)), a.py(b.P50)}
+		}
+		c.Polyline(median, colorMedian, 1.6)
+	}
+}
+
+// drawRangeBar draws a stacked bar of time in ranges with a legend beside it
+func drawRangeBar(c canvas, rows []rangeRow, x, y, w, h float64) {
+	barWidth := 36.0
+	top := y
+	for _, r := range rows {
+		segment := r.Percent / 100 * h
+		if segment > 0 {
+			c.Rect(x, top, barWidth, segment, r.Color)
+		}
+		top += segment
+	}
+
+	// Legend entries are spread evenly, top to bottom like the bar
+	step := h / float64(len(rows))
+	for i, r := range rows {
+		ly := y + step*float64(i) + step/2
+		c.Rect(x+barWidth+10, ly-5, 8, 8, r.Color)
+		c.Text(x+barWidth+24, ly+2, r.Label, textStyle{Size: 8.5, Color: colorText})
+		c.Text(x+w, ly+2, fmt.Sprintf("%.1f%%", r.Percent), textStyle{Size: 8.5, Bold: true, Color: colorText, Align: alignRight})
+	}
+}
+
+// agpSegments splits the profile where bins are missing so bands aren't
+// drawn across gaps
+func agpSegments(agp *models.AGPData) [][]models.AGPBin {
+	var segments [][]models.AGPBin
+	var current []models.AGPBin
+	for i, b := range agp.Bins {
+		if i > 0 && b.Minute-agp.Bins[i-1].Minute > agp.BinMinutes {
+			segments = append(segments, current)
+			current = nil
+		}
+		current = append(current, b)
+	}
+	if len(current) > 0 {
+		segments = append(segments, current)
+	}
+	return segments
+}
+
+func binCenter(agp *models.AGPData, b models.AGPBin) float64 {
+	return float64(b.Minute) + float64(agp.BinMinutes)/2
+}
@@ -0,0 +1,171 @@
+package report
+
+import (
+	"html/template"
+	"io"
+)
+
+// Chart sizes in the HTML report, in SVG user units
+const (
+	htmlChartWidth  = 720
+	htmlChartHeight = 240
+	htmlRangeWidth  = 300
+	htmlRangeHeight = 150
+)
+
+// htmlView is the data passed to the HTML template
+type htmlView struct {
+	Title         string
+	Period        string
+	Generated     string
+	Unit          string
+	Summary       []row
+	RangeChart    template.HTML
+	AGPChart      template.HTML
+	OverlayChart  template.HTML
+	HasProfile    bool
+	Totals        []row
+	Parameters    []row
+	EpisodeCounts [][4]string
+	Episodes      []episodeRow
+	EpisodeNote   string
+}
+
+// WriteHTML renders the report as a single HTML page with inline styles
+// and charts, so it can be opened and printed offline
+func WriteHTML(w io.Writer, d *Data) error {
+	view := htmlView{
+		Title:         d.Title,
+		Period:        d.period(),
+		Generated:     d.GeneratedAt.Local().Format("2 Jan 2006 15:04"),
+		Unit:          d.Unit,
+		Summary:       d.summaryRows(),
+		Totals:        d.totalsRows(),
+		Parameters:    d.parameterRows(),
+		EpisodeCounts: d.episodeCountRows(),
+		Episodes:      d.episodeRows(),
+		EpisodeNote:   d.episodeTableNote(),
+	}
+
+	if ranges := d.rangeRows(); len(ranges) > 0 {
+		svg := newSVG(htmlRangeWidth, htmlRangeHeight)
+		drawRangeBar(svg, ranges, 0, 0, htmlRangeWidth, htmlRangeHeight)
+		view.RangeChart = svg.HTML()
+	}
+
+	if d.AGP != nil && len(d.AGP.Bins) > 0 {
+		view.HasProfile = true
+
+		svg := newSVG(htmlChartWidth, htmlChartHeight)
+		drawAGP(svg, d, 0, 0, htmlChartWidth, htmlChartHeight)
+		view.AGPChart = svg.HTML()
+
+		svg = newSVG(htmlChartWidth, htmlChartHeight)
+		drawOverlay(svg, d, 0, 0, htmlChartWidth, htmlChartHeight)
+		view.OverlayChart = svg.HTML()
+	}
+
+	return htmlTemplate.Execute(w, view)
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+  @page { size: A4; margin: 14mm; }
+  body { font-family: Helvetica, Arial, sans-serif; color: #1f2937; max-width: 760px; margin: 24px auto; font-size: 13px; }
+  h1 { font-size: 22px; margin: 0 0 4px; }
+  h2 { font-size: 15px; margin: 28px 0 8px; padding-bottom: 4px; border-bottom: 2px solid #0b5394; }
+  .meta { color: #6b7280; }
+  .columns { display: flex; gap: 32px; align-items: flex-start; }
+  .columns > div { flex: 1; }
+  table { border-collapse: collapse; width: 100%; }
+  td, th { padding: 3px 6px; text-align: left; border-bottom: 1px solid #e5e7eb; }
+  th { font-size: 11px; text-transform: uppercase; color: #6b7280; }
+  td.value, th.value { text-align: right; }
+  .legend { color: #6b7280; font-size: 11px; margin-top: 4px; }
+  .swatch { display: inline-block; width: 10px; height: 10px; margin: 0 4px 0 12px; vertical-align: middle; }
+  .page-break { break-before: page; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="meta">{{.Period}} · generated {{.Generated}} · values in {{.Unit}}</div>
+
+<h2>Summary</h2>
+<div class="columns">
+  <div>
+    <table>
+    {{- range .Summary}}
+      <tr><td>{{.Label}}</td><td class="value">{{.Value}}</td></tr>
+    {{- end}}
+    </table>
+  </div>
+  {{- if .RangeChart}}
+  <div>{{.RangeChart}}</div>
+  {{- end}}
+</div>
+
+{{- if .HasProfile}}
+<h2>Ambulatory glucose profile</h2>
+{{.AGPChart}}
+<div class="legend">
+  <span class="swatch" style="background:#0b5394"></span>Median
+  <span class="swatch" style="background:#6fa8dc"></span>25–75%
+  <span class="swatch" style="background:#cfe2f3"></span>5–95%
+  <span class="swatch" style="background:#ecfdf5;border:1px solid #16a34a"></span>Target range
+</div>
+
+<h2>Daily overlay</h2>
+{{.OverlayChart}}
+<div class="legend">Each line is one day; the dark line is the median.</div>
+{{- end}}
+
+<div class="columns page-break">
+  {{- if .Totals}}
+  <div>
+    <h2>Daily insulin and carbs</h2>
+    <table>
+    {{- range .Totals}}
+      <tr><td>{{.Label}}</td><td class="value">{{.Value}}</td></tr>
+    {{- end}}
+    </table>
+  </div>
+  {{- end}}
+  {{- if .Parameters}}
+  <div>
+    <h2>Learned parameters</h2>
+    <table>
+    {{- range .Parameters}}
+      <tr><td>{{.Label}}</td><td class="value">{{.Value}}</td></tr>
+    {{- end}}
+    </table>
+  </div>
+  {{- end}}
+</div>
+
+{{- if .EpisodeCounts}}
+<h2>Episodes</h2>
+<table>
+  <tr><th></th><th class="value">Day</th><th class="value">Night</th><th class="value">Total</th></tr>
+  {{- range .EpisodeCounts}}
+  <tr><td>{{index . 0}}</td><td class="value">{{index . 1}}</td><td class="value">{{index . 2}}</td><td class="value">{{index . 3}}</td></tr>
+  {{- end}}
+</table>
+<div class="legend">Night is 00:00–06:00.</div>
+
+{{- if .Episodes}}
+<table style="margin-top:12px">
+  <tr><th>Start</th><th>Type</th><th class="value">Duration</th><th class="value">Nadir/peak</th><th class="value">Rate before</th><th>Treated with</th><th class="value">Recovery</th></tr>
+  {{- range .Episodes}}
+  <tr><td>{{.Start}}</td><td>{{.Kind}}</td><td class="value">{{.Duration}}</td><td class="value">{{.Extreme}}</td><td class="value">{{.Rate}}</td><td>{{.Treatments}}</td><td class="value">{{.Recovery}}</td></tr>
+  {{- end}}
+</table>
+{{- if .EpisodeNote}}<div class="legend">{{.EpisodeNote}}</div>{{end}}
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
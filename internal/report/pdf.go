@@ -0,0 +1,220 @@
+package report
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A4 in points, with the page margin
+const (
+	pageWidth  = 595.0
+	pageHeight = 842.0
+	pageMargin = 42.0
+)
+
+// pdfDocument is a minimal PDF writer: vector graphics and text in the
+// standard Helvetica fonts, which every reader provides, so nothing needs
+// to be embedded
+type pdfDocument struct {
+	title string
+	pages []*pdfPage
+}
+
+// pdfPage collects the content stream of one page. Coordinates are taken
+// from the top left like SVG and flipped when written.
+type pdfPage struct {
+	content bytes.Buffer
+}
+
+func (d *pdfDocument) newPage() *pdfPage {
+	p := &pdfPage{}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+func (p *pdfPage) Rect(x, y, w, h float64, fill string) {
+	fmt.Fprintf(&p.content, "%s rg %.2f %.2f %.2f %.2f re f\n", pdfColor(fill), x, pageHeight-y-h, w, h)
+}
+
+func (p *pdfPage) Line(x1, y1, x2, y2 float64, stroke string, width float64) {
+	fmt.Fprintf(&p.content, "%s RG %.2f w %.2f %.2f m %.2f %.2f l S\n",
+		pdfColor(stroke), width, x1, pageHeight-y1, x2, pageHeight-y2)
+}
+
+func (p *pdfPage) Polyline(points []point, stroke string, width float64) {
+	if len(points) < 2 {
+		return
+	}
+	fmt.Fprintf(&p.content, "%s RG %.2f w 1 j ", pdfColor(stroke), width)
+	p.path(points)
+	p.content.WriteString("S\n")
+}
+
+func (p *pdfPage) Polygon(points []point, fill string) {
+	if len(points) < 3 {
+		return
+	}
+	fmt.Fprintf(&p.content, "%s rg ", pdfColor(fill))
+	p.path(points)
+	p.content.WriteString("h f\n")
+}
+
+func (p *pdfPage) path(points []point) {
+	for i, pt := range points {
+		op := "l"
+		if i == 0 {
+			op = "m"
+		}
+		fmt.Fprintf(&p.content, "%.2f %.2f %s ", pt.X, pageHeight-pt.Y, op)
+	}
+}
+
+func (p *pdfPage) Text(x, y float64, s string, style textStyle) {
+	switch style.Align {
+	case alignCenter:
+		x -= textWidth(s, style.Size) / 2
+	case alignRight:
+		x -= textWidth(s, style.Size)
+	}
+	font := "F1"
+	if style.Bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&p.content, "BT %s rg /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n",
+		pdfColor(style.Color), font, style.Size, x, pageHeight-y, pdfString(s))
+}
+
+// write serializes the document
+func (d *pdfDocument) write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var offsets []int
+	written := 0
+
+	emit := func(format string, args ...any) {
+		n, _ := fmt.Fprintf(bw, format, args...)
+		written += n
+	}
+	object := func(body string) int {
+		offsets = append(offsets, written)
+		id := len(offsets)
+		emit("%d 0 obj\n%s\nendobj\n", id, body)
+		return id
+	}
+
+	emit("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1-4 are fixed; pages follow as page/content pairs
+	pageCount := len(d.pages)
+	kids := make([]string, pageCount)
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), pageCount))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, p := range d.pages {
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		if _, err := zw.Write(p.content.Bytes()); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", compressed.Len(), compressed.String()))
+	}
+
+	info := object(fmt.Sprintf("<< /Title (%s) /Producer (nightscout-tray) /CreationDate (D:%s) >>",
+		pdfString(d.title), time.Now().UTC().Format("20060102150405Z")))
+
+	xref := written
+	emit("xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		emit("%010d 00000 n \n", off)
+	}
+	emit("trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, info, xref)
+
+	return bw.Flush()
+}
+
+// pdfColor converts "#rrggbb" into PDF color operands
+func pdfColor(hex string) string {
+	hex = strings.TrimPrefix(hex, "#")
+	if len(hex) != 6 {
+		return "0 0 0"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return "0 0 0"
+	}
+	return fmt.Sprintf("%.3f %.3f %.3f", float64(v>>16&0xff)/255, float64(v>>8&0xff)/255, float64(v&0xff)/255)
+}
+
+// winAnsi maps the non-Latin-1 characters we use to WinAnsiEncoding
+var winAnsi = map[rune]byte{
+	'€': 0x80, '…': 0x85, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94,
+	'•': 0x95, '–': 0x96, '—': 0x97,
+}
+
+// pdfString encodes s for a PDF literal string in WinAnsiEncoding
+func pdfString(s string) string {
+	s = strings.NewReplacer("≥", ">=", "≤", "<=", "→", "->").Replace(s)
+
+	var b strings.Builder
+	for _, r := range s {
+		var c byte
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			c = byte(r)
+		case r >= 0x20 && r < 0x7f, r >= 0xa0 && r <= 0xff:
+			c = byte(r)
+		default:
+			var ok bool
+			if c, ok = winAnsi[r]; !ok {
+				c = '?'
+			}
+		}
+		if c >= 0x80 {
+			fmt.Fprintf(&b, "\\%03o", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// textWidth estimates the width of s in Helvetica. It is only used to align
+// short labels and numbers, where per-class widths are close enough.
+func textWidth(s string, size float64) float64 {
+	var units float64
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9', r == '$', r == '_':
+			units += 556
+		case strings.ContainsRune("iljI.,:;'!|", r):
+			units += 250
+		case r == ' ' || r == 'f' || r == 't' || r == '/' || r == '(' || r == ')' || r == '-':
+			units += 300
+		case r == 'm' || r == 'w' || r == 'M' || r == 'W' || r == '%':
+			units += 850
+		case r >= 'A' && r <= 'Z':
+			units += 680
+		default:
+			units += 520
+		}
+	}
+	return units / 1000 * size
+}
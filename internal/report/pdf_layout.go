@@ -0,0 +1,161 @@
+package report
+
+import "io"
+
+// pdfLayout places report sections top to bottom, starting new pages as needed
+type pdfLayout struct {
+	doc  *pdfDocument
+	page *pdfPage
+	y    float64
+}
+
+const (
+	contentWidth = pageWidth - 2*pageMargin
+	rowHeight    = 14.0
+)
+
+// WritePDF renders the report as an A4 PDF
+func WritePDF(w io.Writer, d *Data) error {
+	l := &pdfLayout{doc: &pdfDocument{title: d.Title}}
+	l.newPage()
+
+	l.page.Text(pageMargin, l.y+14, d.Title, textStyle{Size: 18, Bold: true, Color: colorText})
+	l.y += 30
+	l.page.Text(pageMargin, l.y, d.period()+" · generated "+d.GeneratedAt.Local().Format("2 Jan 2006 15:04")+" · values in "+d.Unit,
+		textStyle{Size: 9, Color: colorMuted})
+	l.y += 8
+
+	// Summary table on the left, time in ranges on the right
+	summary := d.summaryRows()
+	l.heading("Summary", float64(len(summary))*rowHeight)
+	top := l.y
+	l.rows(summary, pageMargin, contentWidth/2-12)
+	if ranges := d.rangeRows(); len(ranges) > 0 {
+		drawRangeBar(l.page, ranges, pageMargin+contentWidth/2+12, top, contentWidth/2-12, 150)
+		l.y = max(l.y, top+150)
+	}
+
+	if d.AGP != nil && len(d.AGP.Bins) > 0 {
+		l.heading("Ambulatory glucose profile", 220)
+		drawAGP(l.page, d, pageMargin, l.y, contentWidth, 210)
+		l.y += 214
+		l.note("Dark line: median. Bands: 25–75% and 5–95%. Green: target range.")
+
+		l.heading("Daily overlay", 220)
+		drawOverlay(l.page, d, pageMargin, l.y, contentWidth, 210)
+		l.y += 214
+		l.note("Each line is one day; the dark line is the median.")
+	}
+
+	if totals := d.totalsRows(); len(totals) > 0 {
+		l.heading("Daily insulin and carbs", float64(len(totals))*rowHeight)
+		l.rows(totals, pageMargin, contentWidth)
+	}
+	if params := d.parameterRows(); len(params) > 0 {
+		l.heading("Learned parameters", float64(len(params))*rowHeight)
+		l.rows(params, pageMargin, contentWidth)
+	}
+
+	if counts := d.episodeCountRows(); len(counts) > 0 {
+		l.heading("Episodes", 5*rowHeight)
+		l.table([]string{"", "Day", "Night", "Total"}, []float64{0.55, 0.15, 0.15, 0.15}, []bool{false, true, true, true}, countCells(counts))
+		l.note("Night is 00:00–06:00.")
+
+		if episodes := d.episodeRows(); len(episodes) > 0 {
+			l.y += 8
+			cells := make([][]string, len(episodes))
+			for i, e := range episodes {
+				cells[i] = []string{e.Start, e.Kind, e.Duration, e.Extreme, e.Rate, e.Treatments, e.Recovery}
+			}
+			l.table([]string{"Start", "Type", "Duration", "Nadir/peak", "Rate before", "Treated with", "Recovery"},
+				[]float64{0.2, 0.1, 0.12, 0.13, 0.15, 0.18, 0.12},
+				[]bool{false, false, true, true, true, false, true}, cells)
+			if note := d.episodeTableNote(); note != "" {
+				l.note(note)
+			}
+		}
+	}
+
+	return l.doc.write(w)
+}
+
+func (l *pdfLayout) newPage() {
+	l.page = l.doc.newPage()
+	l.y = pageMargin
+}
+
+// ensure starts a new page unless height fits on the current one
+func (l *pdfLayout) ensure(height float64) {
+	if l.y+height > pageHeight-pageMargin {
+		l.newPage()
+	}
+}
+
+// heading starts a section, keeping it on one page with the first
+// contentHeight points of its content
+func (l *pdfLayout) heading(title string, contentHeight float64) {
+	l.ensure(34 + min(contentHeight, 300))
+	l.y += 22
+	l.page.Text(pageMargin, l.y, title, textStyle{Size: 12, Bold: true, Color: colorText})
+	l.y += 4
+	l.page.Line(pageMargin, l.y, pageMargin+contentWidth, l.y, colorMedian, 1.5)
+	l.y += 8
+}
+
+func (l *pdfLayout) note(text string) {
+	l.ensure(rowHeight)
+	l.y += 10
+	l.page.Text(pageMargin, l.y, text, textStyle{Size: 8, Color: colorMuted})
+}
+
+// rows draws label/value pairs in a column of the given width
+func (l *pdfLayout) rows(rows []row, x, width float64) {
+	for _, r := range rows {
+		l.ensure(rowHeight)
+		l.y += rowHeight
+		l.page.Text(x, l.y-4, r.Label, textStyle{Size: 9, Color: colorText})
+		l.page.Text(x+width, l.y-4, r.Value, textStyle{Size: 9, Bold: true, Color: colorText, Align: alignRight})
+		l.page.Line(x, l.y, x+width, l.y, colorGrid, 0.5)
+	}
+}
+
+// table draws a table; widths are fractions of the content width and the
+// header is repeated on every page the table spans
+func (l *pdfLayout) table(header []string, widths []float64, right []bool, cells [][]string) {
+	drawRow := func(values []string, style textStyle) {
+		l.y += rowHeight
+		x := pageMargin
+		for i, v := range values {
+			w := widths[i] * contentWidth
+			s := style
+			tx := x + 2
+			if right[i] {
+				s.Align = alignRight
+				tx = x + w - 2
+			}
+			l.page.Text(tx, l.y-4, v, s)
+			x += w
+		}
+		l.page.Line(pageMargin, l.y, pageMargin+contentWidth, l.y, colorGrid, 0.5)
+	}
+
+	headerStyle := textStyle{Size: 7.5, Bold: true, Color: colorMuted}
+	l.ensure(2 * rowHeight)
+	drawRow(header, headerStyle)
+	for _, c := range cells {
+		if l.y+rowHeight > pageHeight-pageMargin {
+			l.newPage()
+			drawRow(header, headerStyle)
+		}
+		drawRow(c, textStyle{Size: 8.5, Color: colorText})
+	}
+}
+
+// countCells converts episode count rows into table cells
+func countCells(rows [][4]string) [][]string {
+	cells := make([][]string, len(rows))
+	for i, r := range rows {
+		cells[i] = r[:]
+	}
+	return cells
+}
@@ -0,0 +1,255 @@
+// Package report renders printable glucose reports for a period as a
+// self-contained HTML page and as a PDF, without network access
+package report
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+	"github.com/mrcode/nightscout-tray/internal/stats"
+)
+
+const (
+	unitMmolL = "mmol/L"
+
+	// maxEpisodeRows limits the episode table; the counts still cover all episodes
+	maxEpisodeRows = 100
+)
+
+// Data is everything shown in a report
+type Data struct {
+	Title       string
+	From        time.Time
+	To          time.Time
+	GeneratedAt time.Time
+	Unit        string                     // "mg/dL" or "mmol/L"
+	Stats       *stats.Statistics          // Summary statistics
+	AGP         *models.AGPData            // Ambulatory glucose profile and daily curves
+	Episodes    *models.EpisodeReport      // Low and high episodes
+	Totals      *models.DiabetesParameters // Average daily insulin and carbs
+	Parameters  *models.DiabetesParameters // Learned parameters, nil if none were calculated
+}
+
+// row is a label and a formatted value
+type row struct {
+	Label string
+	Value string
+}
+
+// rangeRow is a glucose range with its share of readings
+type rangeRow struct {
+	Label   string
+	Percent float64
+	Color   string
+}
+
+// episodeRow is one line of the episode table
+type episodeRow struct {
+	Start      string
+	Kind       string
+	Duration   string
+	Extreme    string
+	Rate       string
+	Treatments string
+	Recovery   string
+}
+
+// Colors shared by the HTML and PDF output
+const (
+	colorText      = "#1f2937"
+	colorMuted     = "#6b7280"
+	colorGrid      = "#e5e7eb"
+	colorVeryLow   = "#991b1b"
+	colorLow       = "#ef4444"
+	colorInRange   = "#22c55e"
+	colorHigh      = "#facc15"
+	colorVeryHigh  = "#f97316"
+	colorTarget    = "#ecfdf5"
+	colorTargetRim = "#16a34a"
+	colorOuterBand = "#cfe2f3"
+	colorInnerBand = "#6fa8dc"
+	colorMedian    = "#0b5394"
+	colorDay       = "#94a3b8"
+)
+
+func (d *Data) useMmol() bool {
+	return d.Unit == unitMmolL
+}
+
+// glucose formats a mg/dL value in the report unit
+func (d *Data) glucose(mgdl float64) string {
+	if d.useMmol() {
+		return fmt.Sprintf("%.1f", models.ToMmol(mgdl))
+	}
+	return fmt.Sprintf("%.0f", mgdl)
+}
+
+// glucoseUnit formats a mg/dL value with the unit
+func (d *Data) glucoseUnit(mgdl float64) string {
+	return d.glucose(mgdl) + " " + d.Unit
+}
+
+// period describes the report window
+func (d *Data) period() string {
+	return fmt.Sprintf("%s – %s (%.0f days)",
+		d.From.Local().Format("2 Jan 2006"), d.To.Local().Format("2 Jan 2006"), d.To.Sub(d.From).Hours()/24)
+}
+
+func (d *Data) summaryRows() []row {
+	st := d.Stats
+	if st == nil || st.Readings == 0 {
+		return []row{{"Readings", "No readings in this period"}}
+	}
+
+	return []row{
+		{"Readings", fmt.Sprintf("%d (%.0f%% CGM active)", st.Readings, st.CGMActive)},
+		{"Mean glucose", d.glucoseUnit(st.Mean)},
+		{"Median", d.glucoseUnit(st.Median)},
+		{"GMI", fmt.Sprintf("%.1f%%", st.GMI)},
+		{"Standard deviation", d.glucoseUnit(st.SD)},
+		{"Coefficient of variation", fmt.Sprintf("%.1f%%", st.CV)},
+		{"Time in tight range", fmt.Sprintf("%.1f%%", st.Ranges.TITR)},
+		{"Glycemia Risk Index", fmt.Sprintf("%.0f (zone %s)", st.GRI.Value, st.GRI.Zone)},
+		{"LBGI / HBGI", fmt.Sprintf("%.1f / %.1f", st.LBGI, st.HBGI)},
+		{"MAGE", d.glucoseUnit(st.MAGE)},
+		{"CONGA (1 h)", d.glucoseUnit(st.CONGA1)},
+		{"MODD", d.glucoseUnit(st.MODD)},
+		{"J-index", fmt.Sprintf("%.1f", st.JIndex)},
+	}
+}
+
+func (d *Data) rangeRows() []rangeRow {
+	st := d.Stats
+	if st == nil || st.Readings == 0 {
+		return nil
+	}
+
+	th := st.Thresholds
+	return []rangeRow{
+		{fmt.Sprintf("Very high (>%s)", d.glucose(th.VeryHigh)), st.Ranges.TARLevel2, colorVeryHigh},
+		{fmt.Sprintf("High (%s–%s)", d.glucose(th.High), d.glucose(th.VeryHigh)), st.Ranges.TARLevel1, colorHigh},
+		{fmt.Sprintf("In range (%s–%s)", d.glucose(th.Low), d.glucose(th.High)), st.Ranges.TIR, colorInRange},
+		{fmt.Sprintf("Low (%s–%s)", d.glucose(th.VeryLow), d.glucose(th.Low)), st.Ranges.TBRLevel1, colorLow},
+		{fmt.Sprintf("Very low (<%s)", d.glucose(th.VeryLow)), st.Ranges.TBRLevel2, colorVeryLow},
+	}
+}
+
+func (d *Data) totalsRows() []row {
+	t := d.Totals
+	if t == nil || (t.TotalDailyInsulin == 0 && t.TotalDailyCarbs == 0) {
+		return nil
+	}
+
+	rows := []row{
+		{"Total daily insulin", fmt.Sprintf("%.1f U", t.TotalDailyInsulin)},
+		{"Bolus insulin", fmt.Sprintf("%.1f U", t.BolusInsulin)},
+		{"Basal insulin", fmt.Sprintf("%.1f U", t.BasalInsulin)},
+		{"Daily carbs", fmt.Sprintf("%.0f g", t.TotalDailyCarbs)},
+	}
+	if t.TotalDailyInsulin > 0 {
+		rows = append(rows, row{"Bolus share", fmt.Sprintf("%.0f%%", t.BolusInsulin/t.TotalDailyInsulin*100)})
+	}
+	return rows
+}
+
+func (d *Data) parameterRows() []row {
+	p := d.Parameters
+	if p == nil {
+		return nil
+	}
+
+	isf := fmt.Sprintf("%.0f mg/dL per U", p.ISF)
+	if d.useMmol() {
+		isf = fmt.Sprintf("%.1f mmol/L per U", models.ToMmol(p.ISF))
+	}
+
+	return []row{
+		{"Insulin sensitivity (ISF)", fmt.Sprintf("%s (%.0f%% confidence)", isf, p.ISFConfidence)},
+		{"Insulin-to-carb ratio (ICR)", fmt.Sprintf("1 U per %.1f g (%.0f%% confidence)", p.ICR, p.ICRConfidence)},
+		{"Duration of insulin action", fmt.Sprintf("%.1f h (%.0f%% confidence)", p.DIA, p.DIAConfidence)},
+		{"Carb absorption", fmt.Sprintf("%.0f g/h", p.CarbAbsorptionRate)},
+		{"Based on", fmt.Sprintf("%d days, %d readings, %d treatments", p.DataDays, p.EntriesAnalyzed, p.TreatmentsAnalyzed)},
+		{"Calculated", p.CalculatedAt.Local().Format("2 Jan 2006 15:04")},
+	}
+}
+
+// episodeCountRows returns day, night and total counts per episode kind
+func (d *Data) episodeCountRows() [][4]string {
+	e := d.Episodes
+	if e == nil {
+		return nil
+	}
+
+	line := func(label string, c models.EpisodeCounts) [4]string {
+		return [4]string{label, fmt.Sprint(c.Day), fmt.Sprint(c.Night), fmt.Sprint(c.Total)}
+	}
+	th := stats.ConsensusThresholds()
+	return [][4]string{
+		line("Low, level 2 (<"+d.glucoseUnit(th.VeryLow)+")", e.Hypo2),
+		line("Low, level 1 (<"+d.glucoseUnit(th.Low)+")", e.Hypo1),
+		line("High, level 1 (>"+d.glucoseUnit(th.High)+")", e.Hyper1),
+		line("High, level 2 (>"+d.glucoseUnit(th.VeryHigh)+")", e.Hyper2),
+	}
+}
+
+// episodeRows returns the table rows, most recent first
+func (d *Data) episodeRows() []episodeRow {
+	if d.Episodes == nil {
+		return nil
+	}
+
+	episodes := d.Episodes.Episodes
+	rows := make([]episodeRow, 0, min(len(episodes), maxEpisodeRows))
+	for i := len(episodes) - 1; i >= 0 && len(rows) < maxEpisodeRows; i-- {
+		ep := episodes[i]
+
+		kind := "Low"
+		if ep.Type == models.EpisodeHyper {
+			kind = "High"
+		}
+		kind = fmt.Sprintf("%s L%d", kind, ep.Level)
+
+		duration := fmt.Sprintf("%.0f min", ep.DurationMinutes)
+		if ep.Ongoing {
+			duration += "+"
+		}
+
+		rate := d.glucose(ep.RateBefore*5) + "/5 min"
+		if ep.RateBefore > 0 {
+			rate = "+" + rate
+		}
+
+		treatments := ""
+		switch {
+		case ep.RescueCarbs > 0:
+			treatments = fmt.Sprintf("%.0f g carbs", ep.RescueCarbs)
+		case ep.CorrectionInsulin > 0:
+			treatments = fmt.Sprintf("%.1f U insulin", ep.CorrectionInsulin)
+		}
+
+		recovery := "–"
+		if ep.Recovered {
+			recovery = fmt.Sprintf("%.0f min", ep.RecoveryMinutes)
+		}
+
+		rows = append(rows, episodeRow{
+			Start:      ep.Start.Local().Format("Mon 2 Jan 15:04"),
+			Kind:       kind,
+			Duration:   duration,
+			Extreme:    d.glucose(float64(ep.Extreme)),
+			Rate:       rate,
+			Treatments: treatments,
+			Recovery:   recovery,
+		})
+	}
+	return rows
+}
+
+// episodeTableNote says how many episodes were left out of the table
+func (d *Data) episodeTableNote() string {
+	if d.Episodes == nil || len(d.Episodes.Episodes) <= maxEpisodeRows {
+		return ""
+	}
+	return fmt.Sprintf("Showing the %d most recent of %d episodes.", maxEpisodeRows, len(d.Episodes.Episodes))
+}
@@ -0,0 +1,68 @@
+package report
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"strings"
+)
+
+// svgCanvas renders drawing calls as an inline SVG element
+type svgCanvas struct {
+	w, h float64
+	b    strings.Builder
+}
+
+func newSVG(w, h float64) *svgCanvas {
+	return &svgCanvas{w: w, h: h}
+}
+
+func (s *svgCanvas) Rect(x, y, w, h float64, fill string) {
+	fmt.Fprintf(&s.b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`, x, y, w, h, fill)
+}
+
+func (s *svgCanvas) Line(x1, y1, x2, y2 float64, stroke string, width float64) {
+	fmt.Fprintf(&s.b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s" stroke-width="%.2f"/>`, x1, y1, x2, y2, stroke, width)
+}
+
+func (s *svgCanvas) Polyline(points []point, stroke string, width float64) {
+	fmt.Fprintf(&s.b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="%.2f" stroke-linejoin="round"/>`, svgPoints(points), stroke, width)
+}
+
+func (s *svgCanvas) Polygon(points []point, fill string) {
+	fmt.Fprintf(&s.b, `<polygon points="%s" fill="%s"/>`, svgPoints(points), fill)
+}
+
+func (s *svgCanvas) Text(x, y float64, text string, style textStyle) {
+	anchor := "start"
+	switch style.Align {
+	case alignCenter:
+		anchor = "middle"
+	case alignRight:
+		anchor = "end"
+	}
+	weight := "normal"
+	if style.Bold {
+		weight = "bold"
+	}
+	fmt.Fprintf(&s.b, `<text x="%.1f" y="%.1f" font-size="%.1f" font-weight="%s" fill="%s" text-anchor="%s">%s</text>`,
+		x, y, style.Size, weight, style.Color, anchor, html.EscapeString(text))
+}
+
+// HTML returns the finished SVG element
+func (s *svgCanvas) HTML() template.HTML {
+	//nolint:gosec // All text is escaped as it is added
+	return template.HTML(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %.0f %.0f" width="100%%" font-family="Helvetica, Arial, sans-serif">%s</svg>`,
+		s.w, s.h, s.b.String()))
+}
+
+func svgPoints(points []point) string {
+	var b strings.Builder
+	for i, p := range points {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%.1f,%.1f", p.X, p.Y)
+	}
+	return b.String()
+}
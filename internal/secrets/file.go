@@ -0,0 +1,150 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// fileStore keeps secrets AES-GCM encrypted in the config directory. The key
+// lives in a separate file readable only by the user, so secrets don't leak
+// through copies of the settings or the secrets file alone. It does not
+// protect against someone with full access to the user's account.
+type fileStore struct {
+	dir string
+}
+
+func newFileStore(dir string) *fileStore {
+	return &fileStore{dir: dir}
+}
+
+func (f *fileStore) dataPath() string {
+	return filepath.Join(f.dir, "secrets.enc")
+}
+
+func (f *fileStore) keyPath() string {
+	return filepath.Join(f.dir, "secrets.key")
+}
+
+func (f *fileStore) Get(key string) (string, error) {
+	values, err := f.load()
+	if err != nil {
+		return "", err
+	}
+	value, ok := values[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (f *fileStore) Set(key, value string) error {
+	values, err := f.load()
+	if err != nil {
+		return err
+	}
+	values[key] = value
+	return f.save(values)
+}
+
+func (f *fileStore) Delete(key string) error {
+	values, err := f.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := values[key]; !ok {
+		return ErrNotFound
+	}
+	delete(values, key)
+	if len(values) == 0 {
+		return os.Remove(f.dataPath())
+	}
+	return f.save(values)
+}
+
+// load decrypts all secrets; a missing file means no secrets
+func (f *fileStore) load() (map[string]string, error) {
+	data, err := os.ReadFile(f.dataPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(map[string]string), nil
+		}
+		return nil, err
+	}
+
+	gcm, err := f.cipher(false)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("secrets file is corrupt")
+	}
+
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting secrets: %w", err)
+	}
+
+	values := make(map[string]string)
+	if err := json.Unmarshal(plain, &values); err != nil {
+		return nil, fmt.Errorf("parsing secrets: %w", err)
+	}
+	return values, nil
+}
+
+func (f *fileStore) save(values map[string]string) error {
+	plain, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := f.cipher(true)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	tmp := f.dataPath() + ".tmp"
+	if err := os.WriteFile(tmp, gcm.Seal(nonce, nonce, plain, nil), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.dataPath())
+}
+
+// cipher loads the key, creating one first if create is set
+func (f *fileStore) cipher(create bool) (cipher.AEAD, error) {
+	key, err := os.ReadFile(f.keyPath())
+	if os.IsNotExist(err) && create {
+		key = make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(f.dir, 0750); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(f.keyPath(), key, 0600); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("reading secrets key: %w", err)
+	}
+
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secrets key is corrupt")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,53 @@
+//go:build darwin
+
+package secrets
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// securityPath is the macOS keychain command-line tool
+const securityPath = "/usr/bin/security"
+
+// keychain stores secrets as generic passwords in the login keychain
+type keychain struct{}
+
+func newKeyring() keyring {
+	return keychain{}
+}
+
+func (keychain) Get(service, key string) (string, error) {
+	out, err := exec.Command(securityPath, "find-generic-password", "-s", service, "-a", key, "-w").Output() //nolint:gosec // Fixed binary, arguments are not user input
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 44 {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("reading keychain: %w", err)
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// Set passes the value hex-encoded on stdin so it never shows up in the process list
+func (keychain) Set(service, key, value string) error {
+	cmd := exec.Command(securityPath, "-i") //nolint:gosec // Fixed binary
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %q -a %q -X %s\n",
+		service, key, hex.EncodeToString([]byte(value))))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("writing keychain: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (keychain) Delete(service, key string) error {
+	err := exec.Command(securityPath, "delete-generic-password", "-s", service, "-a", key).Run() //nolint:gosec // Fixed binary, arguments are not user input
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 44 {
+			return ErrNotFound
+		}
+		return fmt.Errorf("deleting from keychain: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+//go:build linux
+
+package secrets
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Secret Service D-Bus API (https://specifications.freedesktop.org/secret-service/)
+const (
+	ssBusName         = "org.freedesktop.secrets"
+	ssServicePath     = dbus.ObjectPath("/org/freedesktop/secrets")
+	ssDefaultAlias    = dbus.ObjectPath("/org/freedesktop/secrets/aliases/default")
+	ssServiceIface    = "org.freedesktop.Secret.Service"
+	ssCollectionIface = "org.freedesktop.Secret.Collection"
+	ssItemIface       = "org.freedesktop.Secret.Item"
+	ssPromptIface     = "org.freedesktop.Secret.Prompt"
+
+	// promptTimeout bounds how long we wait for the user to unlock the keyring
+	promptTimeout = 2 * time.Minute
+)
+
+// noPrompt is returned instead of a prompt path when no user interaction is needed
+const noPrompt = dbus.ObjectPath("/")
+
+// ssSecret is the Secret structure (oayays) of the Secret Service API
+type ssSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// secretService talks to the freedesktop Secret Service (GNOME Keyring,
+// KWallet, KeePassXC) on the session bus
+type secretService struct {
+	// connect opens a bus connection. It honours DBUS_SESSION_BUS_ADDRESS,
+	// so a private bus with a stand-in service can be used for testing.
+	connect func() (*dbus.Conn, error)
+}
+
+func newKeyring() keyring {
+	return &secretService{
+		connect: func() (*dbus.Conn, error) {
+			return dbus.ConnectSessionBus()
+		},
+	}
+}
+
+// session is an open connection and plain-transfer session
+type session struct {
+	conn *dbus.Conn
+	path dbus.ObjectPath
+}
+
+func (s *secretService) open() (*session, error) {
+	conn, err := s.connect()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to session bus: %w", err)
+	}
+
+	var output dbus.Variant
+	var path dbus.ObjectPath
+	err = conn.Object(ssBusName, ssServicePath).
+		Call(ssServiceIface+".OpenSession", 0, "plain", dbus.MakeVariant("")).
+		Store(&output, &path)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("opening secret service session: %w", err)
+	}
+
+	return &session{conn: conn, path: path}, nil
+}
+
+func (ss *session) close() {
+	_ = ss.conn.Object(ssBusName, ss.path).Call("org.freedesktop.Secret.Session.Close", 0).Err
+	_ = ss.conn.Close()
+}
+
+func attributes(service, key string) map[string]string {
+	return map[string]string{
+		"service":  service,
+		"username": key,
+	}
+}
+
+// find returns the item holding key, unlocking it if needed
+func (ss *session) find(service, key string) (dbus.ObjectPath, error) {
+	var unlocked, locked []dbus.ObjectPath
+	err := ss.conn.Object(ssBusName, ssServicePath).
+		Call(ssServiceIface+".SearchItems", 0, attributes(service, key)).
+		Store(&unlocked, &locked)
+	if err != nil {
+		return "", fmt.Errorf("searching secret service: %w", err)
+	}
+
+	if len(unlocked) > 0 {
+		return unlocked[0], nil
+	}
+	if len(locked) == 0 {
+		return "", ErrNotFound
+	}
+
+	if err := ss.unlock(locked[:1]); err != nil {
+		return "", err
+	}
+	return locked[0], nil
+}
+
+// unlock unlocks objects, showing the keyring's unlock prompt if required
+func (ss *session) unlock(objects []dbus.ObjectPath) error {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	err := ss.conn.Object(ssBusName, ssServicePath).
+		Call(ssServiceIface+".Unlock", 0, objects).
+		Store(&unlocked, &prompt)
+	if err != nil {
+		return fmt.Errorf("unlocking keyring: %w", err)
+	}
+	return ss.prompt(prompt)
+}
+
+// prompt runs a Secret Service prompt and waits for it to complete
+func (ss *session) prompt(path dbus.ObjectPath) error {
+	if path == noPrompt || path == "" {
+		return nil
+	}
+
+	if err := ss.conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(path),
+		dbus.WithMatchInterface(ssPromptIface),
+		dbus.WithMatchMember("Completed"),
+	); err != nil {
+		return err
+	}
+
+	signals := make(chan *dbus.Signal, 1)
+	ss.conn.Signal(signals)
+	defer ss.conn.RemoveSignal(signals)
+
+	if err := ss.conn.Object(ssBusName, path).Call(ssPromptIface+".Prompt", 0, "").Err; err != nil {
+		return fmt.Errorf("showing keyring prompt: %w", err)
+	}
+
+	timeout := time.After(promptTimeout)
+	for {
+		select {
+		case sig := <-signals:
+			if sig.Path != path || sig.Name != ssPromptIface+".Completed" {
+				continue
+			}
+			if len(sig.Body) > 0 {
+				if dismissed, ok := sig.Body[0].(bool); ok && dismissed {
+					return fmt.Errorf("keyring prompt was dismissed")
+				}
+			}
+			return nil
+		case <-timeout:
+			return fmt.Errorf("timed out waiting for keyring prompt")
+		}
+	}
+}
+
+func (s *secretService) Get(service, key string) (string, error) {
+	ss, err := s.open()
+	if err != nil {
+		return "", err
+	}
+	defer ss.close()
+
+	item, err := ss.find(service, key)
+	if err != nil {
+		return "", err
+	}
+
+	var secret ssSecret
+	if err := ss.conn.Object(ssBusName, item).Call(ssItemIface+".GetSecret", 0, ss.path).Store(&secret); err != nil {
+		return "", fmt.Errorf("reading secret: %w", err)
+	}
+	return string(secret.Value), nil
+}
+
+func (s *secretService) Set(service, key, value string) error {
+	ss, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer ss.close()
+
+	collection, err := ss.defaultCollection()
+	if err != nil {
+		return err
+	}
+
+	props := map[string]dbus.Variant{
+		"org.freedesktop.Secret.Item.Label":      dbus.MakeVariant(fmt.Sprintf("%s: %s", service, key)),
+		"org.freedesktop.Secret.Item.Attributes": dbus.MakeVariant(attributes(service, key)),
+	}
+	secret := ssSecret{
+		Session:     ss.path,
+		Parameters:  []byte{},
+		Value:       []byte(value),
+		ContentType: "text/plain; charset=utf8",
+	}
+
+	var item, prompt dbus.ObjectPath
+	err = ss.conn.Object(ssBusName, collection).
+		Call(ssCollectionIface+".CreateItem", 0, props, secret, true).
+		Store(&item, &prompt)
+	if err != nil {
+		return fmt.Errorf("storing secret: %w", err)
+	}
+	return ss.prompt(prompt)
+}
+
+func (s *secretService) Delete(service, key string) error {
+	ss, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer ss.close()
+
+	item, err := ss.find(service, key)
+	if err != nil {
+		return err
+	}
+
+	var prompt dbus.ObjectPath
+	if err := ss.conn.Object(ssBusName, item).Call(ssItemIface+".Delete", 0).Store(&prompt); err != nil {
+		return fmt.Errorf("deleting secret: %w", err)
+	}
+	return ss.prompt(prompt)
+}
+
+// defaultCollection returns the unlocked default collection
+func (ss *session) defaultCollection() (dbus.ObjectPath, error) {
+	var collection dbus.ObjectPath
+	err := ss.conn.Object(ssBusName, ssServicePath).
+		Call(ssServiceIface+".ReadAlias", 0, "default").
+		Store(&collection)
+	if err != nil || collection == noPrompt {
+		collection = ssDefaultAlias
+	}
+
+	locked, err := ss.conn.Object(ssBusName, collection).GetProperty(ssCollectionIface + ".Locked")
+	if err == nil {
+		if isLocked, ok := locked.Value().(bool); ok && isLocked {
+			if err := ss.unlock([]dbus.ObjectPath{collection}); err != nil {
+				return "", err
+			}
+		}
+	}
+	return collection, nil
+}
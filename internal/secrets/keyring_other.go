@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !windows
+
+package secrets
+
+// newKeyring returns nil; secrets are kept in the encrypted file
+func newKeyring() keyring {
+	return nil
+}
@@ -0,0 +1,116 @@
+// Package secrets keeps credentials in the operating system's keyring, with
+// an encrypted file in the config directory as a fallback
+package secrets
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/mrcode/nightscout-tray/internal/logging"
+)
+
+// ServiceName identifies the app's entries in the keyring
+const ServiceName = "nightscout-tray"
+
+// Backend names returned by Set
+const (
+	BackendKeyring = "keyring"
+	BackendFile    = "file"
+)
+
+// ErrNotFound is returned when no secret is stored under a key
+var ErrNotFound error = notFoundError{}
+
+// notFoundError also reports itself through a NotFound method, so callers
+// can recognize it without importing this package
+type notFoundError struct{}
+
+func (notFoundError) Error() string  { return "secret not found" }
+func (notFoundError) NotFound() bool { return true }
+
+// keyring is a platform credential store
+type keyring interface {
+	Get(service, key string) (string, error)
+	Set(service, key, value string) error
+	Delete(service, key string) error
+}
+
+// Store reads and writes secrets, preferring the OS keyring and falling back
+// to an encrypted file when no keyring is available
+type Store struct {
+	mu      sync.Mutex
+	keyring keyring // nil if the platform has none
+	file    *fileStore
+}
+
+// NewStore creates a store whose fallback file lives in dir
+func NewStore(dir string) *Store {
+	return &Store{
+		keyring: newKeyring(),
+		file:    newFileStore(dir),
+	}
+}
+
+// Get returns the secret stored under key, or ErrNotFound
+func (s *Store) Get(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.keyring != nil {
+		value, err := s.keyring.Get(ServiceName, key)
+		if err == nil {
+			return value, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			logger.Warn("keyring unavailable, using encrypted file", "error", err)
+		}
+	}
+	return s.file.Get(key)
+}
+
+// Set stores value under key and returns the backend that holds it.
+// An empty value deletes the secret.
+func (s *Store) Set(key, value string) (string, error) {
+	if value == "" {
+		return "", s.Delete(key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.keyring != nil {
+		err := s.keyring.Set(ServiceName, key, value)
+		if err == nil {
+			// Don't leave an older copy behind in the fallback file
+			if err := s.file.Delete(key); err != nil && !errors.Is(err, ErrNotFound) {
+				logger.Warn("removing secret from file failed", "error", err)
+			}
+			return BackendKeyring, nil
+		}
+		logger.Warn("keyring unavailable, using encrypted file", "error", err)
+	}
+
+	if err := s.file.Set(key, value); err != nil {
+		return "", err
+	}
+	return BackendFile, nil
+}
+
+// Delete removes key from all backends. Missing secrets are not an error.
+func (s *Store) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	if s.keyring != nil {
+		if err := s.keyring.Delete(ServiceName, key); err != nil && !errors.Is(err, ErrNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	if err := s.file.Delete(key); err != nil && !errors.Is(err, ErrNotFound) {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+var logger = logging.For(logging.ComponentSecrets)
@@ -0,0 +1,168 @@
+package stats
+
+import (
+	"math"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+const (
+	// DefaultAGPBinMinutes is the time-of-day bin width used when none is given
+	DefaultAGPBinMinutes = 15
+
+	// minAGPBinReadings is the fewest readings a bin needs for its percentiles to be shown
+	minAGPBinReadings = 5
+
+	// agpSmoothingMinutes is the half-width of the window percentile curves are smoothed over
+	agpSmoothingMinutes = 15
+)
+
+// agpPercentiles are the percentiles drawn in the standard AGP report
+var agpPercentiles = [5]float64{5, 25, 50, 75, 95}
+
+// AGPOptions controls ComputeAGP
+type AGPOptions struct {
+	BinMinutes int              // 5 or 15; other values use DefaultAGPBinMinutes
+	Location   *time.Location   // Time zone the day is split in; nil uses time.Local
+	Settings   *models.Settings // Unit and thresholds for display
+}
+
+// ComputeAGP builds the ambulatory glucose profile for readings in [from, to]
+func ComputeAGP(entries []models.GlucoseEntry, from, to time.Time, opts AGPOptions) *models.AGPData {
+	binMinutes := opts.BinMinutes
+	if binMinutes != 5 && binMinutes != 15 {
+		binMinutes = DefaultAGPBinMinutes
+	}
+	loc := opts.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	settings := opts.Settings
+	if settings == nil {
+		settings = models.DefaultSettings()
+	}
+	useMmol := settings.Unit == "mmol/L"
+
+	agp := &models.AGPData{
+		From:       from,
+		To:         to,
+		Days:       int(math.Round(to.Sub(from).Hours() / 24)),
+		BinMinutes: binMinutes,
+		TargetLow:  settings.TargetLow,
+		TargetHigh: settings.TargetHigh,
+		UrgentLow:  settings.UrgentLow,
+		UrgentHigh: settings.UrgentHigh,
+		Unit:       settings.Unit,
+	}
+
+	readings := prepare(entries, from, to)
+	binCount := 24 * 60 / binMinutes
+	bins := make([][]float64, binCount)
+
+	var day *models.DailyCurve
+	var daySum float64
+	finishDay := func() {
+		if day != nil {
+			day.Mean = convert(daySum/float64(len(day.Entries)), useMmol)
+			agp.Daily = append(agp.Daily, *day)
+		}
+	}
+
+	for _, r := range readings {
+		local := r.t.In(loc)
+		minute := local.Hour()*60 + local.Minute()
+		bins[minute/binMinutes] = append(bins[minute/binMinutes], r.value)
+
+		date := local.Format("2006-01-02")
+		if day == nil || day.Date != date {
+			finishDay()
+			day = &models.DailyCurve{Date: date}
+			daySum = 0
+		}
+		day.Entries = append(day.Entries, models.ChartEntry{
+			Time:    r.t.UnixMilli(),
+			Value:   convert(r.value, useMmol),
+			ValueMg: int(r.value),
+			Status:  settings.GetGlucoseStatus(int(r.value)),
+		})
+		daySum += r.value
+	}
+	finishDay()
+
+	// Raw percentiles per bin; bins with too few readings are left out
+	raw := make([][5]float64, binCount)
+	valid := make([]bool, binCount)
+	for i, values := range bins {
+		if len(values) < minAGPBinReadings {
+			continue
+		}
+		valid[i] = true
+		for p, pct := range agpPercentiles {
+			raw[i][p] = percentile(values, pct)
+		}
+	}
+
+	smoothed := smoothCircular(raw, valid, agpSmoothingMinutes/binMinutes)
+	for i := range bins {
+		if !valid[i] {
+			continue
+		}
+		s := smoothed[i]
+		agp.Bins = append(agp.Bins, models.AGPBin{
+			Minute: i * binMinutes,
+			Count:  len(bins[i]),
+			P5:     convert(s[0], useMmol),
+			P25:    convert(s[1], useMmol),
+			P50:    convert(s[2], useMmol),
+			P75:    convert(s[3], useMmol),
+			P95:    convert(s[4], useMmol),
+		})
+	}
+
+	return agp
+}
+
+// smoothCircular applies a triangular moving average over the valid bins
+// within halfWidth of each bin. Bins wrap around midnight.
+func smoothCircular(raw [][5]float64, valid []bool, halfWidth int) [][5]float64 {
+	n := len(raw)
+	out := make([][5]float64, n)
+	for i := range raw {
+		if !valid[i] {
+			continue
+		}
+
+		var weightSum float64
+		for offset := -halfWidth; offset <= halfWidth; offset++ {
+			j := ((i+offset)%n + n) % n
+			if !valid[j] {
+				continue
+			}
+			weight := float64(halfWidth + 1 - abs(offset))
+			weightSum += weight
+			for p := range out[i] {
+				out[i][p] += weight * raw[j][p]
+			}
+		}
+		for p := range out[i] {
+			out[i][p] /= weightSum
+		}
+	}
+	return out
+}
+
+// convert turns a mg/dL value into the display unit
+func convert(mgdl float64, useMmol bool) float64 {
+	if useMmol {
+		return models.ToMmol(mgdl)
+	}
+	return mgdl
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
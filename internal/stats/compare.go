@@ -0,0 +1,288 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// Significance hints for a metric difference
+const (
+	SignificanceStrong       = "strong"       // p < 0.01
+	SignificanceSignificant  = "significant"  // p < 0.05
+	SignificanceSuggestive   = "suggestive"   // p < 0.10
+	SignificanceNone         = "none"         // Within day-to-day variation
+	SignificanceInsufficient = "insufficient" // Too few complete days to tell
+)
+
+const (
+	// minCompareDays is the fewest complete days per period a difference is tested with
+	minCompareDays = 3
+
+	// minDayCoverage is the CGM active percentage a day needs to count as complete
+	minDayCoverage = 70
+)
+
+// CompareOptions controls Compare
+type CompareOptions struct {
+	Thresholds Thresholds     // Range boundaries in mg/dL
+	Location   *time.Location // Time zone days are split in; nil uses time.Local
+	Unit       string         // Glucose unit used in hints
+}
+
+// Comparison holds the statistics of two periods side by side. A is the
+// period being judged, B the one it is compared against.
+type Comparison struct {
+	A       *Statistics        `json:"a"`
+	B       *Statistics        `json:"b"`
+	DaysA   int                `json:"daysA"` // Complete days used for significance
+	DaysB   int                `json:"daysB"`
+	Metrics []MetricComparison `json:"metrics"`
+}
+
+// MetricComparison is one metric in both periods. Significance comes from a
+// Welch t-test on daily values: readings within a day are too strongly
+// correlated to count as independent samples.
+type MetricComparison struct {
+	Key          string  `json:"key"`
+	Label        string  `json:"label"`
+	Unit         string  `json:"unit"` // "mg/dL", "%" or ""
+	A            float64 `json:"a"`
+	B            float64 `json:"b"`
+	Difference   float64 `json:"difference"`   // A - B
+	Improved     bool    `json:"improved"`     // A is better than B
+	Meaningful   bool    `json:"meaningful"`   // The difference exceeds the clinically relevant change
+	PValue       float64 `json:"pValue"`       // 0 when not tested
+	Significance string  `json:"significance"` // One of the Significance hints
+	Hint         string  `json:"hint"`
+}
+
+// compareMetric describes how a metric is read and judged
+type compareMetric struct {
+	key, label, unit string
+	higherIsBetter   bool
+	meaningful       float64 // Smallest clinically relevant difference
+	value            func(*Statistics) float64
+}
+
+// compareMetrics are the metrics compared, in display order. Relevant
+// differences follow the consensus report, e.g. 5 points time in range.
+var compareMetrics = []compareMetric{
+	{"tir", "Time in range", "%", true, 5, func(s *Statistics) float64 { return s.Ranges.TIR }},
+	{"tbr", "Time below range", "%", false, 1, func(s *Statistics) float64 { return s.Ranges.TBRLevel1 + s.Ranges.TBRLevel2 }},
+	{"tar", "Time above range", "%", false, 5, func(s *Statistics) float64 { return s.Ranges.TARLevel1 + s.Ranges.TARLevel2 }},
+	{"titr", "Time in tight range", "%", true, 5, func(s *Statistics) float64 { return s.Ranges.TITR }},
+	{"mean", "Mean glucose", "mg/dL", false, 10, func(s *Statistics) float64 { return s.Mean }},
+	{"sd", "Standard deviation", "mg/dL", false, 5, func(s *Statistics) float64 { return s.SD }},
+	{"cv", "Coefficient of variation", "%", false, 3, func(s *Statistics) float64 { return s.CV }},
+	{"gmi", "GMI", "%", false, 0.2, func(s *Statistics) float64 { return s.GMI }},
+	{"gri", "Glycemia Risk Index", "", false, 5, func(s *Statistics) float64 { return s.GRI.Value }},
+}
+
+// Compare computes statistics for periods [aFrom, aTo] and [bFrom, bTo]
+// and how they differ. Values stay in mg/dL like Statistics.
+func Compare(a, b []models.GlucoseEntry, aFrom, aTo, bFrom, bTo time.Time, opts CompareOptions) *Comparison {
+	loc := opts.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	c := &Comparison{
+		A: Compute(a, aFrom, aTo, opts.Thresholds),
+		B: Compute(b, bFrom, bTo, opts.Thresholds),
+	}
+	daysA := dailyStatistics(a, aFrom, aTo, opts.Thresholds, loc)
+	daysB := dailyStatistics(b, bFrom, bTo, opts.Thresholds, loc)
+	c.DaysA, c.DaysB = len(daysA), len(daysB)
+
+	for _, m := range compareMetrics {
+		mc := MetricComparison{
+			Key:          m.key,
+			Label:        m.label,
+			Unit:         m.unit,
+			A:            m.value(c.A),
+			B:            m.value(c.B),
+			Significance: SignificanceInsufficient,
+		}
+		mc.Difference = mc.A - mc.B
+		mc.Improved = (mc.Difference > 0) == m.higherIsBetter && mc.Difference != 0
+		mc.Meaningful = math.Abs(mc.Difference) >= m.meaningful
+
+		if c.A.Readings > 0 && c.B.Readings > 0 && len(daysA) >= minCompareDays && len(daysB) >= minCompareDays {
+			mc.PValue = welchPValue(dailyValues(daysA, m.value), dailyValues(daysB, m.value))
+			mc.Significance = significance(mc.PValue)
+		}
+		mc.Hint = compareHint(m, mc, opts.Unit)
+		c.Metrics = append(c.Metrics, mc)
+	}
+	return c
+}
+
+// dailyStatistics returns the statistics of each sufficiently covered local
+// day in [from, to]
+func dailyStatistics(entries []models.GlucoseEntry, from, to time.Time, thresholds Thresholds, loc *time.Location) []*Statistics {
+	var days []*Statistics
+	local := from.In(loc)
+	for start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc); start.Before(to); {
+		end := time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, loc)
+		dayFrom, dayTo := start, end.Add(-time.Millisecond)
+		if dayFrom.Before(from) {
+			dayFrom = from
+		}
+		if dayTo.After(to) {
+			dayTo = to
+		}
+		// Partial days at the edges of the period still have to be mostly covered
+		if st := Compute(entries, dayFrom, dayTo, thresholds); st.Readings > 0 && st.CGMActive >= minDayCoverage &&
+			st.To.Sub(st.From) >= 12*time.Hour {
+			days = append(days, st)
+		}
+		start = end
+	}
+	return days
+}
+
+func dailyValues(days []*Statistics, value func(*Statistics) float64) []float64 {
+	out := make([]float64, len(days))
+	for i, d := range days {
+		out[i] = value(d)
+	}
+	return out
+}
+
+func significance(p float64) string {
+	switch {
+	case p < 0.01:
+		return SignificanceStrong
+	case p < 0.05:
+		return SignificanceSignificant
+	case p < 0.10:
+		return SignificanceSuggestive
+	default:
+		return SignificanceNone
+	}
+}
+
+// compareHint describes a difference in one sentence
+func compareHint(m compareMetric, mc MetricComparison, glucoseUnit string) string {
+	if mc.Difference == 0 {
+		return fmt.Sprintf("%s is unchanged.", m.label)
+	}
+	direction := "worse"
+	if mc.Improved {
+		direction = "better"
+	}
+	difference := math.Abs(mc.Difference)
+	unit := ""
+	switch m.unit {
+	case "%":
+		unit = " points"
+	case "mg/dL":
+		unit = " mg/dL"
+		if glucoseUnit == "mmol/L" {
+			difference = models.ToMmol(difference)
+			unit = " mmol/L"
+		}
+	}
+	hint := fmt.Sprintf("%s is %.1f%s %s", m.label, difference, unit, direction)
+
+	switch mc.Significance {
+	case SignificanceInsufficient:
+		hint += fmt.Sprintf("; each period needs %d complete days to judge whether that is more than day-to-day variation", minCompareDays)
+	case SignificanceNone:
+		hint += "; that is within normal day-to-day variation"
+	case SignificanceSuggestive:
+		hint += fmt.Sprintf("; this may be a real change (p=%.2f)", mc.PValue)
+	default:
+		p := fmt.Sprintf("p=%.3f", mc.PValue)
+		if mc.PValue < 0.001 {
+			p = "p<0.001"
+		}
+		hint += fmt.Sprintf("; this is unlikely to be chance (%s)", p)
+	}
+	if mc.Meaningful && mc.Significance != SignificanceNone && mc.Significance != SignificanceInsufficient {
+		hint += " and large enough to matter clinically"
+	}
+	return hint + "."
+}
+
+// welchPValue returns the two-sided p-value of Welch's t-test for a
+// difference in means between two samples
+func welchPValue(a, b []float64) float64 {
+	if len(a) < 2 || len(b) < 2 {
+		return 1
+	}
+	meanA, sdA := meanSD(a)
+	meanB, sdB := meanSD(b)
+	va, vb := sdA*sdA/float64(len(a)), sdB*sdB/float64(len(b))
+	if va+vb == 0 {
+		if meanA == meanB {
+			return 1
+		}
+		return 0
+	}
+
+	t := (meanA - meanB) / math.Sqrt(va+vb)
+	df := (va + vb) * (va + vb) / (va*va/float64(len(a)-1) + vb*vb/float64(len(b)-1))
+	// P(|T| > t) for Student's t with df degrees of freedom
+	return incompleteBeta(df/2, 0.5, df/(df+t*t))
+}
+
+// incompleteBeta returns the regularized incomplete beta function I_x(a, b)
+func incompleteBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	lga, _ := math.Lgamma(a)
+	lgb, _ := math.Lgamma(b)
+	lgab, _ := math.Lgamma(a + b)
+	front := math.Exp(lgab - lga - lgb + a*math.Log(x) + b*math.Log(1-x))
+
+	// The continued fraction converges quickly only below this point
+	if x < (a+1)/(a+b+2) {
+		return front * betaFraction(a, b, x) / a
+	}
+	return 1 - front*betaFraction(b, a, 1-x)/b
+}
+
+// betaFraction evaluates the continued fraction of the incomplete beta
+// function with Lentz's method
+func betaFraction(a, b, x float64) float64 {
+	const (
+		maxIterations = 200
+		epsilon       = 1e-12
+		tiny          = 1e-300
+	)
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= maxIterations; m++ {
+		fm := float64(m)
+		for _, num := range [2]float64{
+			fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm)),
+			-(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1)),
+		} {
+			d = 1 + num*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + num/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			h *= d * c
+		}
+		if math.Abs(d*c-1) < epsilon {
+			break
+		}
+	}
+	return h
+}
@@ -0,0 +1,240 @@
+package stats
+
+import (
+	"sort"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+const (
+	// episodeMinDuration is how long glucose must stay across a threshold
+	// for an episode to start, and back across it for the episode to end
+	episodeMinDuration = 15 * time.Minute
+
+	// episodeMaxGap splits an episode when readings are missing for longer
+	episodeMaxGap = 30 * time.Minute
+
+	// episodeRateWindow is the period before an episode its rate is measured over
+	episodeRateWindow = 30 * time.Minute
+
+	// episodeFollowUp is how long after an episode treatments are attributed to it
+	episodeFollowUp = time.Hour
+
+	// Night is 00:00 to 06:00 local time, as in the consensus report
+	nightEndHour = 6
+)
+
+// episodeDefinition is a consensus episode type and its threshold
+type episodeDefinition struct {
+	kind      string
+	level     int
+	threshold float64
+}
+
+// EpisodeOptions controls DetectEpisodes
+type EpisodeOptions struct {
+	Location *time.Location   // Time zone used for day and night; nil uses time.Local
+	Settings *models.Settings // Unit for display
+}
+
+// DetectEpisodes finds consensus hypo- and hyperglycemic episodes in
+// [from, to]: level 1 below 70 or above 180 mg/dL and level 2 below 54 or
+// above 250 mg/dL, each lasting at least 15 minutes and ending after 15
+// minutes back across the threshold.
+func DetectEpisodes(entries []models.GlucoseEntry, treatments []models.Treatment, from, to time.Time, opts EpisodeOptions) *models.EpisodeReport {
+	loc := opts.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	settings := opts.Settings
+	if settings == nil {
+		settings = models.DefaultSettings()
+	}
+	useMmol := settings.Unit == "mmol/L"
+
+	report := &models.EpisodeReport{From: from, To: to, Unit: settings.Unit}
+	readings := prepare(entries, from, to)
+
+	sorted := append([]models.Treatment(nil), treatments...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time().Before(sorted[j].Time()) })
+
+	th := ConsensusThresholds()
+	definitions := []episodeDefinition{
+		{models.EpisodeHypo, 1, th.Low},
+		{models.EpisodeHypo, 2, th.VeryLow},
+		{models.EpisodeHyper, 1, th.High},
+		{models.EpisodeHyper, 2, th.VeryHigh},
+	}
+
+	for _, def := range definitions {
+		for _, span := range findSpans(readings, def) {
+			ep := describeEpisode(readings, span, def, th, sorted)
+			ep.ExtremeValue = convert(float64(ep.Extreme), useMmol)
+			ep.Night = ep.Start.In(loc).Hour() < nightEndHour
+
+			counts := def.countsIn(report)
+			counts.Total++
+			if ep.Night {
+				counts.Night++
+			} else {
+				counts.Day++
+			}
+			report.Episodes = append(report.Episodes, ep)
+		}
+	}
+
+	sort.SliceStable(report.Episodes, func(i, j int) bool {
+		return report.Episodes[i].Start.Before(report.Episodes[j].Start)
+	})
+	return report
+}
+
+// episodeSpan indexes the first and last reading across the threshold and
+// the first reading back (-1 if the data ends first)
+type episodeSpan struct {
+	first, last, exit int
+}
+
+// findSpans returns the episodes for one definition
+func findSpans(readings []reading, def episodeDefinition) []episodeSpan {
+	across := func(v float64) bool {
+		if def.kind == models.EpisodeHypo {
+			return v < def.threshold
+		}
+		return v > def.threshold
+	}
+
+	var spans []episodeSpan
+	current := episodeSpan{first: -1}
+	exitStart := -1 // First reading of the current run back across the threshold
+
+	closeSpan := func() {
+		if current.first >= 0 && readings[current.last].t.Sub(readings[current.first].t) >= episodeMinDuration {
+			spans = append(spans, current)
+		}
+		current = episodeSpan{first: -1}
+		exitStart = -1
+	}
+
+	for i, r := range readings {
+		if i > 0 && current.first >= 0 && r.t.Sub(readings[i-1].t) > episodeMaxGap {
+			// Missing data: end at the last reading we have
+			current.exit = exitStart
+			closeSpan()
+		}
+
+		if across(r.value) {
+			if current.first < 0 {
+				current = episodeSpan{first: i, exit: -1}
+			}
+			current.last = i
+			exitStart = -1
+			continue
+		}
+
+		if current.first < 0 {
+			continue
+		}
+		if exitStart < 0 {
+			exitStart = i
+		}
+		if r.t.Sub(readings[exitStart].t) >= episodeMinDuration {
+			current.exit = exitStart
+			closeSpan()
+		}
+	}
+
+	if current.first >= 0 {
+		if exitStart >= 0 {
+			current.exit = exitStart
+		}
+		closeSpan()
+	}
+	return spans
+}
+
+// describeEpisode fills in the details of an episode span
+func describeEpisode(readings []reading, span episodeSpan, def episodeDefinition, th Thresholds, treatments []models.Treatment) models.GlucoseEpisode {
+	start := readings[span.first]
+	ep := models.GlucoseEpisode{
+		Type:      def.kind,
+		Level:     def.level,
+		Threshold: int(def.threshold),
+		Start:     start.t,
+	}
+
+	if span.exit >= 0 {
+		ep.End = readings[span.exit].t
+	} else {
+		ep.End = readings[span.last].t
+		ep.Ongoing = true
+	}
+	ep.DurationMinutes = ep.End.Sub(ep.Start).Minutes()
+
+	extreme := span.first
+	for i := span.first; i <= span.last; i++ {
+		v := readings[i].value
+		if (def.kind == models.EpisodeHypo && v < readings[extreme].value) ||
+			(def.kind == models.EpisodeHyper && v > readings[extreme].value) {
+			extreme = i
+		}
+	}
+	ep.Extreme = int(readings[extreme].value)
+	ep.ExtremeTime = readings[extreme].t
+
+	for i := span.first - 1; i >= 0 && start.t.Sub(readings[i].t) <= episodeRateWindow; i-- {
+		if minutes := start.t.Sub(readings[i].t).Minutes(); minutes > 0 {
+			ep.RateBefore = (start.value - readings[i].value) / minutes
+		}
+	}
+
+	// Recovery means back in the target range, which for level 2 episodes
+	// is further than the episode threshold
+	for i := extreme; i < len(readings); i++ {
+		v := readings[i].value
+		if (def.kind == models.EpisodeHypo && v >= th.Low) || (def.kind == models.EpisodeHyper && v <= th.High) {
+			ep.Recovered = true
+			ep.RecoveryMinutes = readings[i].t.Sub(ep.Start).Minutes()
+			break
+		}
+	}
+
+	followUpEnd := ep.End.Add(episodeFollowUp)
+	for i := range treatments {
+		t := &treatments[i]
+		tt := t.Time()
+		if tt.Before(ep.Start) || tt.After(followUpEnd) || (t.Carbs <= 0 && t.Insulin <= 0) {
+			continue
+		}
+
+		ep.Treatments = append(ep.Treatments, models.EpisodeTreatment{
+			Time:      tt,
+			EventType: t.EventType,
+			Carbs:     t.Carbs,
+			Insulin:   t.Insulin,
+			After:     tt.After(ep.End),
+		})
+		if def.kind == models.EpisodeHypo {
+			ep.RescueCarbs += t.Carbs
+		} else {
+			ep.CorrectionInsulin += t.Insulin
+		}
+	}
+
+	return ep
+}
+
+// countsIn returns the report's counters for the definition
+func (def episodeDefinition) countsIn(report *models.EpisodeReport) *models.EpisodeCounts {
+	switch {
+	case def.kind == models.EpisodeHypo && def.level == 1:
+		return &report.Hypo1
+	case def.kind == models.EpisodeHypo:
+		return &report.Hypo2
+	case def.level == 1:
+		return &report.Hyper1
+	default:
+		return &report.Hyper2
+	}
+}
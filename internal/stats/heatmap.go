@@ -0,0 +1,52 @@
+package stats
+
+import (
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+// ComputeHeatmaps counts the readings in [from, to] by local weekday and
+// hour and returns the share in, below and above the range in each cell.
+// Days are split in loc; nil uses time.Local.
+func ComputeHeatmaps(entries []models.GlucoseEntry, from, to time.Time, thresholds Thresholds, loc *time.Location) *models.WeekdayHeatmaps {
+	if loc == nil {
+		loc = time.Local
+	}
+
+	var total, in, low, high [7][24]int
+	for _, r := range prepare(entries, from, to) {
+		local := r.t.In(loc)
+		d, h := local.Weekday(), local.Hour()
+		total[d][h]++
+		switch {
+		case r.value < thresholds.Low:
+			low[d][h]++
+		case r.value > thresholds.High:
+			high[d][h]++
+		default:
+			in[d][h]++
+		}
+	}
+
+	grid := func(metric string, counts *[7][24]int) models.Heatmap {
+		hm := models.Heatmap{Metric: metric, Cells: make([][]models.HeatmapCell, 7)}
+		for d := range hm.Cells {
+			hm.Cells[d] = make([]models.HeatmapCell, 24)
+			for h := range hm.Cells[d] {
+				cell := models.HeatmapCell{Readings: total[d][h]}
+				if cell.Readings > 0 {
+					cell.Percent = float64(counts[d][h]) / float64(cell.Readings) * 100
+				}
+				hm.Cells[d][h] = cell
+			}
+		}
+		return hm
+	}
+
+	return &models.WeekdayHeatmaps{
+		TIR:   grid("tir", &in),
+		Lows:  grid("lows", &low),
+		Highs: grid("highs", &high),
+	}
+}
@@ -0,0 +1,310 @@
+// Package stats computes glycemic statistics over a window of CGM readings
+// following the international consensus on CGM metrics
+package stats
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+const (
+	// Tight range used for time in tight range (TITR), in mg/dL
+	tightRangeLow  = 70
+	tightRangeHigh = 140
+
+	// Readings outside this range are sensor errors, not glucose values
+	minValidSGV = 39
+	maxValidSGV = 600
+
+	// defaultCadence is assumed when the reading interval can't be estimated
+	defaultCadence = 5 * time.Minute
+
+	// matchTolerance is how far apart two readings may be and still be
+	// paired for CONGA and MODD
+	matchTolerance = 150 * time.Second
+)
+
+// Thresholds are the range boundaries in mg/dL. Readings below VeryLow are
+// level 2 hypoglycemia, VeryLow up to Low level 1; above High is level 1
+// hyperglycemia and above VeryHigh level 2.
+type Thresholds struct {
+	VeryLow  float64 `json:"veryLow"`
+	Low      float64 `json:"low"`
+	High     float64 `json:"high"`
+	VeryHigh float64 `json:"veryHigh"`
+}
+
+// ConsensusThresholds returns the international consensus boundaries
+func ConsensusThresholds() Thresholds {
+	return Thresholds{VeryLow: 54, Low: 70, High: 180, VeryHigh: 250}
+}
+
+// ThresholdsFromSettings uses the user's urgent and target limits as range boundaries
+func ThresholdsFromSettings(settings *models.Settings) Thresholds {
+	return Thresholds{
+		VeryLow:  float64(settings.UrgentLow),
+		Low:      float64(settings.TargetLow),
+		High:     float64(settings.TargetHigh),
+		VeryHigh: float64(settings.UrgentHigh),
+	}
+}
+
+// Ranges are the percentages of readings in each glucose range
+type Ranges struct {
+	TBRLevel2 float64 `json:"tbrLevel2"` // Below VeryLow
+	TBRLevel1 float64 `json:"tbrLevel1"` // VeryLow to below Low
+	TIR       float64 `json:"tir"`       // Low to High
+	TITR      float64 `json:"titr"`      // 70-140 mg/dL
+	TARLevel1 float64 `json:"tarLevel1"` // Above High up to VeryHigh
+	TARLevel2 float64 `json:"tarLevel2"` // Above VeryHigh
+}
+
+// GRI is the Glycemia Risk Index with its hypo- and hyperglycemia components
+type GRI struct {
+	Value float64 `json:"value"` // 0-100, lower is better
+	Zone  string  `json:"zone"`  // "A" (best) to "E"
+	Hypo  float64 `json:"hypo"`
+	Hyper float64 `json:"hyper"`
+}
+
+// Statistics summarizes the readings in a time window. Glucose values are in mg/dL.
+type Statistics struct {
+	From       time.Time  `json:"from"`
+	To         time.Time  `json:"to"`
+	Thresholds Thresholds `json:"thresholds"`
+
+	// Data sufficiency
+	Readings         int     `json:"readings"`
+	ExpectedReadings int     `json:"expectedReadings"`
+	CGMActive        float64 `json:"cgmActive"` // Percentage of expected readings present
+	Days             float64 `json:"days"`
+
+	// Central tendency and dispersion
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	Min    int     `json:"min"`
+	Max    int     `json:"max"`
+	SD     float64 `json:"sd"`
+	CV     float64 `json:"cv"`  // Coefficient of variation, %
+	GMI    float64 `json:"gmi"` // Glucose Management Indicator, %
+
+	// Time in ranges with the user's thresholds and with the consensus ones
+	Ranges          Ranges `json:"ranges"`
+	ConsensusRanges Ranges `json:"consensusRanges"`
+
+	// Risk and variability indices
+	GRI    GRI     `json:"gri"`
+	LBGI   float64 `json:"lbgi"`
+	HBGI   float64 `json:"hbgi"`
+	MAGE   float64 `json:"mage"`
+	CONGA1 float64 `json:"conga1"` // CONGA over 1 hour
+	MODD   float64 `json:"modd"`
+	JIndex float64 `json:"jIndex"`
+}
+
+// reading is a validated glucose value at a point in time
+type reading struct {
+	t     time.Time
+	value float64
+}
+
+// Compute calculates statistics for the readings in [from, to]. Entries
+// outside the window, duplicates and sensor error values are ignored.
+func Compute(entries []models.GlucoseEntry, from, to time.Time, thresholds Thresholds) *Statistics {
+	if now := time.Now(); to.After(now) {
+		to = now
+	}
+
+	st := &Statistics{
+		From:       from,
+		To:         to,
+		Thresholds: thresholds,
+		Days:       to.Sub(from).Hours() / 24,
+	}
+
+	readings := prepare(entries, from, to)
+	st.Readings = len(readings)
+	st.ExpectedReadings = int(to.Sub(from) / cadence(readings))
+	if st.ExpectedReadings > 0 {
+		st.CGMActive = math.Min(100, float64(st.Readings)/float64(st.ExpectedReadings)*100)
+	}
+	if len(readings) == 0 {
+		return st
+	}
+
+	values := make([]float64, len(readings))
+	for i, r := range readings {
+		values[i] = r.value
+	}
+
+	st.Mean, st.SD = meanSD(values)
+	st.Median = percentile(values, 50)
+	st.Min, st.Max = int(minOf(values)), int(maxOf(values))
+	if st.Mean > 0 {
+		st.CV = st.SD / st.Mean * 100
+	}
+	st.GMI = 3.31 + 0.02392*st.Mean
+
+	st.Ranges = ranges(values, thresholds)
+	st.ConsensusRanges = ranges(values, ConsensusThresholds())
+	st.GRI = glycemiaRiskIndex(st.ConsensusRanges)
+	st.LBGI, st.HBGI = riskIndices(values)
+	st.MAGE = mage(values, st.SD)
+	st.CONGA1 = conga(readings, time.Hour)
+	st.MODD = modd(readings)
+	st.JIndex = 0.001 * (st.Mean + st.SD) * (st.Mean + st.SD)
+
+	return st
+}
+
+// prepare returns the valid readings in [from, to], sorted and de-duplicated
+func prepare(entries []models.GlucoseEntry, from, to time.Time) []reading {
+	readings := make([]reading, 0, len(entries))
+	for i := range entries {
+		e := &entries[i]
+		t := e.Time()
+		if e.SGV < minValidSGV || e.SGV > maxValidSGV || t.Before(from) || t.After(to) {
+			continue
+		}
+		readings = append(readings, reading{t: t, value: float64(e.SGV)})
+	}
+
+	sort.Slice(readings, func(i, j int) bool { return readings[i].t.Before(readings[j].t) })
+
+	unique := readings[:0]
+	for _, r := range readings {
+		if len(unique) > 0 && r.t.Equal(unique[len(unique)-1].t) {
+			continue
+		}
+		unique = append(unique, r)
+	}
+	return unique
+}
+
+// Cadence estimates the sensor's reading interval from the entries in [from, to]
+func Cadence(entries []models.GlucoseEntry, from, to time.Time) time.Duration {
+	return cadence(prepare(entries, from, to))
+}
+
+// cadence estimates the sensor's reading interval from the median gap
+func cadence(readings []reading) time.Duration {
+	if len(readings) < 3 {
+		return defaultCadence
+	}
+
+	gaps := make([]float64, 0, len(readings)-1)
+	for i := 1; i < len(readings); i++ {
+		gaps = append(gaps, float64(readings[i].t.Sub(readings[i-1].t)))
+	}
+	median := time.Duration(percentile(gaps, 50))
+	if median < 30*time.Second || median > 15*time.Minute {
+		return defaultCadence
+	}
+	return median
+}
+
+// ranges returns the percentage of values in each range. Boundaries follow
+// the consensus: TIR includes both Low and High.
+func ranges(values []float64, th Thresholds) Ranges {
+	var r Ranges
+	for _, v := range values {
+		switch {
+		case v < th.VeryLow:
+			r.TBRLevel2++
+		case v < th.Low:
+			r.TBRLevel1++
+		case v <= th.High:
+			r.TIR++
+		case v <= th.VeryHigh:
+			r.TARLevel1++
+		default:
+			r.TARLevel2++
+		}
+		if v >= tightRangeLow && v <= tightRangeHigh {
+			r.TITR++
+		}
+	}
+
+	n := float64(len(values)) / 100
+	r.TBRLevel2 /= n
+	r.TBRLevel1 /= n
+	r.TIR /= n
+	r.TITR /= n
+	r.TARLevel1 /= n
+	r.TARLevel2 /= n
+	return r
+}
+
+// glycemiaRiskIndex computes the GRI (Klonoff et al. 2022) from consensus ranges
+func glycemiaRiskIndex(r Ranges) GRI {
+	g := GRI{
+		Hypo:  r.TBRLevel2 + 0.8*r.TBRLevel1,
+		Hyper: r.TARLevel2 + 0.5*r.TARLevel1,
+	}
+	g.Value = math.Min(100, 3.0*g.Hypo+1.6*g.Hyper)
+
+	switch {
+	case g.Value <= 20:
+		g.Zone = "A"
+	case g.Value <= 40:
+		g.Zone = "B"
+	case g.Value <= 60:
+		g.Zone = "C"
+	case g.Value <= 80:
+		g.Zone = "D"
+	default:
+		g.Zone = "E"
+	}
+	return g
+}
+
+func meanSD(values []float64) (mean, sd float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	if len(values) < 2 {
+		return mean, 0
+	}
+
+	var sumSq float64
+	for _, v := range values {
+		sumSq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sumSq / float64(len(values)-1))
+}
+
+// percentile returns the p-th percentile (0-100) with linear interpolation
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	pos := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+func minOf(values []float64) float64 {
+	m := values[0]
+	for _, v := range values[1:] {
+		m = math.Min(m, v)
+	}
+	return m
+}
+
+func maxOf(values []float64) float64 {
+	m := values[0]
+	for _, v := range values[1:] {
+		m = math.Max(m, v)
+	}
+	return m
+}
@@ -0,0 +1,135 @@
+package stats
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// riskIndices computes the Low and High Blood Glucose Indices (Kovatchev)
+func riskIndices(values []float64) (lbgi, hbgi float64) {
+	for _, v := range values {
+		f := 1.509 * (math.Pow(math.Log(v), 1.084) - 5.381)
+		risk := 10 * f * f
+		if f < 0 {
+			lbgi += risk
+		} else {
+			hbgi += risk
+		}
+	}
+
+	n := float64(len(values))
+	return lbgi / n, hbgi / n
+}
+
+// mage computes the Mean Amplitude of Glycemic Excursions: the mean size of
+// the rises and falls between turning points that exceed one SD. Turning
+// points are found with a zigzag that ignores swings smaller than sd.
+func mage(values []float64, sd float64) float64 {
+	if len(values) < 3 || sd == 0 {
+		return 0
+	}
+
+	var amplitudes []float64
+	extreme := values[0]
+	lastTurn := values[0]
+	direction := 0 // 1 rising, -1 falling, 0 undecided
+
+	for _, v := range values[1:] {
+		switch direction {
+		case 0:
+			if math.Abs(v-lastTurn) >= sd {
+				direction = 1
+				if v < lastTurn {
+					direction = -1
+				}
+				extreme = v
+			} else if math.Abs(v-lastTurn) > math.Abs(extreme-lastTurn) {
+				extreme = v
+			}
+		case 1:
+			if v > extreme {
+				extreme = v
+			} else if extreme-v >= sd {
+				amplitudes = append(amplitudes, extreme-lastTurn)
+				lastTurn, extreme, direction = extreme, v, -1
+			}
+		case -1:
+			if v < extreme {
+				extreme = v
+			} else if v-extreme >= sd {
+				amplitudes = append(amplitudes, lastTurn-extreme)
+				lastTurn, extreme, direction = extreme, v, 1
+			}
+		}
+	}
+
+	// The final excursion counts once it is large enough
+	if direction != 0 && math.Abs(extreme-lastTurn) >= sd {
+		amplitudes = append(amplitudes, math.Abs(extreme-lastTurn))
+	}
+
+	if len(amplitudes) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, a := range amplitudes {
+		sum += a
+	}
+	return sum / float64(len(amplitudes))
+}
+
+// conga computes the Continuous Overall Net Glycemic Action: the SD of the
+// differences between each reading and the one lag earlier
+func conga(readings []reading, lag time.Duration) float64 {
+	diffs := laggedDiffs(readings, lag)
+	if len(diffs) < 2 {
+		return 0
+	}
+	_, sd := meanSD(diffs)
+	return sd
+}
+
+// modd computes the Mean Of Daily Differences: the mean absolute difference
+// between readings taken at the same time on consecutive days
+func modd(readings []reading) float64 {
+	diffs := laggedDiffs(readings, 24*time.Hour)
+	if len(diffs) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, d := range diffs {
+		sum += math.Abs(d)
+	}
+	return sum / float64(len(diffs))
+}
+
+// laggedDiffs pairs every reading with the one closest to lag earlier, if
+// there is one within matchTolerance, and returns the differences
+func laggedDiffs(readings []reading, lag time.Duration) []float64 {
+	var diffs []float64
+	for _, r := range readings {
+		target := r.t.Add(-lag)
+		i := sort.Search(len(readings), func(i int) bool { return !readings[i].t.Before(target) })
+
+		best := -1
+		bestGap := matchTolerance + 1
+		for _, j := range []int{i - 1, i} {
+			if j < 0 || j >= len(readings) {
+				continue
+			}
+			gap := readings[j].t.Sub(target)
+			if gap < 0 {
+				gap = -gap
+			}
+			if gap < bestGap {
+				best, bestGap = j, gap
+			}
+		}
+		if best >= 0 {
+			diffs = append(diffs, r.value-readings[best].value)
+		}
+	}
+	return diffs
+}
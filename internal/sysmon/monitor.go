@@ -0,0 +1,128 @@
+// Package sysmon detects system sleep/resume and network availability changes
+package sysmon
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultInterval is how often the clocks and network are sampled
+	DefaultInterval = 10 * time.Second
+
+	// clockJumpThreshold is the wall/monotonic divergence treated as a resume
+	clockJumpThreshold = 30 * time.Second
+)
+
+// EventType identifies a system event
+type EventType string
+
+// System event types
+const (
+	EventResume         EventType = "resume"          // System woke from sleep or the wall clock jumped
+	EventNetworkChanged EventType = "network_changed" // Network interfaces or addresses changed
+)
+
+// Event describes a detected system change
+type Event struct {
+	Type EventType
+	// Gap is the time the process was suspended (resume events only)
+	Gap time.Duration
+	// Online reports whether any non-loopback address is available (network events only)
+	Online bool
+}
+
+// Monitor samples clocks and network interfaces to detect sleep and network changes
+type Monitor struct {
+	interval time.Duration
+	// networkState returns a fingerprint of the current network configuration
+	// and whether the machine appears to be online. Replaceable for testing.
+	networkState func() (string, bool)
+}
+
+// NewMonitor creates a monitor that samples every interval
+func NewMonitor(interval time.Duration) *Monitor {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Monitor{
+		interval:     interval,
+		networkState: interfaceFingerprint,
+	}
+}
+
+// Run samples until ctx is cancelled, calling handler for each detected event
+func (m *Monitor) Run(ctx context.Context, handler func(Event)) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	lastNet, _ := m.networkState()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+
+		// The monotonic clock stops while the system is suspended but the wall
+		// clock keeps going, so their difference is the time spent asleep.
+		// A tick delivered far too late (process frozen) counts as well.
+		monoElapsed := now.Sub(last)
+		wallElapsed := now.Round(0).Sub(last.Round(0))
+		last = now
+
+		gap := wallElapsed - monoElapsed
+		if gap < 0 {
+			gap = -gap
+		}
+		if late := monoElapsed - m.interval; late > gap {
+			gap = late
+		}
+		if gap > clockJumpThreshold {
+			handler(Event{Type: EventResume, Gap: gap})
+		}
+
+		if fp, online := m.networkState(); fp != lastNet {
+			lastNet = fp
+			handler(Event{Type: EventNetworkChanged, Online: online})
+		}
+	}
+}
+
+// interfaceFingerprint summarizes the addresses of all interfaces that are up
+func interfaceFingerprint() (string, bool) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", false
+	}
+
+	var parts []string
+	online := false
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			online = true
+			parts = append(parts, iface.Name+"="+ipNet.String())
+		}
+	}
+
+	sort.Strings(parts)
+	return strings.Join(parts, ","), online
+}
@@ -165,11 +165,16 @@ func (g *IconGenerator) generateCompactSparkline() string {
 }
 
 func (g *IconGenerator) generateMultiLineSparkline() string {
-	if len(g.history) < 2 {
+	return RenderSparkline(g.history, 10)
+}
+
+// RenderSparkline draws values as a multi-line braille chart with min/max labels.
+// It is shared by the tray tooltip and the command-line chart.
+func RenderSparkline(values []float64, height int) string {
+	if len(values) < 2 || height < 1 {
 		return ""
 	}
-	height := 10
-	minVal, maxVal := g.getMinMax()
+	minVal, maxVal := getMinMax(values)
 	buffer := 10.0
 	minVal = math.Max(0, minVal-buffer)
 	maxVal += buffer
@@ -178,19 +183,16 @@ func (g *IconGenerator) generateMultiLineSparkline() string {
 	blocks := []rune{'⠀', '⣀', '⣤', '⣶', '⣿'}
 	subBlocksPerLine := 4.0
 
-
-rows := make([][]rune, height)
-	width := len(g.history)
+	rows := make([][]rune, height)
+	width := len(values)
 	for i := 0; i < height; i++ {
-	
-rows[i] = make([]rune, width)
+		rows[i] = make([]rune, width)
 		for j := 0; j < width; j++ {
-		
-rows[i][j] = '⠀'
+			rows[i][j] = '⠀'
 		}
 	}
 
-	for x, val := range g.history {
+	for x, val := range values {
 		normalized := (val - minVal) / rangeVal
 		totalSubBlocks := normalized * float64(height) * subBlocksPerLine
 		for y := 0; y < height; y++ {
@@ -198,14 +200,16 @@ rows[i][j] = '⠀'
 			lineStart := float64(y) * subBlocksPerLine
 			lineEnd := float64(y+1) * subBlocksPerLine
 			if totalSubBlocks >= lineEnd {
-			
-rows[lineIdx][x] = '⣿'
+				rows[lineIdx][x] = '⣿'
 			} else if totalSubBlocks > lineStart {
 				remainder := int(math.Round(totalSubBlocks - lineStart))
-				if remainder < 0 { remainder = 0 }
-				if remainder >= len(blocks) { remainder = len(blocks) - 1 }
-			
-rows[lineIdx][x] = blocks[remainder]
+				if remainder < 0 {
+					remainder = 0
+				}
+				if remainder >= len(blocks) {
+					remainder = len(blocks) - 1
+				}
+				rows[lineIdx][x] = blocks[remainder]
 			}
 		}
 	}
@@ -222,9 +226,13 @@ rows[lineIdx][x] = blocks[remainder]
 }
 
 func (g *IconGenerator) getMinMax() (float64, float64) {
-	if len(g.history) == 0 { return 0, 0 }
-	minV, maxV := g.history[0], g.history[0]
-	for _, v := range g.history {
+	return getMinMax(g.history)
+}
+
+func getMinMax(values []float64) (float64, float64) {
+	if len(values) == 0 { return 0, 0 }
+	minV, maxV := values[0], values[0]
+	for _, v := range values {
 		if v < minV { minV = v }
 		if v > maxV { maxV = v }
 	}
@@ -0,0 +1,176 @@
+// Package webhooks delivers outbound webhook notifications for readings and alerts
+package webhooks
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// maxDeadLettersPerTarget bounds the size of each target's dead-letter log
+const maxDeadLettersPerTarget = 500
+
+// DeadLetter records a delivery that could not be completed
+type DeadLetter struct {
+	DeliveryID string    `json:"deliveryId"`
+	TargetID   string    `json:"targetId"`
+	TargetName string    `json:"targetName"`
+	URL        string    `json:"url"`
+	Event      string    `json:"event"`
+	Attempts   int       `json:"attempts"`
+	Error      string    `json:"error"`
+	FailedAt   time.Time `json:"failedAt"`
+	Payload    Payload   `json:"payload"`
+}
+
+// DeadLetterLog stores failed deliveries as one JSON-lines file per target
+type DeadLetterLog struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewDeadLetterLog creates a dead-letter log in dir
+func NewDeadLetterLog(dir string) *DeadLetterLog {
+	return &DeadLetterLog{dir: dir}
+}
+
+var unsafeIDChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+func (l *DeadLetterLog) path(targetID string) string {
+	id := unsafeIDChars.ReplaceAllString(targetID, "_")
+	if id == "" {
+		id = "default"
+	}
+	return filepath.Join(l.dir, id+".deadletter.jsonl")
+}
+
+// Append records a failed delivery
+func (l *DeadLetterLog) Append(job delivery, attempts int, cause error) {
+	entry := DeadLetter{
+		DeliveryID: job.id,
+		TargetID:   job.target.ID,
+		TargetName: job.target.Name,
+		URL:        job.target.URL,
+		Event:      job.event,
+		Attempts:   attempts,
+		FailedAt:   time.Now(),
+		Payload:    job.payload,
+	}
+	if cause != nil {
+		entry.Error = cause.Error()
+	}
+
+	if err := l.write(entry); err != nil {
+		logger.Error("writing webhook dead letter failed", "error", err)
+	}
+}
+
+func (l *DeadLetterLog) write(entry DeadLetter) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.dir == "" {
+		return fmt.Errorf("no dead-letter directory configured")
+	}
+	if err := os.MkdirAll(l.dir, 0750); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	path := l.path(entry.TargetID)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600) //nolint:gosec // Path is derived from the app config dir
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return l.trim(path)
+}
+
+// trim keeps only the most recent maxDeadLettersPerTarget entries
+func (l *DeadLetterLog) trim(path string) error {
+	entries, err := readLines(path)
+	if err != nil || len(entries) <= maxDeadLettersPerTarget {
+		return err
+	}
+
+	entries = entries[len(entries)-maxDeadLettersPerTarget:]
+	var out []byte
+	for _, e := range entries {
+		out = append(out, e...)
+		out = append(out, '\n')
+	}
+	return os.WriteFile(path, out, 0600)
+}
+
+// Read returns all dead letters for a target, oldest first
+func (l *DeadLetterLog) Read(targetID string) ([]DeadLetter, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lines, err := readLines(l.path(targetID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []DeadLetter{}, nil
+		}
+		return nil, err
+	}
+
+	result := make([]DeadLetter, 0, len(lines))
+	for _, line := range lines {
+		var dl DeadLetter
+		if err := json.Unmarshal(line, &dl); err != nil {
+			continue
+		}
+		result = append(result, dl)
+	}
+	return result, nil
+}
+
+// Clear deletes the dead-letter log for a target
+func (l *DeadLetterLog) Clear(targetID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := os.Remove(l.path(targetID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func readLines(path string) ([][]byte, error) {
+	f, err := os.Open(path) //nolint:gosec // Path is derived from the app config dir
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := append([]byte(nil), scanner.Bytes()...)
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
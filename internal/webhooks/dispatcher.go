@@ -0,0 +1,356 @@
+// Package webhooks delivers outbound webhook notifications for readings and alerts
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/mrcode/nightscout-tray/internal/logging"
+	"github.com/mrcode/nightscout-tray/internal/models"
+)
+
+const (
+	// queueSize is the number of pending deliveries buffered before new events are dropped
+	queueSize = 256
+
+	// workers is the number of deliveries in flight at once
+	workers = 4
+
+	// userAgent identifies the app to webhook receivers
+	userAgent = "nightscout-tray-webhook/1"
+)
+
+// retryDelays is the backoff between delivery attempts (first attempt is immediate)
+var retryDelays = []time.Duration{
+	2 * time.Second,
+	10 * time.Second,
+	1 * time.Minute,
+	5 * time.Minute,
+}
+
+// Event describes something that happened and may trigger webhooks
+type Event struct {
+	Type       string                   // models.WebhookEventReading or models.WebhookEventAlert
+	AlertType  string                   // Alert type for alert events ("urgent_low", "low", ...)
+	Status     *models.GlucoseStatus    // Current glucose status
+	Prediction *models.PredictionResult // Latest prediction, may be nil
+	Unit       string                   // Display unit selected by the user
+	Time       time.Time                // When the event occurred
+}
+
+// Payload is the default JSON body and the data passed to body templates
+type Payload struct {
+	Event      string                   `json:"event"`
+	AlertType  string                   `json:"alertType,omitempty"`
+	Timestamp  time.Time                `json:"timestamp"`
+	Unit       string                   `json:"unit"`
+	Glucose    *models.GlucoseStatus    `json:"glucose,omitempty"`
+	Prediction *models.PredictionResult `json:"prediction,omitempty"`
+}
+
+// delivery is a single queued webhook request
+type delivery struct {
+	id      string
+	target  models.WebhookTarget
+	event   string
+	payload Payload
+}
+
+// Dispatcher delivers events to the configured webhook targets asynchronously
+type Dispatcher struct {
+	mu         sync.RWMutex
+	targets    []models.WebhookTarget
+	deadLetter *DeadLetterLog
+	httpClient *http.Client
+
+	queue chan delivery
+}
+
+// NewDispatcher creates a dispatcher writing dead letters to dir.
+// Events are queued until Run is started.
+func NewDispatcher(dir string) *Dispatcher {
+	return &Dispatcher{
+		deadLetter: NewDeadLetterLog(dir),
+		httpClient: &http.Client{
+			Timeout: 15 * time.Second,
+		},
+		queue: make(chan delivery, queueSize),
+	}
+}
+
+// UpdateTargets replaces the set of webhook targets
+func (d *Dispatcher) UpdateTargets(targets []models.WebhookTarget) {
+	copied := make([]models.WebhookTarget, len(targets))
+	for i, t := range targets {
+		copied[i] = t.Clone()
+	}
+
+	d.mu.Lock()
+	d.targets = copied
+	d.mu.Unlock()
+}
+
+// Dispatch queues an event for every target subscribed to it. It never blocks;
+// if the queue is full the delivery is written straight to the dead-letter log.
+func (d *Dispatcher) Dispatch(ev Event) {
+	d.mu.RLock()
+	targets := d.targets
+	d.mu.RUnlock()
+
+	if len(targets) == 0 {
+		return
+	}
+
+	payload := buildPayload(ev)
+	for _, t := range targets {
+		if !t.Accepts(ev.Type, ev.AlertType) {
+			continue
+		}
+
+		job := delivery{
+			id:      newDeliveryID(),
+			target:  t,
+			event:   ev.Type,
+			payload: payload,
+		}
+
+		select {
+		case d.queue <- job:
+		default:
+			d.deadLetter.Append(job, 0, fmt.Errorf("delivery queue full"))
+		}
+	}
+}
+
+// SendTest synchronously delivers a single test event to target, without retries
+func (d *Dispatcher) SendTest(target models.WebhookTarget, ev Event) error {
+	job := delivery{
+		id:      newDeliveryID(),
+		target:  target,
+		event:   ev.Type,
+		payload: buildPayload(ev),
+	}
+	return d.send(context.Background(), job)
+}
+
+// DeadLetters returns the failed deliveries recorded for a target
+func (d *Dispatcher) DeadLetters(targetID string) ([]DeadLetter, error) {
+	return d.deadLetter.Read(targetID)
+}
+
+// ClearDeadLetters removes the dead-letter log for a target
+func (d *Dispatcher) ClearDeadLetters(targetID string) error {
+	return d.deadLetter.Clear(targetID)
+}
+
+// Run delivers queued events until ctx is cancelled. On return, deliveries
+// still queued or waiting for a retry have been written to the dead-letter log.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.work(ctx)
+		}()
+	}
+	wg.Wait()
+
+	for {
+		select {
+		case job := <-d.queue:
+			d.deadLetter.Append(job, 0, fmt.Errorf("shutdown before delivery"))
+		default:
+			return
+		}
+	}
+}
+
+// work delivers jobs from the queue one at a time until ctx is cancelled
+func (d *Dispatcher) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-d.queue:
+			if ctx.Err() != nil {
+				d.deadLetter.Append(job, 0, fmt.Errorf("shutdown before delivery"))
+				return
+			}
+			d.deliver(ctx, job)
+		}
+	}
+}
+
+// deliver sends a job, retrying with backoff until it succeeds or attempts run
+// out. Failures a retry cannot fix are dead-lettered immediately.
+func (d *Dispatcher) deliver(ctx context.Context, job delivery) {
+	var err error
+	attempts := 0
+	for {
+		attempts++
+		if err = d.send(ctx, job); err == nil {
+			return
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) || attempts > len(retryDelays) {
+			break
+		}
+
+		logger.Warn("webhook delivery failed", "target", job.target.Name, "attempt", attempts, "error", err)
+
+		select {
+		case <-time.After(retryDelays[attempts-1]):
+		case <-ctx.Done():
+			d.deadLetter.Append(job, attempts, fmt.Errorf("shutdown before retry: %w", err))
+			return
+		}
+	}
+
+	d.deadLetter.Append(job, attempts, err)
+}
+
+// send performs a single HTTP delivery attempt
+func (d *Dispatcher) send(ctx context.Context, job delivery) error {
+	body, err := renderBody(job.target.BodyTemplate, job.payload)
+	if err != nil {
+		return &permanentError{err: err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", job.target.URL, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err: fmt.Errorf("building request: %w", err)}
+	}
+
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("X-Webhook-Event", job.event)
+	req.Header.Set("X-Webhook-Delivery", job.id)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if job.target.Secret != "" {
+		req.Header.Set("X-Webhook-Signature", "sha256="+sign(job.target.Secret, timestamp, body))
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := fmt.Errorf("webhook returned status %d", resp.StatusCode)
+		if retryableStatus(resp.StatusCode) {
+			return err
+		}
+		return &permanentError{err: err}
+	}
+
+	return nil
+}
+
+// permanentError marks a delivery failure that retrying will not fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// retryableStatus reports whether a failed delivery with this HTTP status
+// may succeed later. Other 4xx responses mean the request itself is rejected.
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return code < 400 || code >= 500
+}
+
+// sign computes the HMAC-SHA256 signature over "<timestamp>.<body>"
+func sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func buildPayload(ev Event) Payload {
+	ts := ev.Time
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	return Payload{
+		Event:      ev.Type,
+		AlertType:  ev.AlertType,
+		Timestamp:  ts,
+		Unit:       ev.Unit,
+		Glucose:    ev.Status,
+		Prediction: ev.Prediction,
+	}
+}
+
+// renderBody produces the request body, using the target's template if one is set
+func renderBody(tmpl string, payload Payload) ([]byte, error) {
+	if tmpl == "" {
+		return json.Marshal(payload)
+	}
+
+	t, err := template.New("body").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Option("missingkey=zero").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("parsing body template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, payload); err != nil {
+		return nil, fmt.Errorf("rendering body template: %w", err)
+	}
+
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("body template did not produce valid JSON")
+	}
+
+	return buf.Bytes(), nil
+}
+
+func newDeliveryID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// ValidateTemplate checks that a body template parses and renders valid JSON for a sample payload
+func ValidateTemplate(tmpl string) error {
+	_, err := renderBody(tmpl, Payload{
+		Event:     models.WebhookEventReading,
+		Timestamp: time.Now(),
+		Unit:      "mg/dL",
+		Glucose:   &models.GlucoseStatus{Value: 120, ValueMmol: 6.7, Trend: "→", Direction: "Flat", Status: "normal", Time: time.Now()},
+	})
+	return err
+}
+
+var logger = logging.For(logging.ComponentWebhooks)
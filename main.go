@@ -2,10 +2,16 @@ package main
 
 import (
 	"embed"
-	"log"
+	"errors"
+	"fmt"
+	"os"
 	"time"
 
 	"github.com/mrcode/nightscout-tray/internal/app"
+	"github.com/mrcode/nightscout-tray/internal/cli"
+	"github.com/mrcode/nightscout-tray/internal/instance"
+	"github.com/mrcode/nightscout-tray/internal/logging"
+	"github.com/mrcode/nightscout-tray/internal/models"
 	"github.com/wailsapp/wails/v3/pkg/application"
 )
 
@@ -16,11 +22,44 @@ var assets embed.FS
 var appIcon []byte
 
 func main() {
+	args := os.Args[1:]
+
+	// Subcommands and headless mode run without any window
+	if cli.IsCommand(args) {
+		os.Exit(cli.Run(args))
+	}
+
+	// Only one instance may run; later launches forward their flags to it
+	controlReq, hasControl := instance.ParseArgs(args)
+	var inst *instance.Instance
+	if configDir, err := models.GetConfigDir(); err == nil {
+		inst, err = instance.Acquire(configDir)
+		if errors.Is(err, instance.ErrAlreadyRunning) {
+			if !hasControl {
+				controlReq = instance.Request{Command: instance.CommandShow}
+			}
+			os.Exit(cli.Forward(controlReq))
+		}
+		if err != nil {
+			logger.Warn("single-instance lock unavailable", "error", err)
+		}
+	}
+
+	// Commands for a running instance must not start a new one
+	if hasControl && controlReq.Command != instance.CommandSnooze && controlReq.Command != instance.CommandShow {
+		if inst != nil {
+			_ = inst.Close()
+		}
+		fmt.Fprintln(os.Stderr, "Nightscout Tray is not running")
+		os.Exit(1)
+	}
+
 	nsService := app.NewNightscoutService()
 
 	wailsApp := application.New(application.Options{
 		Name:        "Nightscout Tray",
 		Description: "Nightscout CGM Monitoring",
+		Logger:      logging.For(logging.ComponentWails),
 		Services: []application.Service{
 			application.NewService(nsService),
 		},
@@ -79,9 +118,12 @@ func main() {
 
 	// Create tray menu
 	trayMenu := application.NewMenu()
-	trayMenu.Add("Show Dashboard").OnClick(func(_ *application.Context) {
+	showDashboard := func() {
 		mainWindow.Show()
 		mainWindow.Focus()
+	}
+	trayMenu.Add("Show Dashboard").OnClick(func(_ *application.Context) {
+		showDashboard()
 	})
 	trayMenu.AddSeparator()
 	trayMenu.Add("Quit").OnClick(func(_ *application.Context) {
@@ -107,7 +149,7 @@ func main() {
 
 	// Left click opens main dashboard
 	tray.OnClick(func() {
-		if mainWindow.IsHidden() {
+		if !mainWindow.IsVisible() {
 			mainWindow.Show()
 			mainWindow.Focus()
 		}
@@ -117,9 +159,28 @@ func main() {
 	nsService.SetTray(tray)
 	nsService.SetApp(wailsApp)
 
+	// Accept control commands from later launches
+	if inst != nil {
+		handler := cli.ControlHandler(nsService, showDashboard, wailsApp.Quit)
+		inst.SetHandler(handler)
+		defer func() {
+			_ = inst.Close()
+		}()
+
+		// Flags given to the first launch apply to this instance
+		if hasControl {
+			if resp := handler(controlReq); resp.Message != "" {
+				logger.Info("applied startup command", "command", controlReq.Command, "result", resp.Message)
+			}
+		}
+	}
+
 	// Start the app
 	err := wailsApp.Run()
 	if err != nil {
-		log.Fatal(err)
+		logger.Error("application stopped", "error", err)
+		os.Exit(1)
 	}
 }
+
+var logger = logging.For(logging.ComponentApp)